@@ -2,51 +2,335 @@ package aof
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
 	"io"
 	"os"
-	"strconv"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/IAmRiteshKoushik/bluedis/resp"
 )
 
+// Defaults for the automatic rewrite trigger, the same as Redis uses
+const (
+	DefaultRewritePercentage = 100
+	DefaultRewriteMinSize    = 64 * 1024 * 1024
+)
+
+// Policies for flushing the AOF to disk, as set by appendfsync
+const (
+	FsyncAlways   = "always"   // After every write, before the client gets its reply
+	FsyncEverySec = "everysec" // Once per second, from a background goroutine
+	FsyncNo       = "no"       // Whenever the operating system decides to
+)
+
+// How often the everysec policy flushes the file
+const fsyncInterval = time.Second
+
+var ErrRewriteInProgress = errors.New("ERR Background append only file rewriting already in progress")
+
+// BaseFormat is the format a rewrite writes the base file in.
+type BaseFormat int
+
+const (
+	BaseAOF BaseFormat = iota // Commands, the same as in the incr files
+	BaseRDB                   // A snapshot, the same as SAVE writes
+)
+
+// Aof is an append only file split over several files in one directory, the
+// way Redis 7 lays it out: a base file holding the dataset as of the last
+// rewrite, incr files holding the commands written since, and a manifest
+// listing them in order. Writes only ever go to the last incr file. A rewrite
+// starts a new incr file and replaces the base and the incr files before it,
+// so it never has to touch the file that is being appended to.
 type Aof struct {
-	file *os.File      // Hold the file descriptor
-	rd   *bufio.Reader // Read RESP commands for the file for reconstruction
-	mu   sync.Mutex
+	dir      string    // Holds the manifest and every file it lists
+	name     string    // Prefix of the file names, from appendfilename
+	manifest *Manifest // As last written to disk
+	file     *os.File  // The last incr file, the one commands are appended to
+	mu       sync.Mutex
+
+	fsync string // One of the Fsync* policies
+	dirty bool   // Written to since the last fsync
+
+	// Files replaced by a rewrite get moved to the archive directory rather
+	// than deleted
+	archive bool
+
+	// Stops the background syncer on Close
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+
+	// While a rewrite is in progress, rewriteIncr is the incr file that was
+	// started along with it: the files before it are what the rewrite replaces
+	rewriting   bool
+	rewriteIncr string
+
+	currentSize int64 // Size of the base and every incr file together
+	baseSize    int64 // Size of the base file
+
+	// Outcome of the past rewrites, reported by INFO
+	rewrites            int64
+	lastRewriteErr      error
+	lastRewriteDuration time.Duration
+
+	// An automatic rewrite starts once the AOF is at least rewriteMinSize
+	// bytes and has grown by rewritePercentage percent since the last
+	// rewrite. A percentage of 0 disables automatic rewrites.
+	rewritePercentage int
+	rewriteMinSize    int64
 }
 
-func NewAof(path string) (*Aof, error) {
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0666)
-	if err != nil {
+// RewriteInfo describes the state of AOF rewrites for INFO.
+type RewriteInfo struct {
+	InProgress          bool
+	Rewrites            int64
+	LastErr             error
+	LastRewriteDuration time.Duration
+	CurrentSize         int64
+	BaseSize            int64
+}
+
+// NewAof opens the AOF kept in dir, whose files are all named after name. The
+// directory and an empty AOF are created when there is none yet. An AOF from
+// before the manifest existed, a single file called name next to dir, becomes
+// the base of the new one.
+func NewAof(dir, name string) (*Aof, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, err
 	}
 
 	aof := &Aof{
-		file: f,
-		rd:   bufio.NewReader(f),
+		dir:               dir,
+		name:              name,
+		fsync:             FsyncEverySec,
+		done:              make(chan struct{}),
+		rewritePercentage: DefaultRewritePercentage,
+		rewriteMinSize:    DefaultRewriteMinSize,
+	}
+
+	m, err := ReadManifest(ManifestPath(dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		m, err = aof.upgrade()
 	}
+	if err != nil {
+		return nil, err
+	}
+	aof.manifest = m
+
+	if len(m.Incrs) == 0 {
+		err = aof.openNewIncr()
+	} else {
+		// O_APPEND keeps writes at the end of the file whatever happens to
+		// the offset
+		last := m.Incrs[len(m.Incrs)-1]
+		aof.file, err = os.OpenFile(filepath.Join(dir, last.Name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	}
+	if err != nil {
+		return nil, err
+	}
+	aof.updateSizes()
+
+	// The syncer runs for the lifetime of the AOF whatever the policy, and
+	// only does something while the policy is everysec. That way switching
+	// policies at runtime does not need to start or stop goroutines.
+	aof.wg.Add(1)
+	go aof.syncer()
+
+	return aof, nil
+}
+
+// upgrade returns the manifest for a directory that does not have one yet.
+// A single file AOF left by an older version is moved into the directory and
+// becomes the base.
+func (aof *Aof) upgrade() (*Manifest, error) {
+	m := &Manifest{}
+
+	legacy := filepath.Join(filepath.Dir(aof.dir), aof.name)
+	info, err := os.Stat(legacy)
+	if err != nil || !info.Mode().IsRegular() || info.Size() == 0 {
+		return m, nil
+	}
+
+	base := ManifestEntry{Name: baseFileName(aof.name, 1, BaseAOF), Seq: 1, Type: TypeBase}
+	path := filepath.Join(aof.dir, base.Name)
+	if err := os.Rename(legacy, path); err != nil {
+		return nil, err
+	}
+	m.Base = &base
+	if err := writeManifest(aof.dir, aof.name, m); err != nil {
+		os.Rename(path, legacy)
+		return nil, err
+	}
+	fmt.Printf("Moved the AOF %s into %s\n", legacy, aof.dir)
+	return m, nil
+}
+
+// openNewIncr starts a new incr file and makes it the one being written to.
+// The previous one is synced and closed. The caller holds the lock, unless
+// the AOF is still being opened.
+func (aof *Aof) openNewIncr() error {
+	m := aof.manifest.clone()
+	seq := m.nextIncrSeq()
+	entry := ManifestEntry{Name: incrFileName(aof.name, seq), Seq: seq, Type: TypeIncr}
+
+	// A file of that name can only be left over from a crash before it made
+	// it into the manifest, so nothing in it counts
+	path := filepath.Join(aof.dir, entry.Name)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+
+	m.Incrs = append(m.Incrs, entry)
+	if err := writeManifest(aof.dir, aof.name, m); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+
+	if aof.file != nil {
+		if err := aof.file.Sync(); err != nil {
+			fmt.Println("Error syncing the AOF:", err)
+		}
+		aof.file.Close()
+	}
+	aof.manifest = m
+	aof.file = f
+	aof.dirty = false
+	return nil
+}
 
-	// At the time of initialization, we spawn a goroutine which syncs the AOF
-	// to disk every 1 second. If we have not setup 1 second then the program
-	// becomes OS dependent on when to flush the file contents to the disk but as
-	// we have setup 1 second, we can lose data only within this span in the worst
-	// case scenario.
-	go func() {
-		for {
+// removeHistory deletes the files a rewrite replaced, or moves them to the
+// archive directory, and drops them from the manifest. Files that cannot be
+// removed stay listed, to be tried again next time. The caller holds the
+// lock, unless the AOF is still being opened.
+func (aof *Aof) removeHistory() {
+	if len(aof.manifest.History) == 0 {
+		return
+	}
+
+	archiveDir := filepath.Join(aof.dir, "archive")
+	var kept []ManifestEntry
+	for _, entry := range aof.manifest.History {
+		path := filepath.Join(aof.dir, entry.Name)
+		var err error
+		if aof.archive {
+			if err = os.MkdirAll(archiveDir, 0755); err == nil {
+				err = os.Rename(path, filepath.Join(archiveDir, entry.Name))
+			}
+		} else {
+			err = os.Remove(path)
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("Error removing the AOF history file %s: %v\n", entry.Name, err)
+			kept = append(kept, entry)
+		}
+	}
+
+	m := aof.manifest.clone()
+	m.History = kept
+	if err := writeManifest(aof.dir, aof.name, m); err != nil {
+		fmt.Println("Error writing the AOF manifest:", err)
+		return
+	}
+	aof.manifest = m
+}
+
+// updateSizes recomputes the sizes reported by RewriteInfo from the files on
+// disk. The caller holds the lock, unless the AOF is still being opened.
+func (aof *Aof) updateSizes() {
+	size := func(name string) int64 {
+		info, err := os.Stat(filepath.Join(aof.dir, name))
+		if err != nil {
+			return 0
+		}
+		return info.Size()
+	}
+
+	aof.baseSize = 0
+	if aof.manifest.Base != nil {
+		aof.baseSize = size(aof.manifest.Base.Name)
+	}
+	aof.currentSize = aof.baseSize
+	for _, entry := range aof.manifest.Incrs {
+		aof.currentSize += size(entry.Name)
+	}
+}
+
+// syncer flushes the file to disk once a second under the everysec policy. At
+// most the last second of writes can be lost that way, without paying for an
+// fsync on every write the way the always policy does.
+func (aof *Aof) syncer() {
+	defer aof.wg.Done()
+
+	ticker := time.NewTicker(fsyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-aof.done:
+			return
+		case <-ticker.C:
 			aof.mu.Lock()
-			aof.file.Sync()
+			if aof.fsync == FsyncEverySec && aof.dirty {
+				if err := aof.file.Sync(); err != nil {
+					fmt.Println("Error syncing the AOF:", err)
+				} else {
+					aof.dirty = false
+				}
+			}
 			aof.mu.Unlock()
-			time.Sleep(time.Second)
 		}
-	}()
+	}
+}
 
-	// Alternate approach is to sync the file everytime a command is successfully
-	// executed but this can result in poor performance for write operations
-	// because IO ops are expensive.
+// SetFsyncPolicy changes when the AOF gets flushed to disk. It can be called at
+// any time, and switching to always flushes whatever is pending right away.
+func (aof *Aof) SetFsyncPolicy(policy string) error {
+	switch policy {
+	case FsyncAlways, FsyncEverySec, FsyncNo:
+	default:
+		return fmt.Errorf("invalid fsync policy '%s'", policy)
+	}
 
-	return aof, nil
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	aof.fsync = policy
+	if policy == FsyncAlways && aof.dirty {
+		if err := aof.file.Sync(); err != nil {
+			return err
+		}
+		aof.dirty = false
+	}
+	return nil
+}
+
+// SetRewriteThreshold changes when an automatic rewrite is due, see
+// NeedsRewrite.
+func (aof *Aof) SetRewriteThreshold(percentage int, minSize int64) {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	aof.rewritePercentage = percentage
+	aof.rewriteMinSize = minSize
+}
+
+// SetArchiveHistory chooses whether the files replaced by a rewrite are kept
+// in the archive directory instead of being deleted. Files still waiting to
+// be removed, because a crash cut the last rewrite short, are dealt with
+// right away.
+func (aof *Aof) SetArchiveHistory(archive bool) {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	aof.archive = archive
+	aof.removeHistory()
 }
 
 func (aof *Aof) Close() error {
@@ -54,11 +338,18 @@ func (aof *Aof) Close() error {
 	// the lock then we can run into problems where some garbage value gets
 	// written as a race condition between server shutting down and a goroutine
 	// trying to write to the AOF
+	aof.closeOnce.Do(func() { close(aof.done) })
+	aof.wg.Wait()
 
 	aof.mu.Lock()
 	defer aof.mu.Unlock()
 
-	return aof.file.Close()
+	// Whatever the policy, a clean shutdown must not lose anything
+	syncErr := aof.file.Sync()
+	if err := aof.file.Close(); err != nil {
+		return err
+	}
+	return syncErr
 }
 
 func (aof *Aof) Write(value resp.Value) error {
@@ -68,72 +359,366 @@ func (aof *Aof) Write(value resp.Value) error {
 	// We are writing to the AOF file in RESP format using the Marshal() method
 	// so that if we have to reconstruct then we can run all the commands of that
 	// file in a loop without any pre-processing requirement
-	_, err := aof.file.Write(value.Marshal())
+	data := value.Marshal()
+	n, err := aof.file.Write(data)
 	if err != nil {
+		// Half a command would leave the file unreadable from there on, so
+		// whatever part of it made it in is cut off again
+		if n > 0 {
+			aof.rollback(int64(n))
+		}
 		return err
 	}
+	aof.currentSize += int64(n)
+
+	aof.dirty = true
+
+	// Under the always policy the write has to be on disk before the command
+	// is acknowledged, which is why this happens here rather than in the
+	// background
+	if aof.fsync == FsyncAlways {
+		if err := aof.file.Sync(); err != nil {
+			return err
+		}
+		aof.dirty = false
+	}
 
 	return nil
 }
 
-func (aof *Aof) Read(callback func(value resp.Value)) error {
+// rollback drops the last n bytes of the file, written by a write that failed
+// half way through. The file is opened for appending, so the next write goes
+// to the new end. The caller holds the lock.
+func (aof *Aof) rollback(n int64) {
+	info, err := aof.file.Stat()
+	if err == nil {
+		err = aof.file.Truncate(info.Size() - n)
+	}
+	if err != nil {
+		fmt.Println("Error removing a partial write from the AOF:", err)
+	}
+}
+
+// Load replays the AOF, one file at a time in manifest order. A base file in
+// snapshot format is handed to loadSnapshot; every command in the other files
+// is passed to callback. If a file turns out to be damaged, the commands
+// before the damage are replayed and a *CorruptError is returned.
+func (aof *Aof) Load(loadSnapshot func(r io.Reader) error, callback func(value resp.Value)) error {
 	aof.mu.Lock()
-	defer aof.mu.Unlock()
+	files := aof.manifest.Files()
+	aof.mu.Unlock()
+
+	for _, entry := range files {
+		if err := aof.loadFile(entry, loadSnapshot, callback); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (aof *Aof) loadFile(entry ManifestEntry, loadSnapshot func(r io.Reader) error, callback func(value resp.Value)) error {
+	f, err := os.Open(filepath.Join(aof.dir, entry.Name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if entry.IsSnapshot() {
+		if err := loadSnapshot(f); err != nil {
+			return fmt.Errorf("%s: %w", entry.Name, err)
+		}
+		return nil
+	}
+
+	_, err = scan(f, callback)
+	var corrupt *CorruptError
+	if errors.As(err, &corrupt) {
+		corrupt.File = entry.Name
+	}
+	return err
+}
+
+// CorruptError describes the first record of an AOF that could not be read.
+// Everything before Offset is made of complete, well formed commands.
+type CorruptError struct {
+	File      string // Which file of the AOF, when it has several
+	Offset    int64  // Where the bad record starts
+	Truncated bool   // The file ends in the middle of the record
+	Err       error
+}
+
+func (e *CorruptError) Error() string {
+	where := fmt.Sprintf("at offset %d", e.Offset)
+	if e.File != "" {
+		where = fmt.Sprintf("in %s at offset %d", e.File, e.Offset)
+	}
+	if e.Truncated {
+		return "unexpected end of file " + where
+	}
+	return fmt.Sprintf("bad file format %s: %v", where, e.Err)
+}
+
+func (e *CorruptError) Unwrap() error {
+	return e.Err
+}
+
+// countingReader keeps track of how many bytes have been read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
 
-	aof.file.Seek(0, io.SeekStart)
-	resp := resp.NewResp(aof.file)
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
 
+// scan reads commands from r until the end, calling callback for each one. It
+// returns the number of commands read, and a *CorruptError if it came across
+// something that is not a complete command: the tail of a write cut short by
+// a crash, for instance.
+//
+// A transaction is logged between MULTI and EXEC and only counts as a whole:
+// when the file ends before its EXEC, the damage reported starts at its MULTI.
+func scan(r io.Reader, callback func(value resp.Value)) (int, error) {
+	cr := &countingReader{r: r}
+	rd := resp.NewResp(cr)
+
+	commands := 0
+	var offset int64     // End of the last complete command
+	var multi int64 = -1 // Start of the open transaction, if any
 	for {
-		value, err := resp.Read()
+		value, err := rd.Read()
+		consumed := cr.n - int64(rd.Buffered())
 		if err != nil {
-			if err == io.EOF {
-				break
+			if errors.Is(err, io.EOF) && consumed == offset && multi < 0 {
+				return commands, nil // Clean end of file
 			}
-			return err
+			truncated := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+			if truncated && multi >= 0 {
+				offset = multi
+			}
+			return commands, &CorruptError{Offset: offset, Truncated: truncated, Err: err}
 		}
+		if !isCommand(value) {
+			return commands, &CorruptError{Offset: offset, Err: errors.New("expected a command")}
+		}
+
+		switch strings.ToUpper(value.Array[0].Bulk) {
+		case "MULTI":
+			multi = offset
+		case "EXEC":
+			multi = -1
+		}
+
 		callback(value)
+		commands++
+		offset = consumed
 	}
+}
 
-	return nil
+// isCommand reports whether value has the shape of a command: a non-empty
+// array of bulk strings.
+func isCommand(value resp.Value) bool {
+	if value.Typ != "array" || len(value.Array) == 0 {
+		return false
+	}
+	for _, arg := range value.Array {
+		if arg.Typ != "bulk" {
+			return false
+		}
+	}
+	return true
 }
 
-func (aof *Aof) WriteExpire(key string, ttl int, condition string) error {
-	args := []resp.Value{
-		{Typ: "bulk", Bulk: "EXPIRE"},
-		{Typ: "bulk", Bulk: key},
-		{Typ: "bulk", Bulk: strconv.Itoa(ttl)},
+// Check validates the AOF at path without loading it. It returns the number
+// of valid commands and the size of the file. The error is a *CorruptError if
+// the file is damaged.
+func Check(path string) (commands int, size int64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	commands, err = scan(f, func(resp.Value) {})
+	return commands, info.Size(), err
+}
+
+// Truncate cuts file down to size bytes, dropping a damaged tail found by
+// Load. Only the last file can be cut short that way: dropping the tail of any
+// other would lose the commands in the files after it as well. The result is
+// synced before returning.
+func (aof *Aof) Truncate(file string, size int64) error {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	incrs := aof.manifest.Incrs
+	if file != incrs[len(incrs)-1].Name {
+		return fmt.Errorf("%s is not the last file of the AOF", file)
+	}
+	if err := aof.file.Truncate(size); err != nil {
+		return err
 	}
-	if condition != "" {
-		args = append(args, resp.Value{Typ: "bulk", Bulk: condition})
+	if err := aof.file.Sync(); err != nil {
+		return err
 	}
-	value := resp.Value{Typ: "array", Array: args}
-	return aof.Write(value)
+	aof.dirty = false
+	aof.updateSizes()
+	return nil
 }
 
-// WriteDel converts the DEL command and its arguments into RESP format
-func (aof *Aof) WriteDel(keys []string) error {
-	args := []resp.Value{{Typ: "bulk", Bulk: "DEL"}}
-	for _, key := range keys {
-		args = append(args, resp.Value{Typ: "bulk", Bulk: key})
+// BeginRewrite marks the start of a rewrite by moving writes over to a new
+// incr file, which the rewrite leaves alone. The caller must call it at the
+// exact moment it takes the snapshot of the dataset passed to FinishRewrite:
+// anything written before is part of the snapshot, anything after goes to the
+// new incr file.
+func (aof *Aof) BeginRewrite() error {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	if aof.rewriting {
+		return ErrRewriteInProgress
+	}
+	if err := aof.openNewIncr(); err != nil {
+		return err
 	}
-	value := resp.Value{Typ: "array", Array: args}
-	return aof.Write(value)
+	aof.rewriting = true
+	aof.rewriteIncr = aof.manifest.Incrs[len(aof.manifest.Incrs)-1].Name
+	return nil
 }
 
-// WriteSet converts the SET command and its arguments into RESP format,
-// optionally including expiry information.
-func (aof *Aof) WriteSet(key, value string, args ...string) error {
-	commandArgs := []resp.Value{{Typ: "bulk", Bulk: "SET"}, {Typ: "bulk", Bulk: key}, {Typ: "bulk", Bulk: value}}
+// FinishRewrite replaces the base file and the incr files written before
+// BeginRewrite with a new base. write is called to write the snapshot taken at
+// BeginRewrite, in the given format, into a temporary file. That runs without
+// holding the AOF lock, so clients keep appending in the meantime. The file is
+// then synced, renamed into place and committed by writing the manifest. On
+// failure the AOF is left as it was.
+func (aof *Aof) FinishRewrite(format BaseFormat, write func(w io.Writer) error) error {
+	start := time.Now()
+	err := aof.rewrite(format, write)
+
+	aof.mu.Lock()
+	aof.rewriting = false
+	aof.rewriteIncr = ""
+	aof.rewrites++
+	aof.lastRewriteErr = err
+	aof.lastRewriteDuration = time.Since(start)
+	aof.mu.Unlock()
 
-	// Check for expiry arguments (EX or PX)
-	for i := 0; i < len(args); i++ {
-		commandArgs = append(commandArgs, resp.Value{Typ: "bulk", Bulk: args[i]})
-		if i+1 < len(args) {
-			commandArgs = append(commandArgs, resp.Value{Typ: "bulk", Bulk: args[i+1]})
-			break // Consume both EX/PX and the time
+	return err
+}
+
+func (aof *Aof) rewrite(format BaseFormat, write func(w io.Writer) error) error {
+	tmpPath := filepath.Join(aof.dir, fmt.Sprintf("temp-rewriteaof-bg-%d.aof", os.Getpid()))
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+
+	// Any failure before the rename leaves the temporary file behind, get rid
+	// of it
+	renamed := false
+	defer func() {
+		if !renamed {
+			os.Remove(tmpPath)
 		}
+	}()
+
+	w := bufio.NewWriter(tmp)
+	err = write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	m := aof.manifest.clone()
+	seq := m.nextBaseSeq()
+	base := ManifestEntry{Name: baseFileName(aof.name, seq, format), Seq: seq, Type: TypeBase}
+	path := filepath.Join(aof.dir, base.Name)
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	renamed = true
+
+	// The old base and the incr files before the one BeginRewrite started are
+	// all in the new base now
+	if m.Base != nil {
+		m.History = append(m.History, ManifestEntry{Name: m.Base.Name, Seq: m.Base.Seq, Type: TypeHistory})
+	}
+	m.Base = &base
+	for len(m.Incrs) > 0 && m.Incrs[0].Name != aof.rewriteIncr {
+		m.History = append(m.History, ManifestEntry{Name: m.Incrs[0].Name, Seq: m.Incrs[0].Seq, Type: TypeHistory})
+		m.Incrs = m.Incrs[1:]
+	}
+
+	// Until the manifest is written the old files are still the AOF, and a
+	// crash leaves the new base as a stray file nothing refers to
+	if err := writeManifest(aof.dir, aof.name, m); err != nil {
+		os.Remove(path)
+		return err
 	}
+	aof.manifest = m
 
-	respValue := resp.Value{Typ: "array", Array: commandArgs}
-	return aof.Write(respValue)
+	aof.removeHistory()
+	aof.updateSizes()
+	return nil
+}
+
+// NeedsRewrite reports whether the AOF has grown enough since the last rewrite
+// for an automatic one to be due.
+func (aof *Aof) NeedsRewrite() bool {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	if aof.rewriting || aof.rewritePercentage <= 0 || aof.currentSize < aof.rewriteMinSize {
+		return false
+	}
+	base := aof.baseSize
+	if base == 0 {
+		base = 1
+	}
+	growth := (aof.currentSize - base) * 100 / base
+	return growth >= int64(aof.rewritePercentage)
+}
+
+// RewriteInfo returns the state of AOF rewrites.
+func (aof *Aof) RewriteInfo() RewriteInfo {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	return RewriteInfo{
+		InProgress:          aof.rewriting,
+		Rewrites:            aof.rewrites,
+		LastErr:             aof.lastRewriteErr,
+		LastRewriteDuration: aof.lastRewriteDuration,
+		CurrentSize:         aof.currentSize,
+		BaseSize:            aof.baseSize,
+	}
+}
+
+// syncDir flushes a directory entry to disk, making a rename inside it durable.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
 }
@@ -0,0 +1,49 @@
+package aof
+
+import (
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// A write cut short, here by the file size limit, leaves nothing of itself
+// behind in the file
+func TestWriteRollsBack(t *testing.T) {
+	aof := openAof(t)
+	if err := aof.Write(command("SET a 1")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	info, err := aof.file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, current := info.Size(), aof.currentSize
+
+	var saved syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_FSIZE, &saved); err != nil {
+		t.Fatal(err)
+	}
+	limit := saved
+	limit.Cur = uint64(size) + 10
+	if err := syscall.Setrlimit(syscall.RLIMIT_FSIZE, &limit); err != nil {
+		t.Skipf("cannot lower the file size limit: %v", err)
+	}
+	err = aof.Write(command("SET b " + strings.Repeat("x", 100)))
+	syscall.Setrlimit(syscall.RLIMIT_FSIZE, &saved)
+	if err == nil {
+		t.Fatal("Write beyond the file size limit succeeded")
+	}
+
+	if info, _ := aof.file.Stat(); info.Size() != size {
+		t.Errorf("file is %d bytes after the failed write, want %d", info.Size(), size)
+	}
+	if aof.currentSize != current {
+		t.Errorf("currentSize = %d after the failed write, want %d", aof.currentSize, current)
+	}
+	if err := aof.Write(command("SET c 3")); err != nil {
+		t.Fatalf("Write after the failed one: %v", err)
+	}
+	if got := strings.Join(commands(t, aof), ","); got != "SET a 1,SET c 3" {
+		t.Errorf("AOF holds %q", got)
+	}
+}
@@ -0,0 +1,444 @@
+package aof
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/IAmRiteshKoushik/bluedis/resp"
+)
+
+// marshal encodes commands the way they are logged.
+func marshal(commands ...[]string) []byte {
+	var b bytes.Buffer
+	for _, args := range commands {
+		value := resp.Value{Typ: "array"}
+		for _, arg := range args {
+			value.Array = append(value.Array, resp.Value{Typ: "bulk", Bulk: arg})
+		}
+		b.Write(value.Marshal())
+	}
+	return b.Bytes()
+}
+
+// command builds the RESP array for a command line.
+func command(line string) resp.Value {
+	value := resp.Value{Typ: "array"}
+	for _, field := range strings.Fields(line) {
+		value.Array = append(value.Array, resp.Value{Typ: "bulk", Bulk: field})
+	}
+	return value
+}
+
+// commands returns every command in the AOF as a line, with a line saying
+// "snapshot" standing for a base in snapshot format.
+func commands(t *testing.T, aof *Aof) []string {
+	t.Helper()
+	var lines []string
+	loadSnapshot := func(r io.Reader) error {
+		lines = append(lines, "snapshot")
+		return nil
+	}
+	err := aof.Load(loadSnapshot, func(value resp.Value) {
+		var fields []string
+		for _, v := range value.Array {
+			fields = append(fields, v.Bulk)
+		}
+		lines = append(lines, strings.Join(fields, " "))
+	})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	return lines
+}
+
+func openAof(t *testing.T) *Aof {
+	t.Helper()
+	aof, err := NewAof(t.TempDir(), "appendonly.aof")
+	if err != nil {
+		t.Fatalf("NewAof: %v", err)
+	}
+	t.Cleanup(func() { aof.Close() })
+	return aof
+}
+
+func TestRewrite(t *testing.T) {
+	aof := openAof(t)
+	for _, line := range []string{"SET a 1", "SET a 2", "SET b 1", "DEL b"} {
+		aof.Write(command(line))
+	}
+
+	if err := aof.BeginRewrite(); err != nil {
+		t.Fatalf("BeginRewrite: %v", err)
+	}
+	if err := aof.BeginRewrite(); !errors.Is(err, ErrRewriteInProgress) {
+		t.Errorf("second BeginRewrite = %v, want ErrRewriteInProgress", err)
+	}
+	// Written while the snapshot is being dumped, so it goes to the new incr
+	// file
+	aof.Write(command("SET c 1"))
+
+	err := aof.FinishRewrite(BaseAOF, func(w io.Writer) error {
+		_, err := w.Write(command("SET a 2").Marshal())
+		return err
+	})
+	if err != nil {
+		t.Fatalf("FinishRewrite: %v", err)
+	}
+	aof.Write(command("SET d 1"))
+
+	if got, want := strings.Join(commands(t, aof), ","), "SET a 2,SET c 1,SET d 1"; got != want {
+		t.Errorf("AOF after rewrite = %q, want %q", got, want)
+	}
+	info := aof.RewriteInfo()
+	if info.InProgress || info.Rewrites != 1 || info.LastErr != nil {
+		t.Errorf("RewriteInfo = %+v", info)
+	}
+	base, current := int64(len(command("SET a 2").Marshal())), int64(len(marshal([]string{"SET", "c", "1"}, []string{"SET", "d", "1"})))
+	if info.BaseSize != base || info.CurrentSize != base+current {
+		t.Errorf("sizes %d/%d, want %d/%d", info.BaseSize, info.CurrentSize, base, base+current)
+	}
+	// The manifest, the new base and the incr file BeginRewrite started are
+	// all that is left
+	if files := dirFiles(t, aof.dir); strings.Join(files, ",") != "appendonly.aof.1.base.aof,appendonly.aof.2.incr.aof,appendonly.aof.manifest" {
+		t.Errorf("files after the rewrite: %q", files)
+	}
+}
+
+// dirFiles returns the names of the files in dir, sorted.
+func dirFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestRewriteFailureKeepsAof(t *testing.T) {
+	aof := openAof(t)
+	aof.Write(command("SET a 1"))
+
+	aof.BeginRewrite()
+	aof.Write(command("SET b 1"))
+	failure := errors.New("dump failed")
+	err := aof.FinishRewrite(BaseAOF, func(w io.Writer) error {
+		w.Write(command("SET x 1").Marshal())
+		return failure
+	})
+	if err != failure {
+		t.Errorf("FinishRewrite = %v, want %v", err, failure)
+	}
+
+	if got, want := strings.Join(commands(t, aof), ","), "SET a 1,SET b 1"; got != want {
+		t.Errorf("AOF after a failed rewrite = %q, want %q", got, want)
+	}
+	if info := aof.RewriteInfo(); info.InProgress || info.LastErr != failure {
+		t.Errorf("RewriteInfo = %+v", info)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(aof.dir, "temp-*")); len(leftovers) != 0 {
+		t.Errorf("temporary files left behind: %q", leftovers)
+	}
+	// The next rewrite can start
+	if err := aof.BeginRewrite(); err != nil {
+		t.Errorf("BeginRewrite after a failure: %v", err)
+	}
+}
+
+func TestNeedsRewrite(t *testing.T) {
+	tests := []struct {
+		name          string
+		percentage    int
+		minSize       int64
+		base, current int64
+		rewriting     bool
+		want          bool
+	}{
+		{"below the minimum size", 100, 1000, 100, 999, false, false},
+		{"grown enough", 100, 1000, 500, 1000, false, true},
+		{"not grown enough", 100, 1000, 600, 1000, false, false},
+		{"no base yet", 100, 1000, 0, 1000, false, true},
+		{"disabled", 0, 0, 1, 1000, false, false},
+		{"already rewriting", 100, 1000, 500, 1000, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aof := openAof(t)
+			aof.SetRewriteThreshold(tt.percentage, tt.minSize)
+			aof.baseSize, aof.currentSize, aof.rewriting = tt.base, tt.current, tt.rewriting
+			if got := aof.NeedsRewrite(); got != tt.want {
+				t.Errorf("NeedsRewrite = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFsyncPolicy(t *testing.T) {
+	aof := openAof(t)
+	for _, policy := range []string{FsyncAlways, FsyncNo, FsyncEverySec} {
+		if err := aof.SetFsyncPolicy(policy); err != nil {
+			t.Errorf("SetFsyncPolicy(%s): %v", policy, err)
+		}
+	}
+	if err := aof.SetFsyncPolicy("sometimes"); err == nil {
+		t.Error("SetFsyncPolicy(sometimes) succeeded")
+	}
+	if aof.fsync != FsyncEverySec {
+		t.Errorf("policy is %q after a rejected change, want everysec", aof.fsync)
+	}
+
+	aof.Write(command("SET a 1"))
+	if !aof.dirty {
+		t.Error("not dirty after a write under everysec")
+	}
+	// Switching to always flushes what is pending
+	aof.SetFsyncPolicy(FsyncAlways)
+	if aof.dirty {
+		t.Error("still dirty after switching to always")
+	}
+	aof.Write(command("SET a 2"))
+	if aof.dirty {
+		t.Error("dirty after a write under always")
+	}
+}
+
+func TestCloseTwice(t *testing.T) {
+	aof, err := NewAof(t.TempDir(), "appendonly.aof")
+	if err != nil {
+		t.Fatalf("NewAof: %v", err)
+	}
+	aof.Write(command("SET a 1"))
+	if err := aof.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	// The syncer is already gone; the second close only reports the file
+	aof.Close()
+}
+
+func TestScan(t *testing.T) {
+	set := marshal([]string{"SET", "k", "v"})
+	multi, exec := marshal([]string{"MULTI"}), marshal([]string{"EXEC"})
+
+	tests := []struct {
+		name      string
+		data      []byte
+		commands  int
+		corrupt   bool
+		offset    int64
+		truncated bool
+	}{
+		{name: "empty", data: nil},
+		{name: "complete", data: bytes.Repeat(set, 2), commands: 2},
+		{
+			name:     "cut short",
+			data:     append(bytes.Clone(set), set[:len(set)-3]...),
+			commands: 1, corrupt: true, offset: int64(len(set)), truncated: true,
+		},
+		{
+			name:     "transaction",
+			data:     bytes.Join([][]byte{set, multi, set, exec}, nil),
+			commands: 4,
+		},
+		{
+			name:     "transaction without EXEC",
+			data:     bytes.Join([][]byte{set, multi, set}, nil),
+			commands: 3, corrupt: true, offset: int64(len(set)), truncated: true,
+		},
+		{
+			name:     "transaction cut short",
+			data:     bytes.Join([][]byte{set, multi, set[:5]}, nil),
+			commands: 2, corrupt: true, offset: int64(len(set)), truncated: true,
+		},
+		{
+			name:     "not a command",
+			data:     append(bytes.Clone(set), "+OK\r\n"...),
+			commands: 1, corrupt: true, offset: int64(len(set)),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commands, err := scan(bytes.NewReader(tt.data), func(resp.Value) {})
+			if commands != tt.commands {
+				t.Errorf("scan read %d commands, want %d", commands, tt.commands)
+			}
+
+			var corrupt *CorruptError
+			if !tt.corrupt {
+				if err != nil {
+					t.Fatalf("scan returned %v, want no error", err)
+				}
+				return
+			}
+			if !errors.As(err, &corrupt) {
+				t.Fatalf("scan returned %v, want a *CorruptError", err)
+			}
+			if corrupt.Offset != tt.offset || corrupt.Truncated != tt.truncated {
+				t.Errorf("scan reported damage at %d (truncated %v), want %d (truncated %v)",
+					corrupt.Offset, corrupt.Truncated, tt.offset, tt.truncated)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	set := marshal([]string{"SET", "k", "v"})
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	if err := os.WriteFile(path, append(bytes.Repeat(set, 3), set[:5]...), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	commands, size, err := Check(path)
+	var corrupt *CorruptError
+	if !errors.As(err, &corrupt) || corrupt.Offset != int64(3*len(set)) {
+		t.Fatalf("Check returned %v, want damage at offset %d", err, 3*len(set))
+	}
+	if commands != 3 || size != int64(3*len(set)+5) {
+		t.Errorf("Check = %d commands, %d bytes, want 3 commands, %d bytes", commands, size, 3*len(set)+5)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	dir := t.TempDir()
+	aof, err := NewAof(dir, "appendonly.aof")
+	if err != nil {
+		t.Fatalf("NewAof: %v", err)
+	}
+	defer aof.Close()
+
+	for _, key := range []string{"a", "b"} {
+		if err := aof.Write(resp.Value{Typ: "array", Array: []resp.Value{
+			{Typ: "bulk", Bulk: "SET"}, {Typ: "bulk", Bulk: key}, {Typ: "bulk", Bulk: "1"},
+		}}); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	// A write cut short by a crash
+	if _, err := aof.file.Write([]byte("*3\r\n$3\r\nSET\r\n$1")); err != nil {
+		t.Fatal(err)
+	}
+
+	load := func() ([]string, error) {
+		var keys []string
+		err := aof.Load(func(r io.Reader) error { return nil }, func(value resp.Value) {
+			keys = append(keys, value.Array[1].Bulk)
+		})
+		return keys, err
+	}
+
+	keys, err := load()
+	var corrupt *CorruptError
+	if !errors.As(err, &corrupt) || !corrupt.Truncated {
+		t.Fatalf("Load returned %v, want a truncated *CorruptError", err)
+	}
+	if strings.Join(keys, ",") != "a,b" {
+		t.Errorf("Load replayed %v before the damage, want [a b]", keys)
+	}
+
+	if err := aof.Truncate("not-the-last-file.aof", corrupt.Offset); err == nil {
+		t.Errorf("Truncate accepted a file other than the last one")
+	}
+	if err := aof.Truncate(corrupt.File, corrupt.Offset); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+
+	keys, err = load()
+	if err != nil {
+		t.Fatalf("Load after Truncate returned %v", err)
+	}
+	if strings.Join(keys, ",") != "a,b" {
+		t.Errorf("Load after Truncate replayed %v, want [a b]", keys)
+	}
+	if info := aof.RewriteInfo(); info.CurrentSize != corrupt.Offset {
+		t.Errorf("current size is %d after Truncate, want %d", info.CurrentSize, corrupt.Offset)
+	}
+}
+
+func TestRewriteSnapshotBase(t *testing.T) {
+	for _, archive := range []bool{false, true} {
+		aof := openAof(t)
+		aof.SetArchiveHistory(archive)
+		aof.Write(command("SET a 1"))
+		aof.BeginRewrite()
+		aof.Write(command("SET b 1"))
+		err := aof.FinishRewrite(BaseRDB, func(w io.Writer) error {
+			_, err := w.Write([]byte("snapshot bytes"))
+			return err
+		})
+		if err != nil {
+			t.Fatalf("FinishRewrite: %v", err)
+		}
+
+		// The snapshot is loaded first, then what was written since
+		if got, want := strings.Join(commands(t, aof), ","), "snapshot,SET b 1"; got != want {
+			t.Errorf("AOF after rewrite = %q, want %q", got, want)
+		}
+		archived, _ := filepath.Glob(filepath.Join(aof.dir, "archive", "*"))
+		if archive && (len(archived) != 1 || filepath.Base(archived[0]) != "appendonly.aof.1.incr.aof") {
+			t.Errorf("archived files: %q, want the replaced incr file", archived)
+		}
+		if !archive && len(archived) != 0 {
+			t.Errorf("files archived with archiving off: %q", archived)
+		}
+	}
+}
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	aof, err := NewAof(dir, "appendonly.aof")
+	if err != nil {
+		t.Fatalf("NewAof: %v", err)
+	}
+	aof.Write(command("SET a 1"))
+	aof.BeginRewrite()
+	aof.Write(command("SET b 1"))
+	aof.FinishRewrite(BaseAOF, func(w io.Writer) error {
+		_, err := w.Write(command("SET a 1").Marshal())
+		return err
+	})
+	aof.Close()
+
+	// Writes carry on in the last incr file
+	aof, err = NewAof(dir, "appendonly.aof")
+	if err != nil {
+		t.Fatalf("NewAof: %v", err)
+	}
+	defer aof.Close()
+	aof.Write(command("SET c 1"))
+	if got, want := strings.Join(commands(t, aof), ","), "SET a 1,SET b 1,SET c 1"; got != want {
+		t.Errorf("AOF after reopening = %q, want %q", got, want)
+	}
+	if m := aof.manifest; m.Base == nil || len(m.Incrs) != 1 || len(m.History) != 0 {
+		t.Errorf("manifest after reopening = %+v", m)
+	}
+}
+
+func TestUpgradeSingleFile(t *testing.T) {
+	parent := t.TempDir()
+	legacy := filepath.Join(parent, "appendonly.aof")
+	os.WriteFile(legacy, marshal([]string{"SET", "a", "1"}), 0666)
+
+	aof, err := NewAof(filepath.Join(parent, "appendonlydir"), "appendonly.aof")
+	if err != nil {
+		t.Fatalf("NewAof: %v", err)
+	}
+	defer aof.Close()
+	aof.Write(command("SET b 1"))
+
+	if got, want := strings.Join(commands(t, aof), ","), "SET a 1,SET b 1"; got != want {
+		t.Errorf("AOF after upgrading = %q, want %q", got, want)
+	}
+	if _, err := os.Stat(legacy); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the old file is still there: %v", err)
+	}
+	if aof.manifest.Base == nil || aof.manifest.Base.Name != "appendonly.aof.1.base.aof" {
+		t.Errorf("base after upgrading = %+v", aof.manifest.Base)
+	}
+}
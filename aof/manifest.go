@@ -0,0 +1,240 @@
+package aof
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Kinds of files listed in a manifest
+const (
+	TypeBase    = "b" // Snapshot of the dataset the incr files build upon
+	TypeIncr    = "i" // Commands appended since the base was written
+	TypeHistory = "h" // Replaced by a rewrite, waiting to be deleted or archived
+)
+
+// Extensions telling the two formats of base files apart
+const (
+	baseRDBSuffix = ".base.rdb"
+	baseAOFSuffix = ".base.aof"
+	incrSuffix    = ".incr.aof"
+)
+
+// ManifestEntry is one file of the AOF.
+type ManifestEntry struct {
+	Name string // Relative to the AOF directory
+	Seq  int64
+	Type string
+}
+
+// IsSnapshot reports whether the file is a base in snapshot format rather than
+// a list of commands.
+func (e ManifestEntry) IsSnapshot() bool {
+	return strings.HasSuffix(e.Name, ".rdb")
+}
+
+// Manifest lists the files that make up the AOF, like Redis 7 does: at most
+// one base file, followed by incr files in the order they were written. The
+// dataset is the base with every incr file replayed on top of it.
+type Manifest struct {
+	Base    *ManifestEntry
+	Incrs   []ManifestEntry
+	History []ManifestEntry
+}
+
+// Files returns the files to load, in the order they have to be loaded in.
+func (m *Manifest) Files() []ManifestEntry {
+	files := make([]ManifestEntry, 0, len(m.Incrs)+1)
+	if m.Base != nil {
+		files = append(files, *m.Base)
+	}
+	return append(files, m.Incrs...)
+}
+
+// clone returns a copy of m that can be modified without touching m.
+func (m *Manifest) clone() *Manifest {
+	c := &Manifest{
+		Incrs:   append([]ManifestEntry(nil), m.Incrs...),
+		History: append([]ManifestEntry(nil), m.History...),
+	}
+	if m.Base != nil {
+		base := *m.Base
+		c.Base = &base
+	}
+	return c
+}
+
+// nextBaseSeq returns the sequence number for a new base file. Sequence
+// numbers only ever go up, so that an archived file is never overwritten by a
+// newer one of the same name.
+func (m *Manifest) nextBaseSeq() int64 {
+	var seq int64
+	if m.Base != nil {
+		seq = m.Base.Seq
+	}
+	for _, e := range m.History {
+		if isBaseName(e.Name) && e.Seq > seq {
+			seq = e.Seq
+		}
+	}
+	return seq + 1
+}
+
+// nextIncrSeq returns the sequence number for a new incr file.
+func (m *Manifest) nextIncrSeq() int64 {
+	var seq int64
+	for _, files := range [][]ManifestEntry{m.Incrs, m.History} {
+		for _, e := range files {
+			if strings.HasSuffix(e.Name, incrSuffix) && e.Seq > seq {
+				seq = e.Seq
+			}
+		}
+	}
+	return seq + 1
+}
+
+func isBaseName(name string) bool {
+	return strings.HasSuffix(name, baseRDBSuffix) || strings.HasSuffix(name, baseAOFSuffix)
+}
+
+// ManifestPath returns where the manifest of the AOF called name lives.
+func ManifestPath(dir, name string) string {
+	return filepath.Join(dir, name+".manifest")
+}
+
+func baseFileName(name string, seq int64, format BaseFormat) string {
+	suffix := baseAOFSuffix
+	if format == BaseRDB {
+		suffix = baseRDBSuffix
+	}
+	return fmt.Sprintf("%s.%d%s", name, seq, suffix)
+}
+
+func incrFileName(name string, seq int64) string {
+	return fmt.Sprintf("%s.%d%s", name, seq, incrSuffix)
+}
+
+// ReadManifest parses a manifest file. Every line describes one file as
+// "file <name> seq <n> type <b|i|h>".
+func ReadManifest(path string) (*Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m := &Manifest{}
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		entry, err := parseManifestLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineNo, err)
+		}
+		switch entry.Type {
+		case TypeBase:
+			if m.Base != nil {
+				return nil, fmt.Errorf("%s:%d: more than one base file", path, lineNo)
+			}
+			m.Base = &entry
+		case TypeIncr:
+			m.Incrs = append(m.Incrs, entry)
+		case TypeHistory:
+			m.History = append(m.History, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func parseManifestLine(line string) (ManifestEntry, error) {
+	var entry ManifestEntry
+	fields := strings.Fields(line)
+	if len(fields)%2 != 0 {
+		return entry, fmt.Errorf("invalid manifest line '%s'", line)
+	}
+
+	for i := 0; i < len(fields); i += 2 {
+		switch fields[i] {
+		case "file":
+			entry.Name = fields[i+1]
+		case "seq":
+			seq, err := strconv.ParseInt(fields[i+1], 10, 64)
+			if err != nil || seq < 1 {
+				return entry, fmt.Errorf("invalid sequence number '%s'", fields[i+1])
+			}
+			entry.Seq = seq
+		case "type":
+			entry.Type = fields[i+1]
+		}
+		// Unknown keys are skipped so that newer manifests stay readable
+	}
+
+	if entry.Name == "" || strings.ContainsRune(entry.Name, '/') {
+		return entry, fmt.Errorf("invalid file name in '%s'", line)
+	}
+	if entry.Seq == 0 {
+		return entry, fmt.Errorf("missing sequence number in '%s'", line)
+	}
+	switch entry.Type {
+	case TypeBase, TypeIncr, TypeHistory:
+	default:
+		return entry, fmt.Errorf("invalid file type in '%s'", line)
+	}
+	return entry, nil
+}
+
+// writeManifest replaces the manifest atomically: a crash leaves either the
+// old one or the new one, never a mix of both.
+func writeManifest(dir, name string, m *Manifest) error {
+	var b strings.Builder
+	write := func(e ManifestEntry) {
+		fmt.Fprintf(&b, "file %s seq %d type %s\n", e.Name, e.Seq, e.Type)
+	}
+	if m.Base != nil {
+		write(*m.Base)
+	}
+	for _, e := range m.History {
+		write(e)
+	}
+	for _, e := range m.Incrs {
+		write(e)
+	}
+
+	tmpPath := filepath.Join(dir, "temp-"+name+".manifest")
+	if err := writeFileSync(tmpPath, []byte(b.String())); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, ManifestPath(dir, name)); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	syncDir(dir)
+	return nil
+}
+
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,124 @@
+package aof
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseManifestLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    ManifestEntry
+		wantErr bool
+	}{
+		{line: "file appendonly.aof.1.base.rdb seq 1 type b", want: ManifestEntry{"appendonly.aof.1.base.rdb", 1, TypeBase}},
+		{line: "file appendonly.aof.3.incr.aof seq 3 type i", want: ManifestEntry{"appendonly.aof.3.incr.aof", 3, TypeIncr}},
+		{line: "type h seq 2 file appendonly.aof.2.incr.aof", want: ManifestEntry{"appendonly.aof.2.incr.aof", 2, TypeHistory}},
+		{line: "file a.aof seq 1 type i startoffset 10", want: ManifestEntry{"a.aof", 1, TypeIncr}},
+		{line: "file a.aof seq 1 type", wantErr: true},
+		{line: "file a.aof seq 0 type i", wantErr: true},
+		{line: "file a.aof seq -1 type i", wantErr: true},
+		{line: "file a.aof seq one type i", wantErr: true},
+		{line: "file a.aof type i", wantErr: true},
+		{line: "seq 1 type i", wantErr: true},
+		{line: "file ../a.aof seq 1 type i", wantErr: true},
+		{line: "file a.aof seq 1 type x", wantErr: true},
+		{line: "file a.aof seq 1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, err := parseManifestLine(tt.line)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseManifestLine = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseManifestLine returned %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("parseManifestLine = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    *Manifest
+		wantErr bool
+	}{
+		{name: "empty", content: "", want: &Manifest{}},
+		{
+			name: "full",
+			content: "# written by bluedis\n" +
+				"file appendonly.aof.2.base.rdb seq 2 type b\n" +
+				"\n" +
+				"file appendonly.aof.1.base.aof seq 1 type h\n" +
+				"file appendonly.aof.3.incr.aof seq 3 type i\n" +
+				"  file appendonly.aof.4.incr.aof seq 4 type i  \n",
+			want: &Manifest{
+				Base:    &ManifestEntry{"appendonly.aof.2.base.rdb", 2, TypeBase},
+				Incrs:   []ManifestEntry{{"appendonly.aof.3.incr.aof", 3, TypeIncr}, {"appendonly.aof.4.incr.aof", 4, TypeIncr}},
+				History: []ManifestEntry{{"appendonly.aof.1.base.aof", 1, TypeHistory}},
+			},
+		},
+		{
+			name: "two bases",
+			content: "file a.1.base.aof seq 1 type b\n" +
+				"file a.2.base.aof seq 2 type b\n",
+			wantErr: true,
+		},
+		{name: "bad line", content: "file a.1.incr.aof seq 1 type i\nnonsense\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "appendonly.aof.manifest")
+			if err := os.WriteFile(path, []byte(tt.content), 0666); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := ReadManifest(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ReadManifest = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadManifest returned %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadManifest = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteManifest(t *testing.T) {
+	dir := t.TempDir()
+	want := &Manifest{
+		Base:    &ManifestEntry{"appendonly.aof.2.base.rdb", 2, TypeBase},
+		Incrs:   []ManifestEntry{{"appendonly.aof.3.incr.aof", 3, TypeIncr}},
+		History: []ManifestEntry{{"appendonly.aof.1.incr.aof", 1, TypeHistory}},
+	}
+	if err := writeManifest(dir, "appendonly.aof", want); err != nil {
+		t.Fatalf("writeManifest: %v", err)
+	}
+
+	got, err := ReadManifest(ManifestPath(dir, "appendonly.aof"))
+	if err != nil {
+		t.Fatalf("ReadManifest: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadManifest = %+v, want %+v", got, want)
+	}
+	if files := got.Files(); len(files) != 2 || files[0] != *want.Base || files[1] != want.Incrs[0] {
+		t.Errorf("Files = %+v, want the base then the incr file", files)
+	}
+}
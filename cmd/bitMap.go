@@ -3,14 +3,20 @@ package cmd
 import (
 	"fmt"
 	"strconv"
-	"sync"
 
 	"github.com/IAmRiteshKoushik/bluedis/resp"
 	"github.com/IAmRiteshKoushik/bluedis/store"
 )
 
-var BitMapStore = make(map[string]*store.StringBitMap)
-var BitMapStoreMu sync.Mutex
+// lookupBitMap returns the bitmap stored at key, or nil if the key does not
+// exist. The caller must hold the keyspace lock.
+func lookupBitMap(key string) (*store.StringBitMap, error) {
+	entry, err := DB.LookupType(key, store.TypeBitMap)
+	if entry == nil {
+		return nil, err
+	}
+	return entry.Value.(*store.StringBitMap), nil
+}
 
 func SetBit(args []resp.Value) resp.Value {
 	if len(args) != 3 {
@@ -27,18 +33,24 @@ func SetBit(args []resp.Value) resp.Value {
 		return resp.Value{Typ: "error", Str: "ERR invalid value argument for 'setbit' command"}
 	}
 
-	BitMapStoreMu.Lock()
-	defer BitMapStoreMu.Unlock()
+	DB.Lock()
+	defer DB.Unlock()
 
-	bitmap, exists := BitMapStore[key] 
-	if !exists {
+	bitmap, err := lookupBitMap(key)
+	if err != nil {
+		return wrongTypeError()
+	}
+	if bitmap == nil {
 		bitmap = store.NewStringBitMap()
-		BitMapStore[key] = bitmap 
+		DB.Put(key, store.TypeBitMap, bitmap)
+		Notify(NotifyNew, "new", key)
 	}
 	err = bitmap.SetBit(key, pos, value == 1)
 	if err != nil {
 		return resp.Value{Typ: "error", Str: fmt.Sprintf("ERR %v", err)}
 	}
+	DB.Touch(key)
+	Notify(NotifyString, "setbit", key)
 
 	return resp.Value{Typ: "integer", Num: 1}
 }
@@ -54,12 +66,15 @@ func GetBit(args []resp.Value) resp.Value {
 		return resp.Value{Typ: "error", Str: "ERR invalid position argument for 'getbit' command"}
 	}
 
-	BitMapStoreMu.Lock()
-	defer BitMapStoreMu.Unlock()
+	DB.RLock()
+	defer DB.RUnlock()
 
-	bitmap, exists := BitMapStore[key] 
-	if !exists {
-		 return resp.Value{Typ: "integer", Num: 0} 
+	bitmap, err := lookupBitMap(key)
+	if err != nil {
+		return wrongTypeError()
+	}
+	if bitmap == nil {
+		return resp.Value{Typ: "integer", Num: 0}
 	}
 	value, err := bitmap.GetBit(key, pos)
 	if err != nil {
@@ -79,12 +94,15 @@ func BitCount(args []resp.Value) resp.Value {
 
 	key := args[0].Bulk
 
-	BitMapStoreMu.Lock()
-	defer BitMapStoreMu.Unlock()
+	DB.RLock()
+	defer DB.RUnlock()
 
-	bitmap, exists := BitMapStore[key] 
-	if !exists {
-		 return resp.Value{Typ: "integer", Num: 0} 
+	bitmap, err := lookupBitMap(key)
+	if err != nil {
+		return wrongTypeError()
+	}
+	if bitmap == nil {
+		return resp.Value{Typ: "integer", Num: 0}
 	}
 	count, err := bitmap.PopCount(key)
 	if err != nil {
@@ -101,10 +119,13 @@ func DelBitMap(args []resp.Value) resp.Value {
 
 	key := args[0].Bulk
 
-	BitMapStoreMu.Lock()
-	defer BitMapStoreMu.Unlock()
+	DB.Lock()
+	defer DB.Unlock()
+
+	if _, err := lookupBitMap(key); err != nil {
+		return wrongTypeError()
+	}
+	DB.Remove(key)
 
-	delete(BitMapStore, key)
-	
 	return resp.Value{Typ: "integer", Num: 1}
 }
@@ -2,14 +2,20 @@ package cmd
 
 import (
 	"strconv"
-	"sync"
 
 	"github.com/IAmRiteshKoushik/bluedis/resp"
 	"github.com/IAmRiteshKoushik/bluedis/store"
 )
 
-var bloomStore = make(map[string]*store.BloomFilter)
-var bloomStoreMu sync.RWMutex
+// lookupBloom returns the bloom filter stored at key, or nil if the key does
+// not exist. The caller must hold the keyspace lock.
+func lookupBloom(key string) (*store.BloomFilter, error) {
+	entry, err := DB.LookupType(key, store.TypeBloom)
+	if entry == nil {
+		return nil, err
+	}
+	return entry.Value.(*store.BloomFilter), nil
+}
 
 func BFReserve(args []resp.Value) resp.Value {
 	if len(args) != 2 {
@@ -20,18 +26,20 @@ func BFReserve(args []resp.Value) resp.Value {
 	}
 	key := args[0].Bulk
 	capacity := args[1].Bulk
-	_, exists := bloomStore[key]
-	if exists {
-		return resp.Value{Typ: "error", Str: "ERR key already exists"}
-	}
-
 	size, err := strconv.Atoi(capacity)
 	if err != nil {
 		return resp.Value{Typ: "error", Str: "ERR capacity must be an integer"}
 	}
-	bloomStoreMu.Lock()
-	bloomStore[key] = store.NewBloomFilter(size)
-	bloomStoreMu.Unlock()
+
+	DB.Lock()
+	defer DB.Unlock()
+	_, exists := DB.Lookup(key)
+	if exists {
+		return resp.Value{Typ: "error", Str: "ERR key already exists"}
+	}
+	DB.Put(key, store.TypeBloom, store.NewBloomFilter(size))
+	Notify(NotifyNew, "new", key)
+	Notify(NotifyGeneric, "bf.reserve", key)
 	return resp.Value{Typ: "string", Str: "OK"}
 }
 
@@ -46,14 +54,18 @@ func BFAdd(args []resp.Value) resp.Value {
 	item := args[1]
 
 	// If filer doesn't exist, make it
-	bloomStoreMu.Lock()
-	filter, exists := bloomStore[key]
-	if !exists {
+	DB.Lock()
+	defer DB.Unlock()
+	filter, err := lookupBloom(key)
+	if err != nil {
+		return wrongTypeError()
+	}
+	if filter == nil {
 		// Default Size of 10000 bytes
 		filter = store.NewBloomFilter(10000)
-		bloomStore[key] = filter
+		DB.Put(key, store.TypeBloom, filter)
+		Notify(NotifyNew, "new", key)
 	}
-	bloomStoreMu.Unlock()
 
 	// If item alr exists, return 0 (could be wrong, false positive)
 	// Otherwise add the item and return 1
@@ -64,9 +76,9 @@ func BFAdd(args []resp.Value) resp.Value {
 		}
 	}
 
-	bloomStoreMu.Lock()
 	filter.Add(item)
-	bloomStoreMu.Unlock()
+	DB.Touch(key)
+	Notify(NotifyGeneric, "bf.add", key)
 	return resp.Value{
 		Typ: "integer",
 		Num: 1,
@@ -74,9 +86,6 @@ func BFAdd(args []resp.Value) resp.Value {
 }
 
 func BFExists(args []resp.Value) resp.Value {
-	bloomStoreMu.RLock()
-	defer bloomStoreMu.RUnlock()
-
 	if len(args) != 2 {
 		return resp.Value{
 			Typ: "error",
@@ -85,9 +94,15 @@ func BFExists(args []resp.Value) resp.Value {
 	}
 	key := args[0].Bulk
 	value := args[1]
-	filter, exists := bloomStore[key]
+
+	DB.RLock()
+	defer DB.RUnlock()
+	filter, err := lookupBloom(key)
+	if err != nil {
+		return wrongTypeError()
+	}
 	// If the filter doesn't exist, retrun 0
-	if !exists {
+	if filter == nil {
 		return resp.Value{
 			Typ: "integer",
 			Num: 0,
@@ -107,14 +122,14 @@ func BFExists(args []resp.Value) resp.Value {
 	}
 }
 
-// Helper function for BF.INSERT and BF.MADD
-func insertItems(args []resp.Value, start int, filter *store.BloomFilter) resp.Value {
-	bloomStoreMu.Lock()
-	defer bloomStoreMu.Unlock()
+// Helper function for BF.INSERT and BF.MADD, event being the keyspace event
+// they report. The caller must hold the keyspace lock.
+func insertItems(event string, args []resp.Value, start int, filter *store.BloomFilter) resp.Value {
 	resultArray := resp.Value{
 		Typ:   "array",
 		Array: make([]resp.Value, 0),
 	}
+	added := false
 	for i := start; i < len(args); i++ {
 		value := args[i]
 		if filter.Exists(value) {
@@ -124,12 +139,17 @@ func insertItems(args []resp.Value, start int, filter *store.BloomFilter) resp.V
 			})
 		} else {
 			filter.Add(value)
+			DB.Touch(args[0].Bulk)
+			added = true
 			resultArray.Array = append(resultArray.Array, resp.Value{
 				Typ: "integer",
 				Num: 1,
 			})
 		}
 	}
+	if added {
+		Notify(NotifyGeneric, event, args[0].Bulk)
+	}
 	return resultArray
 }
 
@@ -143,10 +163,17 @@ func BFInsert(args []resp.Value) resp.Value {
 		}
 	}
 	key := args[0].Bulk
-	optArgument, optVal := args[1].Bulk, args[2].Bulk
-	bloomStoreMu.Lock()
-	filter, exists := bloomStore[key]
-	if !exists {
+	optArgument, optVal := args[1].Bulk, ""
+	if len(args) > 2 {
+		optVal = args[2].Bulk
+	}
+	DB.Lock()
+	defer DB.Unlock()
+	filter, err := lookupBloom(key)
+	if err != nil {
+		return wrongTypeError()
+	}
+	if filter == nil {
 		switch optArgument {
 		case "NOCREATE":
 			return resp.Value{
@@ -163,12 +190,14 @@ func BFInsert(args []resp.Value) resp.Value {
 				}
 			}
 			filter = store.NewBloomFilter(capacity)
-			bloomStore[key] = filter
+			DB.Put(key, store.TypeBloom, filter)
+			Notify(NotifyNew, "new", key)
 
 		case "ITEMS":
 			// Creating default filter
 			filter = store.NewBloomFilter(10000)
-			bloomStore[key] = filter
+			DB.Put(key, store.TypeBloom, filter)
+			Notify(NotifyNew, "new", key)
 
 		// IF any other argument,
 		default:
@@ -179,7 +208,6 @@ func BFInsert(args []resp.Value) resp.Value {
 		}
 
 	}
-	bloomStoreMu.Unlock()
 	// Loop through to find ITEMS
 	for index, element := range args {
 		if index == 0 {
@@ -193,7 +221,7 @@ func BFInsert(args []resp.Value) resp.Value {
 			case "CAPACITY":
 				continue
 			case "ITEMS":
-				return insertItems(args, index+1, filter)
+				return insertItems("bf.insert", args, index+1, filter)
 			default:
 				// Any other argument is not allowed
 				return resp.Value{
@@ -211,21 +239,25 @@ func BFInsert(args []resp.Value) resp.Value {
 }
 
 func BFMAdd(args []resp.Value) resp.Value {
-	if len(args) < 1 {
+	if len(args) < 2 {
 		return resp.Value{
 			Typ: "error",
 			Str: "ERR wrong number of arguments for 'BF.MADD' command",
 		}
 	}
 	key := args[0].Bulk
-	bloomStoreMu.Lock()
-	filter, exists := bloomStore[key]
-	if !exists {
+	DB.Lock()
+	defer DB.Unlock()
+	filter, err := lookupBloom(key)
+	if err != nil {
+		return wrongTypeError()
+	}
+	if filter == nil {
 		filter = store.NewBloomFilter(10000)
-		bloomStore[key] = filter
+		DB.Put(key, store.TypeBloom, filter)
+		Notify(NotifyNew, "new", key)
 	}
-	bloomStoreMu.Unlock()
-	return insertItems(args, 1, filter)
+	return insertItems("bf.madd", args, 1, filter)
 }
 
 func BFMExists(args []resp.Value) resp.Value {
@@ -236,8 +268,13 @@ func BFMExists(args []resp.Value) resp.Value {
 		}
 	}
 	key := args[0].Bulk
-	bloomStoreMu.RLock()
-	filter, exists := bloomStore[key]
+	DB.RLock()
+	defer DB.RUnlock()
+	filter, err := lookupBloom(key)
+	if err != nil {
+		return wrongTypeError()
+	}
+	exists := filter != nil
 	resultArray := resp.Value{
 		Typ:   "array",
 		Array: make([]resp.Value, 0),
@@ -263,6 +300,83 @@ func BFMExists(args []resp.Value) resp.Value {
 			}
 		}
 	}
-	bloomStoreMu.RUnlock()
 	return resultArray
 }
+
+// BFScanDump hands out the raw bits of a filter so that it can be restored
+// with BF.LOADCHUNK. Filters are small enough to be dumped in one chunk: an
+// iterator of 0 returns the next iterator (1) together with the data, and
+// iterator 1 returns 0 to signal that the dump is complete.
+func BFScanDump(args []resp.Value) resp.Value {
+	if len(args) != 2 {
+		return resp.Value{
+			Typ: "error",
+			Str: "ERR wrong number of arguments for 'BF.SCANDUMP' command",
+		}
+	}
+	key := args[0].Bulk
+	iterator, err := strconv.Atoi(args[1].Bulk)
+	if err != nil || iterator < 0 {
+		return resp.Value{Typ: "error", Str: "ERR invalid iterator for 'BF.SCANDUMP' command"}
+	}
+
+	DB.RLock()
+	defer DB.RUnlock()
+	filter, err := lookupBloom(key)
+	if err != nil {
+		return wrongTypeError()
+	}
+	if filter == nil {
+		return resp.Value{Typ: "error", Str: "ERR not found"}
+	}
+
+	if iterator > 0 {
+		return resp.Value{
+			Typ: "array",
+			Array: []resp.Value{
+				{Typ: "integer", Num: 0},
+				{Typ: "bulk", Bulk: ""},
+			},
+		}
+	}
+	return resp.Value{
+		Typ: "array",
+		Array: []resp.Value{
+			{Typ: "integer", Num: 1},
+			{Typ: "bulk", Bulk: string(filter.Bytes())},
+		},
+	}
+}
+
+// BFLoadChunk restores a filter from data produced by BF.SCANDUMP, replacing
+// any filter stored at the key.
+func BFLoadChunk(args []resp.Value) resp.Value {
+	if len(args) != 3 {
+		return resp.Value{
+			Typ: "error",
+			Str: "ERR wrong number of arguments for 'BF.LOADCHUNK' command",
+		}
+	}
+	key := args[0].Bulk
+	iterator, err := strconv.Atoi(args[1].Bulk)
+	if err != nil || iterator != 1 {
+		return resp.Value{Typ: "error", Str: "ERR invalid iterator for 'BF.LOADCHUNK' command"}
+	}
+	data := args[2].Bulk
+	if len(data) == 0 {
+		return resp.Value{Typ: "error", Str: "ERR received bad data for 'BF.LOADCHUNK' command"}
+	}
+
+	DB.Lock()
+	defer DB.Unlock()
+	filter, err := lookupBloom(key)
+	if err != nil {
+		return wrongTypeError()
+	}
+	DB.Put(key, store.TypeBloom, store.NewBloomFilterFromBytes([]byte(data)))
+	if filter == nil {
+		Notify(NotifyNew, "new", key)
+	}
+	Notify(NotifyGeneric, "bf.loadchunk", key)
+	return resp.Value{Typ: "string", Str: "OK"}
+}
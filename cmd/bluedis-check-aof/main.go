@@ -0,0 +1,134 @@
+// Command bluedis-check-aof validates an append only file and can repair it by
+// cutting it short before the first bad record.
+//
+// Usage:
+//
+//	bluedis-check-aof [--fix] <file.manifest|file.aof>
+//
+// Given a manifest, every file it lists is checked in order: the base, which
+// may be a snapshot, then the incr files. Given a single file, only that file
+// is checked. Without --fix it only reports whether the AOF is valid and, if
+// not, the offset of the first record that cannot be read. With --fix it asks
+// for confirmation and then truncates the file to the last valid command.
+// Only the last file of a manifest can be fixed that way, since cutting any
+// other short would lose the files after it too.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/IAmRiteshKoushik/bluedis/aof"
+	"github.com/IAmRiteshKoushik/bluedis/rdb"
+	"github.com/IAmRiteshKoushik/bluedis/store"
+)
+
+func main() {
+	fix := false
+	args := os.Args[1:]
+	if len(args) == 2 && args[0] == "--fix" {
+		fix = true
+		args = args[1:]
+	}
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: bluedis-check-aof [--fix] <file.manifest|file.aof>")
+		os.Exit(1)
+	}
+	path := args[0]
+
+	files := []string{path}
+	if strings.HasSuffix(path, ".manifest") {
+		m, err := aof.ReadManifest(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Cannot read the manifest:", err)
+			os.Exit(1)
+		}
+		files = files[:0]
+		for _, entry := range m.Files() {
+			files = append(files, filepath.Join(filepath.Dir(path), entry.Name))
+		}
+		fmt.Printf("Checking the %d files listed in %s\n", len(files), path)
+	}
+
+	for i, file := range files {
+		if strings.HasSuffix(file, ".rdb") {
+			checkSnapshot(file)
+		} else {
+			checkFile(file, fix, i == len(files)-1)
+		}
+	}
+	fmt.Println("AOF is valid")
+}
+
+// checkSnapshot validates a base file written in snapshot format. Snapshots
+// carry a checksum, so there is nothing that could be fixed in one.
+func checkSnapshot(path string) {
+	keys, err := rdb.LoadFile(path, store.NewKeyspace())
+	if err != nil {
+		fmt.Printf("Snapshot %s is not valid: %v\n", path, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Snapshot analyzed: filename=%s, keys=%d\n", path, keys)
+}
+
+// checkFile validates a file of commands, truncating it when asked to and
+// allowed to.
+func checkFile(path string, fix, last bool) {
+	commands, size, err := aof.Check(path)
+	var corrupt *aof.CorruptError
+	if err != nil && !errors.As(err, &corrupt) {
+		fmt.Fprintln(os.Stderr, "Cannot check the AOF:", err)
+		os.Exit(1)
+	}
+
+	okUpTo := size
+	if corrupt != nil {
+		okUpTo = corrupt.Offset
+	}
+	fmt.Printf("AOF analyzed: filename=%s, size=%d, ok_up_to=%d, ok_commands=%d, diff=%d\n",
+		path, size, okUpTo, commands, size-okUpTo)
+
+	if corrupt == nil {
+		return
+	}
+
+	fmt.Printf("First bad record: %v\n", corrupt)
+	if !fix {
+		fmt.Println("AOF is not valid. Use the --fix option to try fixing it.")
+		os.Exit(1)
+	}
+	if !last {
+		fmt.Println("Only the last file of the AOF can be fixed: every file after this one would be lost.")
+		os.Exit(1)
+	}
+
+	if !corrupt.Truncated {
+		fmt.Println("The damage is not at the end of the file: every command after it will be lost as well.")
+	}
+	fmt.Printf("This will shrink the AOF from %d bytes, with %d bytes, to %d bytes\n", size, size-okUpTo, okUpTo)
+	fmt.Print("Continue? [y/N]: ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(answer)), "y") {
+		fmt.Println("Aborting...")
+		os.Exit(1)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err == nil {
+		err = f.Truncate(okUpTo)
+		if err == nil {
+			err = f.Sync()
+		}
+		f.Close()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to truncate the AOF:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Successfully truncated AOF")
+	os.Exit(0)
+}
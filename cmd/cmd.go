@@ -1,47 +1,65 @@
 package cmd
 
 import (
-	"time"
-
 	"github.com/IAmRiteshKoushik/bluedis/resp"
+	"github.com/IAmRiteshKoushik/bluedis/store"
 )
 
-var Handlers = map[string]func([]resp.Value) resp.Value{
-	"PING":    Ping,
-	"SET":     Set,
-	"GET":     Get,
-	"HSET":    Hset,
-	"HGET":    Hget,
-	"HGETALL": Hgetall,
-	"LPUSH":   Lpush,
-	"LPOP":    Lpop,
-	"RPUSH":   Rpush,
-	"RPOP":    Rpop,
-	"LLEN":    Llen,
-	"LRANGE":  Lrange,
-	"BLPOP":   Blpop,
-	"EXPIRE":  ExpireHandler,
-	"DEL":     Delete,
-	"ZADD":    Zadd,
-  "ZREM":    Zrem,
-  "ZRANGE":  Zrange,
-  "ZUPDATE": ZupdateScore,
-  "ZTOPK":   ZtopK,
-  "ZRANKTOP":Zranktop,
-  "ZRANKBOTTOM":Zrankbottom,
-	"SETBIT":   SetBit,
-  "GETBIT":   GetBit,
-  "BITCOUNT": BitCount,
-  "BF.ADD":     BFAdd,
-	"BF.EXISTS":  BFExists,
-	"BF.MADD":    BFMAdd,
-	"BF.MEXISTS": BFMExists,
-	"BF.INSERT":  BFInsert,
-	"BF.RESERVE": BFReserve,
+// DB is the single keyspace shared by all commands. Every key lives here no
+// matter which type of value it holds.
+var DB = store.NewKeyspace()
+
+// handlers holds the built-in commands, described in specs. See Register for
+// adding more.
+var handlers = map[string]Handler{
+	"PING":         Ping,
+	"SET":          Set,
+	"GET":          Get,
+	"HSET":         Hset,
+	"HGET":         Hget,
+	"HGETALL":      Hgetall,
+	"LPUSH":        Lpush,
+	"LPOP":         Lpop,
+	"RPUSH":        Rpush,
+	"RPOP":         Rpop,
+	"LLEN":         Llen,
+	"LRANGE":       Lrange,
+	"BLPOP":        Blpop,
+	"EXPIRE":       ExpireHandler,
+	"PEXPIRE":      Pexpire,
+	"EXPIREAT":     Expireat,
+	"PEXPIREAT":    Pexpireat,
+	"TTL":          Ttl,
+	"PTTL":         Pttl,
+	"EXPIRETIME":   Expiretime,
+	"PEXPIRETIME":  Pexpiretime,
+	"PERSIST":      Persist,
+	"DEL":          Delete,
+	"UNLINK":       Delete,
+	"EXISTS":       Exists,
+	"TYPE":         Type,
+	"ZADD":         Zadd,
+	"ZREM":         Zrem,
+	"ZRANGE":       Zrange,
+	"ZUPDATE":      ZupdateScore,
+	"ZTOPK":        ZtopK,
+	"ZRANKTOP":     Zranktop,
+	"ZRANKBOTTOM":  Zrankbottom,
+	"SETBIT":       SetBit,
+	"GETBIT":       GetBit,
+	"BITCOUNT":     BitCount,
+	"BF.ADD":       BFAdd,
+	"BF.EXISTS":    BFExists,
+	"BF.MADD":      BFMAdd,
+	"BF.MEXISTS":   BFMExists,
+	"BF.INSERT":    BFInsert,
+	"BF.RESERVE":   BFReserve,
+	"BF.SCANDUMP":  BFScanDump,
+	"BF.LOADCHUNK": BFLoadChunk,
 }
 
-type Values struct {
-	Content   string
-	Begone    time.Time
-	HasExpiry bool
+// wrongTypeError is the reply for commands run against a key that holds a
+// different type of value than the command operates on
+func wrongTypeError() resp.Value {
+	return resp.Value{Typ: "error", Str: store.ErrWrongType.Error()}
 }
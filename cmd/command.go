@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/IAmRiteshKoushik/bluedis/resp"
+)
+
+// Handler runs a command given the arguments that follow its name, and
+// returns the reply.
+type Handler func(args []resp.Value) resp.Value
+
+// Spec describes a command to the parts of the server that treat commands
+// differently from each other: the dispatcher checks the arity and parks
+// blocking commands, write commands are logged to the AOF and sent to
+// replicas, COMMAND describes it to clients, and so on. The zero Spec is a
+// command that only reads, takes no keys and any number of arguments.
+type Spec struct {
+	// Number of arguments, counting the command name itself, the same way the
+	// Redis command table does: a positive number is the exact count, a
+	// negative one the minimum. Zero skips the check.
+	Arity int
+
+	Flags Flags
+
+	// Where the keys are among the arguments, counting the command name as
+	// position 0: the first one, the last one, and the step from one to the
+	// next. A negative LastKey counts back from the end, -1 being the last
+	// argument. FirstKey is 0 for commands that take no keys.
+	//
+	// With FlagMovableKeys the argument right before FirstKey is the number
+	// of keys instead, which follow it one after the other, as with the
+	// numkeys of EVAL. LastKey and KeyStep are not used then.
+	FirstKey int
+	LastKey  int
+	KeyStep  int
+
+	// What the command does, in a sentence or two, and the group it belongs
+	// to, such as string or pubsub, for COMMAND DOCS
+	Summary string
+	Group   string
+}
+
+// CheckArity reports whether a command can be called with argc arguments,
+// counting the command name.
+func (s Spec) CheckArity(argc int) bool {
+	if s.Arity < 0 {
+		return argc >= -s.Arity
+	}
+	return s.Arity == 0 || argc == s.Arity
+}
+
+// IsWrite reports whether the command may modify the keyspace.
+func (s Spec) IsWrite() bool {
+	return s.Flags&FlagWrite != 0
+}
+
+// IsBlocking reports whether the command may wait for data before replying.
+func (s Spec) IsBlocking() bool {
+	return s.Flags&FlagBlocking != 0
+}
+
+// Keys returns the keys among the arguments of a command, given in full
+// with the command name first.
+func (s Spec) Keys(args []resp.Value) []string {
+	if s.FirstKey <= 0 || s.FirstKey > len(args) {
+		return nil
+	}
+	last := s.LastKey
+	if last < 0 {
+		last += len(args)
+	}
+	step := max(s.KeyStep, 1)
+	if s.Flags&FlagMovableKeys != 0 {
+		numkeys, err := strconv.Atoi(args[s.FirstKey-1].Bulk)
+		if err != nil || numkeys < 0 {
+			return nil
+		}
+		last, step = s.FirstKey+numkeys-1, 1
+	}
+
+	var keys []string
+	for i := s.FirstKey; i <= last && i < len(args); i += step {
+		keys = append(keys, args[i].Bulk)
+	}
+	return keys
+}
+
+// Guards handlers and specs, which Register can change at any time
+var registryMu sync.RWMutex
+
+// Register adds a command to the server, which runs handler for it. A command
+// already registered under name, built-in or not, is replaced, though the
+// commands the server handles per connection, such as MULTI, cannot be. Names
+// are case insensitive.
+//
+// A write command has to make its changes to DB, whose change counter tells
+// whether there is anything to log: the command is then appended to the AOF
+// and sent to the replicas as is. Commands can be registered while the server
+// is running, and take effect from the next request on; since the AOF is
+// replayed with the commands registered by then, those it holds have to be
+// registered before loading it.
+func Register(name string, spec Spec, handler Handler) {
+	name = strings.ToUpper(name)
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	handlers[name] = handler
+	specs[name] = spec
+}
+
+// Lookup returns the handler and the spec of a registered command, given its
+// name in upper case.
+func Lookup(name string) (Handler, Spec, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	handler, ok := handlers[name]
+	return handler, specs[name], ok
+}
+
+// Specs returns the spec of every registered command by name. The map is a
+// copy.
+func Specs() map[string]Spec {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	all := make(map[string]Spec, len(specs))
+	for name := range handlers {
+		all[name] = specs[name]
+	}
+	return all
+}
+
+// IsWrite reports whether command may modify the keyspace.
+func IsWrite(command string) bool {
+	_, spec, _ := Lookup(command)
+	return spec.IsWrite()
+}
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/IAmRiteshKoushik/bluedis/resp"
+)
+
+// command splits a command line into the arguments a client would send.
+func command(line string) []resp.Value {
+	var args []resp.Value
+	for _, field := range strings.Fields(line) {
+		args = append(args, resp.Value{Typ: "bulk", Bulk: field})
+	}
+	return args
+}
+
+func TestCheckArity(t *testing.T) {
+	tests := []struct {
+		arity int
+		argc  int
+		want  bool
+	}{
+		{arity: 0, argc: 1, want: true},
+		{arity: 0, argc: 7, want: true},
+		{arity: 2, argc: 2, want: true},
+		{arity: 2, argc: 1, want: false},
+		{arity: 2, argc: 3, want: false},
+		{arity: -3, argc: 3, want: true},
+		{arity: -3, argc: 10, want: true},
+		{arity: -3, argc: 2, want: false},
+		{arity: -1, argc: 1, want: true},
+	}
+	for _, tt := range tests {
+		if got := (Spec{Arity: tt.arity}).CheckArity(tt.argc); got != tt.want {
+			t.Errorf("Spec{Arity: %d}.CheckArity(%d) = %v, want %v", tt.arity, tt.argc, got, tt.want)
+		}
+	}
+}
+
+func TestKeys(t *testing.T) {
+	single := Spec{FirstKey: 1, LastKey: 1, KeyStep: 1}
+	all := Spec{FirstKey: 1, LastKey: -1, KeyStep: 1}
+	pairs := Spec{FirstKey: 1, LastKey: -1, KeyStep: 2}
+	allButLast := Spec{FirstKey: 1, LastKey: -2, KeyStep: 1}
+	movable := Spec{Flags: FlagMovableKeys, FirstKey: 3}
+
+	tests := []struct {
+		name string
+		spec Spec
+		line string
+		want []string
+	}{
+		{"no keys", Spec{}, "PING", nil},
+		{"single", single, "GET k", []string{"k"}},
+		{"single with arguments", single, "SET k v EX 10", []string{"k"}},
+		{"single missing", single, "GET", nil},
+		{"all", all, "DEL a b c", []string{"a", "b", "c"}},
+		{"pairs", pairs, "MSET a 1 b 2", []string{"a", "b"}},
+		{"all but last", allButLast, "BLPOP a b 0", []string{"a", "b"}},
+		{"zero step", Spec{FirstKey: 1, LastKey: -1}, "EXISTS a b", []string{"a", "b"}},
+		{"movable", movable, "EVAL body 2 a b x y", []string{"a", "b"}},
+		{"movable none", movable, "EVAL body 0 x y", nil},
+		{"movable short", movable, "EVAL body 3 a b", []string{"a", "b"}},
+		{"movable bad numkeys", movable, "EVAL body two a b", nil},
+		{"movable negative numkeys", movable, "EVAL body -1 a b", nil},
+		{"movable missing numkeys", movable, "EVAL body", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.spec.Keys(command(tt.line)); !slices.Equal(got, tt.want) {
+				t.Errorf("Keys(%q) = %q, want %q", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	spec := Spec{Arity: 2, Flags: FlagWrite, FirstKey: 1, LastKey: 1, KeyStep: 1, Summary: "Test command.", Group: "test"}
+	Register("test.touch", spec, func(args []resp.Value) resp.Value {
+		return resp.Value{Typ: "string", Str: "OK"}
+	})
+
+	handler, got, ok := Lookup("TEST.TOUCH")
+	if !ok {
+		t.Fatal("command not found after Register")
+	}
+	if got != spec {
+		t.Errorf("Lookup returned %+v, want %+v", got, spec)
+	}
+	if reply := handler(command("k")); reply.Str != "OK" {
+		t.Errorf("handler replied %+v", reply)
+	}
+	if !IsWrite("TEST.TOUCH") {
+		t.Errorf("IsWrite = false for a write command")
+	}
+	if _, ok := Specs()["TEST.TOUCH"]; !ok {
+		t.Errorf("command missing from Specs")
+	}
+	if _, _, ok := Lookup("TEST.NONE"); ok {
+		t.Errorf("Lookup found a command never registered")
+	}
+}
+
+// Every built-in command comes with a spec, so that none is dispatched
+// without an arity check or missing from COMMAND
+func TestBuiltinSpecs(t *testing.T) {
+	for name := range handlers {
+		spec, ok := specs[name]
+		if !ok {
+			t.Errorf("%s has no spec", name)
+			continue
+		}
+		if spec.Arity == 0 || spec.Summary == "" || spec.Group == "" {
+			t.Errorf("%s has an incomplete spec: %+v", name, spec)
+		}
+	}
+	if _, spec, _ := Lookup("BF.MADD"); spec.CheckArity(2) || !spec.CheckArity(3) {
+		t.Errorf("BF.MADD accepts arity %d, want at least a key and an item", spec.Arity)
+	}
+	// Replaying the AOF calls the handler without checking the arity first
+	if reply := run("BF.MADD " + t.Name()); reply.Typ != "error" {
+		t.Errorf("BF.MADD without items replied %+v, want an error", reply)
+	}
+}
@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IAmRiteshKoushik/bluedis/resp"
+)
+
+// expireGeneric implements EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT. The number
+// given by the client is scaled by unit, and taken as a Unix timestamp instead
+// of a TTL relative to now when absolute is set. Expiry works the same way for
+// every type of key.
+func expireGeneric(name string, args []resp.Value, unit time.Duration, absolute bool) resp.Value {
+	if len(args) < 2 || len(args) > 3 {
+		return resp.Value{
+			Typ: "error",
+			Str: fmt.Sprintf("ERR wrong number of arguments for '%s' command", name),
+		}
+	}
+
+	key := args[0].Bulk
+	amount, err := strconv.ParseInt(args[1].Bulk, 10, 64)
+	if err != nil {
+		return resp.Value{
+			Typ: "error",
+			Str: "ERR value is not an integer or out of range",
+		}
+	}
+	if amount > math.MaxInt64/int64(unit) || amount < math.MinInt64/int64(unit) {
+		return resp.Value{
+			Typ: "error",
+			Str: fmt.Sprintf("ERR invalid expire time in '%s' command", name),
+		}
+	}
+
+	var flag string
+	if len(args) == 3 {
+		flag = strings.ToUpper(args[2].Bulk)
+		if flag != "NX" && flag != "XX" && flag != "GT" && flag != "LT" {
+			return resp.Value{
+				Typ: "error",
+				Str: "ERR invalid flag value",
+			}
+		}
+	}
+
+	var newExpiry time.Time
+	if absolute {
+		newExpiry = time.Unix(0, 0).Add(time.Duration(amount) * unit)
+	} else {
+		newExpiry = time.Now().Add(time.Duration(amount) * unit)
+	}
+
+	DB.Lock()
+	defer DB.Unlock()
+	entry, ok := DB.Lookup(key)
+	if !ok {
+		return resp.Value{Typ: "integer", Num: 0} // Key does not exist
+	}
+
+	applyExpiry := false
+	switch flag {
+	case "":
+		applyExpiry = true
+	case "NX":
+		if !entry.HasExpiry() {
+			applyExpiry = true
+		}
+	case "XX":
+		if entry.HasExpiry() {
+			applyExpiry = true
+		}
+	case "GT":
+		if !entry.HasExpiry() || newExpiry.After(entry.ExpireAt()) {
+			applyExpiry = true
+		}
+	case "LT":
+		if !entry.HasExpiry() || newExpiry.Before(entry.ExpireAt()) {
+			applyExpiry = true
+		}
+	}
+
+	if applyExpiry {
+		fmt.Println("EXPIRE: key=", key, "expiryTime=", newExpiry)
+		// A deadline in the past deletes the key straight away rather than
+		// leaving it for the expire cycle
+		if !newExpiry.After(time.Now()) {
+			DB.Remove(key)
+			Notify(NotifyGeneric, "del", key)
+		} else {
+			DB.SetExpire(key, newExpiry)
+			Notify(NotifyGeneric, "expire", key)
+		}
+		return resp.Value{Typ: "integer", Num: 1}
+	}
+
+	return resp.Value{Typ: "integer", Num: 0}
+}
+
+func ExpireHandler(args []resp.Value) resp.Value {
+	return expireGeneric("expire", args, time.Second, false)
+}
+
+func Pexpire(args []resp.Value) resp.Value {
+	return expireGeneric("pexpire", args, time.Millisecond, false)
+}
+
+func Expireat(args []resp.Value) resp.Value {
+	return expireGeneric("expireat", args, time.Second, true)
+}
+
+func Pexpireat(args []resp.Value) resp.Value {
+	return expireGeneric("pexpireat", args, time.Millisecond, true)
+}
+
+// ttlGeneric implements TTL and PTTL. It replies -2 when the key does not
+// exist and -1 when it has no TTL.
+func ttlGeneric(name string, args []resp.Value, unit time.Duration) resp.Value {
+	if len(args) != 1 {
+		return resp.Value{
+			Typ: "error",
+			Str: fmt.Sprintf("ERR wrong number of arguments for '%s' command", name),
+		}
+	}
+
+	DB.RLock()
+	defer DB.RUnlock()
+	entry, ok := DB.Lookup(args[0].Bulk)
+	if !ok {
+		return resp.Value{Typ: "integer", Num: -2}
+	}
+	if !entry.HasExpiry() {
+		return resp.Value{Typ: "integer", Num: -1}
+	}
+
+	// Round to the nearest unit the same way Redis does, so that a key set
+	// with EX 10 reports a TTL of 10 rather than 9 right away
+	remaining := time.Until(entry.ExpireAt())
+	return resp.Value{Typ: "integer", Num: int((remaining + unit/2) / unit)}
+}
+
+func Ttl(args []resp.Value) resp.Value {
+	return ttlGeneric("ttl", args, time.Second)
+}
+
+func Pttl(args []resp.Value) resp.Value {
+	return ttlGeneric("pttl", args, time.Millisecond)
+}
+
+// expiretimeGeneric implements EXPIRETIME and PEXPIRETIME, which reply with the
+// absolute Unix time at which the key expires.
+func expiretimeGeneric(name string, args []resp.Value, unit time.Duration) resp.Value {
+	if len(args) != 1 {
+		return resp.Value{
+			Typ: "error",
+			Str: fmt.Sprintf("ERR wrong number of arguments for '%s' command", name),
+		}
+	}
+
+	DB.RLock()
+	defer DB.RUnlock()
+	entry, ok := DB.Lookup(args[0].Bulk)
+	if !ok {
+		return resp.Value{Typ: "integer", Num: -2}
+	}
+	if !entry.HasExpiry() {
+		return resp.Value{Typ: "integer", Num: -1}
+	}
+	return resp.Value{Typ: "integer", Num: int(entry.ExpireAt().UnixNano() / int64(unit))}
+}
+
+func Expiretime(args []resp.Value) resp.Value {
+	return expiretimeGeneric("expiretime", args, time.Second)
+}
+
+func Pexpiretime(args []resp.Value) resp.Value {
+	return expiretimeGeneric("pexpiretime", args, time.Millisecond)
+}
+
+// Persist removes the TTL of a key, replying 1 if there was one to remove.
+func Persist(args []resp.Value) resp.Value {
+	if len(args) != 1 {
+		return resp.Value{
+			Typ: "error",
+			Str: "ERR wrong number of arguments for 'persist' command",
+		}
+	}
+
+	DB.Lock()
+	defer DB.Unlock()
+	if DB.Persist(args[0].Bulk) {
+		Notify(NotifyGeneric, "persist", args[0].Bulk)
+		return resp.Value{Typ: "integer", Num: 1}
+	}
+	return resp.Value{Typ: "integer", Num: 0}
+}
@@ -0,0 +1,226 @@
+package cmd
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpireAnyType(t *testing.T) {
+	keys := holders(t)
+	for typ, key := range keys {
+		if reply := run("EXPIRE " + key + " 100"); reply.Num != 1 {
+			t.Errorf("EXPIRE on a %s replied %+v, want 1", typ, reply)
+		}
+		if reply := run("TTL " + key); reply.Num != 100 {
+			t.Errorf("TTL of a %s = %d, want 100", typ, reply.Num)
+		}
+	}
+}
+
+func TestExpireFlags(t *testing.T) {
+	tests := []struct {
+		name  string
+		ttl   string // TTL the key starts with, if any
+		line  string // EXPIRE command, with %k standing for the key
+		reply int
+		want  int // TTL afterwards
+	}{
+		{"plain", "", "EXPIRE %k 50", 1, 50},
+		{"NX without TTL", "", "EXPIRE %k 50 NX", 1, 50},
+		{"NX with TTL", "100", "EXPIRE %k 50 NX", 0, 100},
+		{"XX without TTL", "", "EXPIRE %k 50 XX", 0, -1},
+		{"XX with TTL", "100", "EXPIRE %k 50 XX", 1, 50},
+		{"GT longer", "100", "EXPIRE %k 200 GT", 1, 200},
+		{"GT shorter", "100", "EXPIRE %k 50 GT", 0, 100},
+		{"LT shorter", "100", "EXPIRE %k 50 LT", 1, 50},
+		{"LT longer", "100", "EXPIRE %k 200 LT", 0, 100},
+		{"flag in lower case", "100", "EXPIRE %k 50 xx", 1, 50},
+		{"PEXPIRE", "", "PEXPIRE %k 50000", 1, 50},
+		{"EXPIREAT", "", "EXPIREAT %k " + strconv.FormatInt(time.Now().Add(50*time.Second).Unix(), 10), 1, 50},
+		{"PEXPIREAT", "", "PEXPIREAT %k " + strconv.FormatInt(time.Now().Add(50*time.Second).UnixMilli(), 10), 1, 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := "expire:" + strings.ReplaceAll(tt.name, " ", "-")
+			run("SET " + key + " v")
+			defer run("DEL " + key)
+			if tt.ttl != "" {
+				run("EXPIRE " + key + " " + tt.ttl)
+			}
+
+			line := strings.ReplaceAll(tt.line, "%k", key)
+			if reply := run(line); reply.Typ != "integer" || reply.Num != tt.reply {
+				t.Errorf("%s replied %+v, want %d", line, reply, tt.reply)
+			}
+			// EXPIREAT takes whole seconds, which may round the TTL down
+			if reply := run("TTL " + key); reply.Num != tt.want && (tt.want < 0 || reply.Num != tt.want-1) {
+				t.Errorf("TTL after %s = %d, want %d", line, reply.Num, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpireErrors(t *testing.T) {
+	run("SET expire:errors v")
+	defer run("DEL expire:errors")
+	for _, line := range []string{
+		"EXPIRE expire:errors",
+		"EXPIRE expire:errors ten",
+		"EXPIRE expire:errors 10 SOON",
+		"EXPIRE expire:errors 10 NX extra",
+		"PEXPIRE expire:errors 9223372036854775807000",
+		"EXPIRE expire:errors 9223372036854775807",
+		"TTL",
+		"PERSIST",
+	} {
+		if reply := run(line); reply.Typ != "error" {
+			t.Errorf("%s replied %+v, want an error", line, reply)
+		}
+	}
+	if reply := run("TTL expire:errors"); reply.Num != -1 {
+		t.Errorf("TTL after failed EXPIREs = %d, want -1", reply.Num)
+	}
+}
+
+func TestTTLReplies(t *testing.T) {
+	run("SET ttl:plain v")
+	run("SET ttl:volatile v EX 10")
+	defer run("DEL ttl:plain ttl:volatile")
+
+	tests := []struct {
+		line string
+		want int
+	}{
+		{"TTL ttl:missing", -2},
+		{"PTTL ttl:missing", -2},
+		{"EXPIRETIME ttl:missing", -2},
+		{"TTL ttl:plain", -1},
+		{"PTTL ttl:plain", -1},
+		{"EXPIRETIME ttl:plain", -1},
+		{"TTL ttl:volatile", 10},
+	}
+	for _, tt := range tests {
+		if reply := run(tt.line); reply.Typ != "integer" || reply.Num != tt.want {
+			t.Errorf("%s replied %+v, want %d", tt.line, reply, tt.want)
+		}
+	}
+
+	if pttl := run("PTTL ttl:volatile").Num; pttl <= 9000 || pttl > 10000 {
+		t.Errorf("PTTL of a key set with EX 10 = %d", pttl)
+	}
+	want := time.Now().Add(10 * time.Second).Unix()
+	if at := int64(run("EXPIRETIME ttl:volatile").Num); at < want-1 || at > want {
+		t.Errorf("EXPIRETIME = %d, want about %d", at, want)
+	}
+	if at, sec := int64(run("PEXPIRETIME ttl:volatile").Num), int64(run("EXPIRETIME ttl:volatile").Num); at/1000 != sec {
+		t.Errorf("PEXPIRETIME = %d, does not match EXPIRETIME %d", at, sec)
+	}
+}
+
+func TestPersist(t *testing.T) {
+	run("SET persist:key v EX 100")
+	defer run("DEL persist:key")
+
+	if reply := run("PERSIST persist:key"); reply.Num != 1 {
+		t.Errorf("PERSIST of a volatile key = %d, want 1", reply.Num)
+	}
+	if reply := run("TTL persist:key"); reply.Num != -1 {
+		t.Errorf("TTL after PERSIST = %d, want -1", reply.Num)
+	}
+	if reply := run("PERSIST persist:key"); reply.Num != 0 {
+		t.Errorf("PERSIST of a key without TTL = %d, want 0", reply.Num)
+	}
+	if reply := run("PERSIST persist:missing"); reply.Num != 0 {
+		t.Errorf("PERSIST of a missing key = %d, want 0", reply.Num)
+	}
+}
+
+func TestSetClearsTTL(t *testing.T) {
+	run("SET set:ttl v EX 100")
+	defer run("DEL set:ttl")
+	run("SET set:ttl w")
+	if reply := run("TTL set:ttl"); reply.Num != -1 {
+		t.Errorf("TTL after SET without EX = %d, want -1", reply.Num)
+	}
+}
+
+func TestExpiredKeysAreGone(t *testing.T) {
+	keys := holders(t)
+	past := strconv.FormatInt(time.Now().Add(-time.Second).Unix(), 10)
+	run("SET expired:soon v PX 20")
+
+	for _, key := range keys {
+		if reply := run("EXPIREAT " + key + " " + past); reply.Num != 1 {
+			t.Fatalf("EXPIREAT %s in the past replied %+v", key, reply)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	for _, key := range append([]string{"expired:soon"}, keys["string"], keys["hash"], keys["list"]) {
+		if reply := run("EXISTS " + key); reply.Num != 0 {
+			t.Errorf("%s exists after its TTL passed", key)
+		}
+		if reply := run("TTL " + key); reply.Num != -2 {
+			t.Errorf("TTL of expired %s = %d, want -2", key, reply.Num)
+		}
+	}
+	if reply := run("GET expired:soon"); reply.Typ != "null" {
+		t.Errorf("GET of an expired key replied %+v, want null", reply)
+	}
+	if reply := run("TYPE " + keys["zset"]); reply.Str != "none" {
+		t.Errorf("TYPE of an expired key = %q, want none", reply.Str)
+	}
+	// An expired key is free to hold a value of another type
+	if reply := run("LPUSH " + keys["string"] + " a"); reply.Typ == "error" {
+		t.Errorf("LPUSH on an expired string replied %+v", reply)
+	}
+}
+
+func TestSetAbsoluteDeadline(t *testing.T) {
+	at := time.Now().Add(50 * time.Second)
+	tests := []struct {
+		line string // SET command, with %k standing for the key
+		ttl  int    // TTL afterwards, -2 if the key is gone
+	}{
+		{"SET %k v EXAT " + strconv.FormatInt(at.Unix(), 10), 50},
+		{"SET %k v PXAT " + strconv.FormatInt(at.UnixMilli(), 10), 50},
+		{"SET %k v pxat " + strconv.FormatInt(at.UnixMilli(), 10), 50},
+		{"SET %k v EXAT 1", -2},
+		{"SET %k v PXAT 1", -2},
+	}
+	for _, tt := range tests {
+		run("SET set:deadline old")
+		line := strings.ReplaceAll(tt.line, "%k", "set:deadline")
+		if reply := run(line); reply.Str != "OK" {
+			t.Errorf("%s replied %+v", line, reply)
+		}
+		// EXAT takes whole seconds, which may round the TTL down
+		if reply := run("TTL set:deadline"); reply.Num != tt.ttl && (tt.ttl < 0 || reply.Num != tt.ttl-1) {
+			t.Errorf("TTL after %s = %d, want %d", line, reply.Num, tt.ttl)
+		}
+	}
+	run("DEL set:deadline")
+
+	for _, line := range []string{"SET set:deadline v PXAT 0", "SET set:deadline v EX -1", "SET set:deadline v PX soon"} {
+		if reply := run(line); reply.Typ != "error" {
+			t.Errorf("%s replied %+v, want an error", line, reply)
+		}
+	}
+}
+
+func TestExpireInThePastDeletes(t *testing.T) {
+	for _, line := range []string{"EXPIRE %k -1", "PEXPIRE %k 0", "EXPIREAT %k 1", "PEXPIREAT %k 1"} {
+		run("SET expire:past v")
+		line = strings.ReplaceAll(line, "%k", "expire:past")
+		before := DB.Len()
+		if reply := run(line); reply.Num != 1 {
+			t.Errorf("%s replied %+v, want 1", line, reply)
+		}
+		// Gone from the keyspace, not just hidden until the expire cycle
+		if DB.Len() != before-1 {
+			t.Errorf("%s left the key stored", line)
+		}
+	}
+}
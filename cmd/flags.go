@@ -0,0 +1,13 @@
+package cmd
+
+// Flags describe what a command does beyond what its handler shows, as part
+// of its Spec. Only write commands are appended to the AOF, for instance.
+type Flags uint
+
+const (
+	FlagWrite       Flags = 1 << iota // May modify the keyspace
+	FlagBlocking                      // May wait for data to show up before replying
+	FlagMovableKeys                   // Says how many keys it takes, see Spec
+	FlagNoScript                      // Cannot be called from scripts
+	FlagNoMulti                       // Cannot be queued between MULTI and EXEC
+)
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+// Write commands that change a value in place have to say so, or they would
+// never be persisted
+func TestWritesTouch(t *testing.T) {
+	keys := holders(t)
+	tests := []struct {
+		line    string // Command, with %s standing for the key of that type
+		typ     string
+		changes bool
+	}{
+		{"SET %s w", "string", true},
+		{"HSET %s f w", "hash", true},
+		{"LPUSH %s b", "list", true},
+		{"RPUSH %s c", "list", true},
+		{"LPOP %s", "list", true},
+		{"RPOP %s", "list", true},
+		{"BLPOP %s 0", "list", true},
+		{"LPOP holder:missing", "", false},
+		{"BLPOP holder:missing 0", "", false},
+		{"ZADD %s 2 b", "zset", true},
+		{"ZUPDATE %s b 3", "zset", true},
+		{"ZUPDATE %s missing 3", "zset", false},
+		{"ZREM %s b", "zset", true},
+		{"ZREM %s missing", "zset", false},
+		{"SETBIT %s 9 1", "bitmap", true},
+		{"BF.ADD %s b", "MBbloom--", true},
+		{"BF.MADD %s c d", "MBbloom--", true},
+		{"EXPIRE %s 100", "string", true},
+		{"EXPIRE holder:missing 100", "", false},
+		{"PERSIST %s", "string", true},
+		{"PERSIST %s", "string", false},
+		{"DEL holder:missing", "", false},
+		{"DEL %s", "hash", true},
+		{"LPUSH %s a", "string", false}, // WRONGTYPE
+		{"GET %s", "string", false},
+		{"LRANGE %s 0 -1", "list", false},
+	}
+	for _, tt := range tests {
+		line := tt.line
+		if tt.typ != "" {
+			line = strings.ReplaceAll(line, "%s", keys[tt.typ])
+		}
+		before := DB.Dirty()
+		run(line)
+		if changed := DB.Dirty() != before; changed != tt.changes {
+			t.Errorf("%s changed the dirty count: %v, want %v", line, changed, tt.changes)
+		}
+	}
+}
+
+func TestFlags(t *testing.T) {
+	for command, spec := range specs {
+		if spec.IsBlocking() && !spec.IsWrite() {
+			t.Errorf("%s blocks without being a write command", command)
+		}
+	}
+	for _, command := range []string{"GET", "HGET", "LRANGE", "TTL", "EXISTS", "BF.EXISTS"} {
+		if IsWrite(command) {
+			t.Errorf("%s is flagged as a write command", command)
+		}
+	}
+	if !specs["BLPOP"].IsBlocking() || specs["LPOP"].IsBlocking() {
+		t.Error("only BLPOP should be flagged as blocking")
+	}
+}
@@ -1,13 +1,12 @@
 package cmd
 
 import (
-	"sync"
-
 	"github.com/IAmRiteshKoushik/bluedis/resp"
+	"github.com/IAmRiteshKoushik/bluedis/store"
 )
 
-var HSETs = make(map[string]map[string]string)
-var HSETsMu = sync.RWMutex{}
+// Hashes are stored in the keyspace as plain maps. They have no lock of their
+// own and are guarded by the keyspace lock instead.
 
 func Hset(args []resp.Value) resp.Value {
 	if len(args) != 3 {
@@ -21,12 +20,19 @@ func Hset(args []resp.Value) resp.Value {
 	key := args[1].Bulk
 	value := args[2].Bulk
 
-	HSETsMu.Lock()
-	defer HSETsMu.Unlock()
-	if _, ok := HSETs[hash]; !ok {
-		HSETs[hash] = make(map[string]string)
+	DB.Lock()
+	defer DB.Unlock()
+	entry, err := DB.LookupType(hash, store.TypeHash)
+	if err != nil {
+		return wrongTypeError()
+	}
+	if entry == nil {
+		entry = DB.Put(hash, store.TypeHash, make(map[string]string))
+		Notify(NotifyNew, "new", hash)
 	}
-	HSETs[hash][key] = value
+	entry.Value.(map[string]string)[key] = value
+	DB.Touch(hash)
+	Notify(NotifyHash, "hset", hash)
 
 	return resp.Value{Typ: "string", Str: "OK"}
 }
@@ -42,9 +48,18 @@ func Hget(args []resp.Value) resp.Value {
 	hash := args[0].Bulk
 	key := args[1].Bulk
 
-	HSETsMu.RLock()
-	value, ok := HSETs[hash][key]
-	HSETsMu.RUnlock()
+	DB.RLock()
+	entry, err := DB.LookupType(hash, store.TypeHash)
+	var value string
+	ok := false
+	if entry != nil {
+		value, ok = entry.Value.(map[string]string)[key]
+	}
+	DB.RUnlock()
+
+	if err != nil {
+		return wrongTypeError()
+	}
 
 	if !ok {
 		return resp.Value{Typ: "null"}
@@ -66,16 +81,19 @@ func Hgetall(args []resp.Value) resp.Value {
 
 	hash := args[0].Bulk
 
-	HSETsMu.RLock()
-	value, ok := HSETs[hash]
-	HSETsMu.RUnlock()
+	DB.RLock()
+	defer DB.RUnlock()
+	entry, err := DB.LookupType(hash, store.TypeHash)
+	if err != nil {
+		return wrongTypeError()
+	}
 
-	if !ok {
+	if entry == nil {
 		return resp.Value{Typ: "null"}
 	}
 
 	resps := []resp.Value{}
-	for k, v := range value {
+	for k, v := range entry.Value.(map[string]string) {
 		resps = append(resps, resp.Value{Typ: "bulk", Bulk: k})
 		resps = append(resps, resp.Value{Typ: "bulk", Bulk: v})
 	}
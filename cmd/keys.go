@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/IAmRiteshKoushik/bluedis/resp"
+)
+
+// Delete removes the given keys whatever type of value they hold and replies
+// with the number of keys that existed. It backs both DEL and UNLINK.
+func Delete(args []resp.Value) resp.Value {
+	if len(args) < 1 {
+		return resp.Value{
+			Typ: "error",
+			Str: "ERR wrong number of arguments for 'del' command",
+		}
+	}
+
+	DB.Lock()
+	defer DB.Unlock()
+
+	deletedCount := 0
+	for _, arg := range args {
+		if DB.Remove(arg.Bulk) {
+			fmt.Println("DEL: key=", arg.Bulk)
+			Notify(NotifyGeneric, "del", arg.Bulk)
+			deletedCount++
+		}
+	}
+	fmt.Println("DEL: deletedCount=", deletedCount)
+	return resp.Value{
+		Typ: "integer",
+		Num: deletedCount,
+	}
+}
+
+// Exists replies with how many of the given keys exist. A key mentioned more
+// than once is counted every time.
+func Exists(args []resp.Value) resp.Value {
+	if len(args) < 1 {
+		return resp.Value{
+			Typ: "error",
+			Str: "ERR wrong number of arguments for 'exists' command",
+		}
+	}
+
+	DB.RLock()
+	defer DB.RUnlock()
+
+	count := 0
+	for _, arg := range args {
+		if _, ok := DB.Lookup(arg.Bulk); ok {
+			count++
+		}
+	}
+	return resp.Value{Typ: "integer", Num: count}
+}
+
+// Type replies with the type of value stored at key, or "none" if the key does
+// not exist.
+func Type(args []resp.Value) resp.Value {
+	if len(args) != 1 {
+		return resp.Value{
+			Typ: "error",
+			Str: "ERR wrong number of arguments for 'type' command",
+		}
+	}
+
+	DB.RLock()
+	entry, ok := DB.Lookup(args[0].Bulk)
+	DB.RUnlock()
+
+	if !ok {
+		return resp.Value{Typ: "string", Str: "none"}
+	}
+	return resp.Value{Typ: "string", Str: entry.Type}
+}
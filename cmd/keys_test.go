@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/IAmRiteshKoushik/bluedis/resp"
+)
+
+// run calls the handler of a command the way the server does, given the
+// command line with its arguments separated by spaces.
+func run(line string) resp.Value {
+	fields := strings.Fields(line)
+	args := make([]resp.Value, len(fields)-1)
+	for i, field := range fields[1:] {
+		args[i] = resp.Value{Typ: "bulk", Bulk: field}
+	}
+	handler, _, _ := Lookup(strings.ToUpper(fields[0]))
+	return handler(args)
+}
+
+// holders creates a key of every type, named after the type, and returns
+// their names by type.
+func holders(t *testing.T) map[string]string {
+	t.Helper()
+	keys := map[string]string{
+		"string":    "holder:string",
+		"hash":      "holder:hash",
+		"list":      "holder:list",
+		"zset":      "holder:zset",
+		"bitmap":    "holder:bitmap",
+		"MBbloom--": "holder:bloom",
+	}
+	for _, line := range []string{
+		"SET holder:string v",
+		"HSET holder:hash f v",
+		"LPUSH holder:list a",
+		"ZADD holder:zset 1 a",
+		"SETBIT holder:bitmap 7 1",
+		"BF.ADD holder:bloom a",
+	} {
+		if reply := run(line); reply.Typ == "error" {
+			t.Fatalf("%s: %s", line, reply.Str)
+		}
+	}
+	t.Cleanup(func() {
+		for _, key := range keys {
+			run("DEL " + key)
+		}
+	})
+	return keys
+}
+
+func TestType(t *testing.T) {
+	keys := holders(t)
+	for typ, key := range keys {
+		if reply := run("TYPE " + key); reply.Str != typ {
+			t.Errorf("TYPE %s = %q, want %q", key, reply.Str, typ)
+		}
+	}
+	if reply := run("TYPE holder:missing"); reply.Str != "none" {
+		t.Errorf("TYPE of a missing key = %q, want none", reply.Str)
+	}
+}
+
+func TestWrongType(t *testing.T) {
+	keys := holders(t)
+	tests := []struct {
+		typ  string // Type of the key the command is run against
+		line string // Command, with %k standing for the key
+	}{
+		{"hash", "GET %k"},
+		{"list", "GET %k"},
+		{"string", "HSET %k f v"},
+		{"list", "HGET %k f"},
+		{"zset", "HGETALL %k"},
+		{"string", "LPUSH %k a"},
+		{"hash", "RPUSH %k a"},
+		{"zset", "LPOP %k"},
+		{"bitmap", "LLEN %k"},
+		{"bloom", "LRANGE %k 0 -1"},
+		{"string", "ZADD %k 1 a"},
+		{"list", "ZRANGE %k 0 -1"},
+		{"string", "SETBIT %k 1 1"},
+		{"hash", "GETBIT %k 1"},
+		{"list", "BITCOUNT %k"},
+		{"string", "BF.ADD %k a"},
+		{"zset", "BF.EXISTS %k a"},
+	}
+	for _, tt := range tests {
+		typ := tt.typ
+		if typ == "bloom" {
+			typ = "MBbloom--"
+		}
+		line := strings.ReplaceAll(tt.line, "%k", keys[typ])
+		t.Run(line, func(t *testing.T) {
+			reply := run(line)
+			if reply.Typ != "error" || !strings.HasPrefix(reply.Str, "WRONGTYPE") {
+				t.Errorf("%s replied %+v, want a WRONGTYPE error", line, reply)
+			}
+			// The key is left as it was
+			if reply := run("TYPE " + keys[typ]); reply.Str != typ {
+				t.Errorf("TYPE after %s = %q, want %q", line, reply.Str, typ)
+			}
+		})
+	}
+}
+
+func TestSetReplacesAnyType(t *testing.T) {
+	keys := holders(t)
+	for _, key := range keys {
+		if reply := run("SET " + key + " replaced"); reply.Str != "OK" {
+			t.Fatalf("SET %s replied %+v", key, reply)
+		}
+		if reply := run("GET " + key); reply.Bulk != "replaced" {
+			t.Errorf("GET %s after SET = %+v", key, reply)
+		}
+	}
+}
+
+func TestDelExists(t *testing.T) {
+	keys := holders(t)
+	if reply := run("EXISTS holder:string holder:list holder:string holder:missing"); reply.Num != 3 {
+		t.Errorf("EXISTS = %d, want 3", reply.Num)
+	}
+
+	line := "DEL holder:missing"
+	for _, key := range keys {
+		line += " " + key
+	}
+	if reply := run(line); reply.Num != len(keys) {
+		t.Errorf("DEL of a key of every type = %d, want %d", reply.Num, len(keys))
+	}
+	for _, key := range keys {
+		if reply := run("EXISTS " + key); reply.Num != 0 {
+			t.Errorf("%s still exists after DEL", key)
+		}
+	}
+	if reply := run("UNLINK holder:string"); reply.Num != 0 {
+		t.Errorf("UNLINK of a deleted key = %d, want 0", reply.Num)
+	}
+}
@@ -3,15 +3,20 @@ package cmd
 import (
 	"fmt"
 	"strconv"
-	"sync"
-	"time"
 
 	"github.com/IAmRiteshKoushik/bluedis/resp"
 	"github.com/IAmRiteshKoushik/bluedis/store"
 )
 
-var ListStore = make(map[string]*store.DoublyLinkedList)
-var ListStoreMu sync.Mutex
+// lookupList returns the list stored at key, or nil if the key does not exist.
+// The caller must hold the keyspace lock.
+func lookupList(key string) (*store.DoublyLinkedList, error) {
+	entry, err := DB.LookupType(key, store.TypeList)
+	if entry == nil {
+		return nil, err
+	}
+	return entry.Value.(*store.DoublyLinkedList), nil
+}
 
 func Lpush(args []resp.Value) resp.Value {
 	if len(args) < 2 {
@@ -21,17 +26,24 @@ func Lpush(args []resp.Value) resp.Value {
 	key := args[0].Bulk
 	elements := args[1:]
 
-	ListStoreMu.Lock()
-	list, exists := ListStore[key]
-	if !exists {
+	DB.Lock()
+	list, err := lookupList(key)
+	if err != nil {
+		DB.Unlock()
+		return wrongTypeError()
+	}
+	if list == nil {
 		list = store.NewDoublyLinkedList()
-		ListStore[key] = list
+		DB.Put(key, store.TypeList, list)
+		Notify(NotifyNew, "new", key)
 	}
 	for _, element := range elements {
 		list.PushLeft(element.Bulk)
 	}
+	DB.Touch(key)
+	Notify(NotifyList, "lpush", key)
 	length := list.Length()
-	ListStoreMu.Unlock()
+	DB.Unlock()
 
 	return resp.Value{Typ: "integer", Num: length}
 }
@@ -44,17 +56,24 @@ func Rpush(args []resp.Value) resp.Value {
 	key := args[0].Bulk
 	elements := args[1:]
 
-	ListStoreMu.Lock()
-	list, exists := ListStore[key]
-	if (!exists) {
+	DB.Lock()
+	list, err := lookupList(key)
+	if err != nil {
+		DB.Unlock()
+		return wrongTypeError()
+	}
+	if list == nil {
 		list = store.NewDoublyLinkedList()
-		ListStore[key] = list
+		DB.Put(key, store.TypeList, list)
+		Notify(NotifyNew, "new", key)
 	}
 	for _, element := range elements {
 		list.PushRight(element.Bulk)
 	}
+	DB.Touch(key)
+	Notify(NotifyList, "rpush", key)
 	length := list.Length()
-	ListStoreMu.Unlock()
+	DB.Unlock()
 
 	return resp.Value{
 		Typ: "integer",
@@ -77,10 +96,13 @@ func Lpop(args []resp.Value) resp.Value {
 		}
 	}
 
-	ListStoreMu.Lock()
-	list, exists := ListStore[key]
-	if !exists || list.Length() == 0 {
-		ListStoreMu.Unlock()
+	DB.Lock()
+	defer DB.Unlock()
+	list, err := lookupList(key)
+	if err != nil {
+		return wrongTypeError()
+	}
+	if list == nil || list.Length() == 0 {
 		return resp.Value{Typ: "null"}
 	}
 
@@ -88,12 +110,17 @@ func Lpop(args []resp.Value) resp.Value {
 	for i := 0; i < count && list.Length() > 0; i++ {
 		value, ok := list.PopLeft()
 		if !ok {
-			ListStoreMu.Unlock()
 			return resp.Value{Typ: "null"}
 		}
 		result = append(result, resp.Value{Typ: "bulk", Bulk: fmt.Sprintf("%v", value)})
 	}
-	ListStoreMu.Unlock()
+	DB.Touch(key)
+	Notify(NotifyList, "lpop", key)
+	// Remove the key if list is empty.
+	if list.Length() == 0 {
+		DB.Remove(key)
+		Notify(NotifyGeneric, "del", key)
+	}
 
 	if len(result) == 1 {
 		return result[0]
@@ -119,10 +146,13 @@ func Rpop(args []resp.Value) resp.Value {
 		}
 	}
 
-	ListStoreMu.Lock()
-	list, exists := ListStore[key]
-	if !exists || list.Length() == 0 {
-		ListStoreMu.Unlock()
+	DB.Lock()
+	defer DB.Unlock()
+	list, err := lookupList(key)
+	if err != nil {
+		return wrongTypeError()
+	}
+	if list == nil || list.Length() == 0 {
 		return resp.Value{Typ: "null"}
 	}
 
@@ -131,7 +161,13 @@ func Rpop(args []resp.Value) resp.Value {
 		value, _ := list.PopRight()
 		result = append(result, resp.Value{Typ: "bulk", Bulk: fmt.Sprintf("%v", value)})
 	}
-	ListStoreMu.Unlock()
+	DB.Touch(key)
+	Notify(NotifyList, "rpop", key)
+	// Remove the key if list is empty.
+	if list.Length() == 0 {
+		DB.Remove(key)
+		Notify(NotifyGeneric, "del", key)
+	}
 
 	if len(result) == 1 {
 		return result[0]
@@ -149,13 +185,17 @@ func Llen(args []resp.Value) resp.Value {
 
 	key := args[0].Bulk
 
-	ListStoreMu.Lock()
-	list, exists := ListStore[key]
+	DB.RLock()
+	list, err := lookupList(key)
 	length := 0
-	if exists {
+	if list != nil {
 		length = list.Length()
 	}
-	ListStoreMu.Unlock()
+	DB.RUnlock()
+
+	if err != nil {
+		return wrongTypeError()
+	}
 
 	return resp.Value{
 		Typ: "integer",
@@ -175,10 +215,13 @@ func Lrange(args []resp.Value) resp.Value {
 		return resp.Value{Typ: "error", Str: "ERR invalid arguments for 'lrange' command"}
 	}
 
-	ListStoreMu.Lock()
-	list, exists := ListStore[key]
-	if !exists {
-		ListStoreMu.Unlock()
+	DB.RLock()
+	defer DB.RUnlock()
+	list, err := lookupList(key)
+	if err != nil {
+		return wrongTypeError()
+	}
+	if list == nil {
 		return resp.Value{
 			Typ:   "array",
 			Array: []resp.Value{},
@@ -190,7 +233,6 @@ func Lrange(args []resp.Value) resp.Value {
 	for i, v := range values {
 		result[i] = resp.Value{Typ: "bulk", Bulk: fmt.Sprintf("%v", v)}
 	}
-	ListStoreMu.Unlock()
 
 	return resp.Value{
 		Typ:   "array",
@@ -198,6 +240,10 @@ func Lrange(args []resp.Value) resp.Value {
 	}
 }
 
+// Blpop pops from the first non-empty list among the given keys. It never
+// waits itself: when every list is empty it replies null and leaves it to the
+// connection serving the client to retry until data arrives or the timeout
+// expires, so a blocked client only ever parks its own goroutine.
 func Blpop(args []resp.Value) resp.Value {
 	if len(args) < 2 {
 		return resp.Value{Typ: "error", Str: "ERR wrong number of arguments for 'blpop' command"}
@@ -210,50 +256,32 @@ func Blpop(args []resp.Value) resp.Value {
 		return resp.Value{Typ: "error", Str: "ERR invalid timeout argument for 'blpop' command"}
 	}
 
-	// Create a ticker for polling and a timer for timeout.
-	ticker := time.NewTicker(50 * time.Millisecond)
-	defer ticker.Stop()
-
-	var timer *time.Timer
-	var timerC <-chan time.Time
-
-	if timeout > 0 {
-		timer = time.NewTimer(time.Duration(timeout) * time.Second)
-		defer timer.Stop()
-		timerC = timer.C
-	}
-
-	for {
-		// Check all keys under lock.
-		ListStoreMu.Lock()
-		for _, key := range keys {
-			list, exists := ListStore[key.Bulk]
-			if exists && list.Length() > 0 {
-				value:= list.BlockingPopLeft()
-				ListStoreMu.Unlock()
-
-				return resp.Value{
-					Typ: "array",
-					Array: []resp.Value{
-						{Typ: "bulk", Bulk: key.Bulk},
-						{Typ: "bulk", Bulk: fmt.Sprintf("%v", value)},
-					},
-				}
-			}
-		}
-		ListStoreMu.Unlock()
-
-		// Wait for either timeout or next tick.
-		select {
-		case <-timerC:
-			return resp.Value{Typ: "null"}
-		case <-ticker.C:
-			// Continue to next iteration.
+	// Check all keys under lock.
+	DB.Lock()
+	defer DB.Unlock()
+	for _, key := range keys {
+		list, err := lookupList(key.Bulk)
+		if err != nil {
+			return wrongTypeError()
 		}
+		if list != nil && list.Length() > 0 {
+			value := list.BlockingPopLeft()
+			DB.Touch(key.Bulk)
+			Notify(NotifyList, "lpop", key.Bulk)
+			if list.Length() == 0 {
+				DB.Remove(key.Bulk)
+				Notify(NotifyGeneric, "del", key.Bulk)
+			}
 
-		// If timeout is 0, return immediately.
-		if timeout == 0 {
-			return resp.Value{Typ: "null"}
+			return resp.Value{
+				Typ: "array",
+				Array: []resp.Value{
+					{Typ: "bulk", Bulk: key.Bulk},
+					{Typ: "bulk", Bulk: fmt.Sprintf("%v", value)},
+				},
+			}
 		}
 	}
+
+	return resp.Value{Typ: "null"}
 }
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// Classes of keyspace events. notify-keyspace-events picks the classes that
+// get published, and where: on the keyspace channel of the key, whose message
+// is the event, or on the keyevent channel of the event, whose message is the
+// key.
+const (
+	NotifyKeyspace = 1 << iota // K: __keyspace@0__:<key>
+	NotifyKeyevent             // E: __keyevent@0__:<event>
+	NotifyGeneric              // g: commands not tied to a type, and Bloom filters
+	NotifyString               // $: string and bitmap commands
+	NotifyList                 // l: list commands
+	NotifySet                  // s: set commands
+	NotifyHash                 // h: hash commands
+	NotifyZSet                 // z: sorted set commands
+	NotifyExpired              // x: a key expired
+	NotifyEvicted              // e: a key was evicted
+	NotifyStream               // t: stream commands
+	NotifyKeyMiss              // m: a key looked up was missing, not reported yet
+	NotifyModule               // d: module key types
+	NotifyNew                  // n: a key was created
+
+	// A: every class but key misses and new keys, which are chatty
+	NotifyAll = NotifyGeneric | NotifyString | NotifyList | NotifySet | NotifyHash |
+		NotifyZSet | NotifyExpired | NotifyEvicted | NotifyStream | NotifyModule
+)
+
+// The flag characters of notify-keyspace-events, in the order Redis prints
+// them back
+var notifyFlagChars = []struct {
+	char  byte
+	class int
+}{
+	{'g', NotifyGeneric},
+	{'$', NotifyString},
+	{'l', NotifyList},
+	{'s', NotifySet},
+	{'h', NotifyHash},
+	{'z', NotifyZSet},
+	{'x', NotifyExpired},
+	{'e', NotifyEvicted},
+	{'t', NotifyStream},
+	{'m', NotifyKeyMiss},
+	{'d', NotifyModule},
+	{'n', NotifyNew},
+	{'K', NotifyKeyspace},
+	{'E', NotifyKeyevent},
+}
+
+// ParseKeyspaceEvents turns the value of notify-keyspace-events into a set of
+// Notify flags. Nothing gets published unless K or E is given along with at
+// least one class.
+func ParseKeyspaceEvents(value string) (int, error) {
+	flags := 0
+	for i := 0; i < len(value); i++ {
+		if value[i] == 'A' {
+			flags |= NotifyAll
+			continue
+		}
+		found := false
+		for _, flag := range notifyFlagChars {
+			if flag.char == value[i] {
+				flags |= flag.class
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("invalid event class character. Use 'Ag$lshzxeKEtmdn'")
+		}
+	}
+	return flags, nil
+}
+
+// FormatKeyspaceEvents turns Notify flags back into the value of
+// notify-keyspace-events.
+func FormatKeyspaceEvents(flags int) string {
+	var b strings.Builder
+	if flags&NotifyAll == NotifyAll {
+		b.WriteByte('A')
+		flags &^= NotifyAll
+	}
+	for _, flag := range notifyFlagChars {
+		if flags&flag.class != 0 {
+			b.WriteByte(flag.char)
+		}
+	}
+	return b.String()
+}
+
+// Classes of events currently published, see SetKeyspaceEvents
+var notifyFlags atomic.Int64
+
+// OnKeyspaceEvent, when set, publishes a keyspace notification to channel.
+// It is called with the keyspace lock held and must not call back into it.
+var OnKeyspaceEvent func(channel, message string)
+
+// SetKeyspaceEvents changes the events that get published to flags, as
+// returned by ParseKeyspaceEvents.
+func SetKeyspaceEvents(flags int) {
+	notifyFlags.Store(int64(flags))
+}
+
+// Notify publishes the keyspace notifications for event happening to key, if
+// its class is enabled. Write commands call it once they changed the key.
+func Notify(class int, event, key string) {
+	flags := int(notifyFlags.Load())
+	if flags&class == 0 || OnKeyspaceEvent == nil {
+		return
+	}
+	if flags&NotifyKeyspace != 0 {
+		OnKeyspaceEvent("__keyspace@0__:"+key, event)
+	}
+	if flags&NotifyKeyevent != 0 {
+		OnKeyspaceEvent("__keyevent@0__:"+event, key)
+	}
+}
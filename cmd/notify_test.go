@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseKeyspaceEvents(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string // As printed back
+		ok    bool
+	}{
+		{"", "", true},
+		{"KEA", "AKE", true},
+		{"Elg", "glE", true},
+		{"A", "A", true},
+		{"Ag$", "A", true},
+		{"Kxn", "xnK", true},
+		{"K?", "", false},
+		{"k", "", false},
+	}
+	for _, tt := range tests {
+		flags, err := ParseKeyspaceEvents(tt.value)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseKeyspaceEvents(%q) = %v, want ok %v", tt.value, err, tt.ok)
+			continue
+		}
+		if got := FormatKeyspaceEvents(flags); tt.ok && got != tt.want {
+			t.Errorf("ParseKeyspaceEvents(%q) prints back as %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+// captureEvents enables the keyspace events in flags for the rest of the test
+// and returns the events published, as "channel message".
+func captureEvents(t *testing.T, flags string) *[]string {
+	t.Helper()
+	parsed, err := ParseKeyspaceEvents(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []string
+	saved := OnKeyspaceEvent
+	OnKeyspaceEvent = func(channel, message string) {
+		events = append(events, channel+" "+message)
+	}
+	SetKeyspaceEvents(parsed)
+	t.Cleanup(func() {
+		OnKeyspaceEvent = saved
+		SetKeyspaceEvents(0)
+	})
+	return &events
+}
+
+func TestNotify(t *testing.T) {
+	withKeyspace(t)
+	tests := []struct {
+		flags string
+		line  string
+		want  []string
+	}{
+		{"KA", "SET s v", []string{"__keyspace@0__:s set"}},
+		{"EA", "SET s w EX 100", []string{"__keyevent@0__:set s", "__keyevent@0__:expire s"}},
+		{"KEA", "PERSIST s", []string{"__keyspace@0__:s persist", "__keyevent@0__:persist s"}},
+		{"Kn", "SET fresh v", []string{"__keyspace@0__:fresh new"}},
+		{"Kn", "SET fresh w", nil},
+		{"Kl", "RPUSH l a", []string{"__keyspace@0__:l rpush"}},
+		{"Kl", "LPOP l", []string{"__keyspace@0__:l lpop"}},
+		{"Kg", "RPUSH l a", nil},
+		{"Kh", "HSET h f v", []string{"__keyspace@0__:h hset"}},
+		{"Kz", "ZADD z 1 a", []string{"__keyspace@0__:z zadd"}},
+		{"K$", "SETBIT b 7 1", []string{"__keyspace@0__:b setbit"}},
+		{"Kg", "BF.ADD f a", []string{"__keyspace@0__:f bf.add"}},
+		{"Kg", "BF.ADD f a", nil}, // Nothing changed
+		{"Kg", "EXPIRE h 100", []string{"__keyspace@0__:h expire"}},
+		{"Kg", "DEL h missing", []string{"__keyspace@0__:h del"}},
+		{"K", "SET s v", nil}, // No class
+		{"A", "SET s v", nil}, // Nowhere to publish
+	}
+	for _, tt := range tests {
+		events := captureEvents(t, tt.flags)
+		if reply := run(tt.line); reply.Typ == "error" {
+			t.Fatalf("%s: %s", tt.line, reply.Str)
+		}
+		if strings.Join(*events, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s with %s published %q, want %q", tt.line, tt.flags, *events, tt.want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/IAmRiteshKoushik/bluedis/resp"
+	"github.com/IAmRiteshKoushik/bluedis/store"
+)
+
+// Maximum number of elements put into a single RPUSH or ZADD when rewriting,
+// so that a huge collection does not turn into one enormous command
+const rewriteItemsPerCmd = 64
+
+// RewriteKeyspace emits a minimal stream of commands that rebuilds ks from an
+// empty database: a FUNCTION LOAD for each function library, then the keys one
+// at a time, followed by a PEXPIREAT with the absolute deadline for keys that
+// have a TTL. It is used to compact the AOF, where the stream replaces the
+// whole history of commands that led to ks.
+func RewriteKeyspace(ks *store.Keyspace, emit func(resp.Value) error) error {
+	libraries := ks.Libraries()
+	names := make([]string, 0, len(libraries))
+	for name := range libraries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := emit(makeCommand("FUNCTION", "LOAD", libraries[name])); err != nil {
+			return err
+		}
+	}
+
+	return ks.ForEach(func(key string, entry *store.Entry) error {
+		if err := rewriteEntry(key, entry, emit); err != nil {
+			return err
+		}
+		if entry.HasExpiry() {
+			deadline := strconv.FormatInt(entry.ExpireAt().UnixMilli(), 10)
+			return emit(makeCommand("PEXPIREAT", key, deadline))
+		}
+		return nil
+	})
+}
+
+func rewriteEntry(key string, entry *store.Entry, emit func(resp.Value) error) error {
+	switch value := entry.Value.(type) {
+	case string:
+		return emit(makeCommand("SET", key, value))
+
+	case map[string]string:
+		// HSET takes a single field at a time
+		for field, v := range value {
+			if err := emit(makeCommand("HSET", key, field, v)); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case *store.DoublyLinkedList:
+		items := make([]string, 0, rewriteItemsPerCmd)
+		for _, element := range value.Values() {
+			items = append(items, fmt.Sprintf("%v", element))
+			if len(items) == rewriteItemsPerCmd {
+				if err := emit(makeCommand("RPUSH", append([]string{key}, items...)...)); err != nil {
+					return err
+				}
+				items = items[:0]
+			}
+		}
+		if len(items) > 0 {
+			return emit(makeCommand("RPUSH", append([]string{key}, items...)...))
+		}
+		return nil
+
+	case *store.SortedSet[string, int64, string]:
+		items := make([]string, 0, 2*rewriteItemsPerCmd)
+		for _, node := range value.Nodes() {
+			items = append(items, strconv.FormatInt(node.Score, 10), node.Value)
+			if len(items) == 2*rewriteItemsPerCmd {
+				if err := emit(makeCommand("ZADD", append([]string{key}, items...)...)); err != nil {
+					return err
+				}
+				items = items[:0]
+			}
+		}
+		if len(items) > 0 {
+			return emit(makeCommand("ZADD", append([]string{key}, items...)...))
+		}
+		return nil
+
+	case *store.StringBitMap:
+		// Clearing the last bit first gives the bitmap its full length, and
+		// keeps the key even if no bit is set; after that, only the bits that
+		// are set need to be replayed
+		data := value.Bytes(key)
+		last := strconv.Itoa(max(len(data)*8-1, 0))
+		if err := emit(makeCommand("SETBIT", key, last, "0")); err != nil {
+			return err
+		}
+		for i, b := range data {
+			for bit := 0; bit < 8; bit++ {
+				if b&(1<<bit) == 0 {
+					continue
+				}
+				pos := strconv.Itoa(i*8 + bit)
+				if err := emit(makeCommand("SETBIT", key, pos, "1")); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+
+	case *store.BloomFilter:
+		return emit(makeCommand("BF.LOADCHUNK", key, "1", string(value.Bytes())))
+	}
+
+	return fmt.Errorf("cannot rewrite key '%s' of type %s", key, entry.Type)
+}
+
+// makeCommand builds a command in the form clients send it: an array of bulk
+// strings starting with the command name.
+func makeCommand(name string, args ...string) resp.Value {
+	command := resp.Value{Typ: "array", Array: make([]resp.Value, 0, len(args)+1)}
+	command.Array = append(command.Array, resp.Value{Typ: "bulk", Bulk: name})
+	for _, arg := range args {
+		command.Array = append(command.Array, resp.Value{Typ: "bulk", Bulk: arg})
+	}
+	return command
+}
@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/IAmRiteshKoushik/bluedis/resp"
+	"github.com/IAmRiteshKoushik/bluedis/store"
+)
+
+// describe renders the type, value and deadline of key in ks, for comparing
+// keys before and after a round trip.
+func describe(ks *store.Keyspace, key string) string {
+	entry, ok := ks.Lookup(key)
+	if !ok {
+		return "none"
+	}
+	var value string
+	switch v := entry.Value.(type) {
+	case string:
+		value = v
+	case map[string]string:
+		value = fmt.Sprint(v)
+	case *store.DoublyLinkedList:
+		value = fmt.Sprint(v.Values())
+	case *store.SortedSet[string, int64, string]:
+		for _, node := range v.Nodes() {
+			value += fmt.Sprintf("%s=%d ", node.Value, node.Score)
+		}
+	case *store.StringBitMap:
+		value = fmt.Sprintf("%x", v.Bytes(key))
+	case *store.BloomFilter:
+		value = fmt.Sprintf("%x", v.Bytes())
+	}
+	if !entry.HasExpiry() {
+		return entry.Type + " " + value
+	}
+	return fmt.Sprintf("%s %s expires at %d", entry.Type, value, entry.ExpireAt().UnixMilli())
+}
+
+// withKeyspace runs the test against an empty keyspace of its own.
+func withKeyspace(t *testing.T) {
+	saved := DB
+	DB = store.NewKeyspace()
+	t.Cleanup(func() { DB = saved })
+}
+
+func TestRewriteKeyspace(t *testing.T) {
+	withKeyspace(t)
+	setup := []string{
+		"SET string v",
+		"SET volatile v EX 100",
+		"HSET hash a 1",
+		"HSET hash b 2",
+		"HSET hash c 3",
+		"SETBIT bitmap 1 1",
+		"SETBIT bitmap 9 1",
+		"SETBIT bitmap 100 1",
+		"SETBIT bitmap 9 0",
+		"SETBIT cleared 100 1", // Every bit cleared again
+		"SETBIT cleared 100 0",
+		"SETBIT padded 3 1", // Longer than its last set bit
+		"SETBIT padded 60 0",
+		"BF.ADD bloom a",
+		"BF.ADD bloom b",
+	}
+	for i := 0; i < 100; i++ {
+		setup = append(setup, fmt.Sprintf("RPUSH list %d", i))
+	}
+	for i := 0; i < 130; i++ {
+		setup = append(setup, fmt.Sprintf("ZADD zset %d m%d", i%7, i))
+	}
+	for _, line := range setup {
+		if reply := run(line); reply.Typ == "error" {
+			t.Fatalf("%s: %s", line, reply.Str)
+		}
+	}
+
+	before := make(map[string]string)
+	for _, key := range DB.Keys() {
+		before[key] = describe(DB, key)
+	}
+
+	var commands []resp.Value
+	err := RewriteKeyspace(DB.Clone(), func(command resp.Value) error {
+		commands = append(commands, command)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("RewriteKeyspace: %v", err)
+	}
+
+	// Collections go in batches rather than one command per element
+	counts := make(map[string]int)
+	for _, command := range commands {
+		counts[command.Array[0].Bulk+" "+command.Array[1].Bulk]++
+	}
+	for command, want := range map[string]int{
+		"SET string":         1,
+		"SET volatile":       1,
+		"PEXPIREAT volatile": 1,
+		"HSET hash":          3,
+		"RPUSH list":         2,
+		"ZADD zset":          3,
+		"BF.LOADCHUNK bloom": 1,
+	} {
+		if counts[command] != want {
+			t.Errorf("rewrite has %d %s, want %d", counts[command], command, want)
+		}
+	}
+
+	// Replaying the stream on an empty keyspace gives the same keys back
+	DB = store.NewKeyspace()
+	for _, command := range commands {
+		name := strings.ToUpper(command.Array[0].Bulk)
+		if reply := handlers[name](command.Array[1:]); reply.Typ == "error" {
+			t.Fatalf("replaying %s: %s", name, reply.Str)
+		}
+	}
+	if DB.Len() != len(before) {
+		t.Errorf("%d keys after replaying the rewrite, want %d", DB.Len(), len(before))
+	}
+	for key, want := range before {
+		if got := describe(DB, key); got != want {
+			t.Errorf("%s after replaying the rewrite:\n got %s\nwant %s", key, got, want)
+		}
+	}
+}
+
+func TestRewriteSkipsExpiredKeys(t *testing.T) {
+	withKeyspace(t)
+	run("SET gone v")
+	run("PEXPIREAT gone 1")
+	run("SET kept v")
+
+	var commands []string
+	RewriteKeyspace(DB.Clone(), func(command resp.Value) error {
+		commands = append(commands, command.Array[0].Bulk+" "+command.Array[1].Bulk)
+		return nil
+	})
+	if strings.Join(commands, ",") != "SET kept" {
+		t.Errorf("rewrite = %q, want only SET kept", commands)
+	}
+}
+
+func TestCloneIsIndependent(t *testing.T) {
+	withKeyspace(t)
+	for _, line := range []string{"SET s v", "HSET h f v", "RPUSH l a", "ZADD z 1 a", "SETBIT b 1 1", "BF.ADD f a"} {
+		run(line)
+	}
+	clone := DB.Clone()
+	want := make(map[string]string)
+	for _, key := range clone.Keys() {
+		want[key] = describe(clone, key)
+	}
+
+	for _, line := range []string{"SET s w", "HSET h f w", "RPUSH l b", "ZADD z 2 b", "SETBIT b 2 1", "BF.ADD f b", "EXPIRE s 100"} {
+		run(line)
+	}
+	for key, want := range want {
+		if got := describe(clone, key); got != want {
+			t.Errorf("clone of %s changed along with the original:\n got %s\nwant %s", key, got, want)
+		}
+	}
+}
+
+func TestRewriteLibraries(t *testing.T) {
+	withKeyspace(t)
+	DB.SetLibrary("b", "#!lua name=b\nredis.register_function('fb', function() end)")
+	DB.SetLibrary("a", "#!lua name=a\nredis.register_function('fa', function() end)")
+	run("SET k v")
+
+	// Libraries come first, in order of their names, so that whatever
+	// follows can call them
+	var commands []string
+	RewriteKeyspace(DB.Clone(), func(command resp.Value) error {
+		commands = append(commands, command.Array[0].Bulk+" "+command.Array[1].Bulk)
+		if command.Array[0].Bulk == "FUNCTION" {
+			commands[len(commands)-1] += " " + strings.Fields(command.Array[2].Bulk)[1]
+		}
+		return nil
+	})
+	if got := strings.Join(commands, ","); got != "FUNCTION LOAD name=a,FUNCTION LOAD name=b,SET k" {
+		t.Errorf("rewrite = %q", got)
+	}
+}
@@ -2,24 +2,19 @@ package cmd
 
 import (
 	"strconv"
-	"sync"
 
 	"github.com/IAmRiteshKoushik/bluedis/resp"
 	"github.com/IAmRiteshKoushik/bluedis/store"
 )
 
-var sortedSetStore = make(map[string]*store.SortedSet[string, int64, string])
-var sortedSetStoreMu sync.Mutex
-
-func getOrCreateSortedSet(key string) *store.SortedSet[string, int64, string] {
-    sortedSetStoreMu.Lock()
-    defer sortedSetStoreMu.Unlock()
-    zset, exists := sortedSetStore[key]
-    if !exists {
-        zset = store.NewSortedSet[string, int64, string]()
-        sortedSetStore[key] = zset
-    }
-    return zset
+// lookupSortedSet returns the sorted set stored at key, or nil if the key does
+// not exist. The caller must hold the keyspace lock.
+func lookupSortedSet(key string) (*store.SortedSet[string, int64, string], error) {
+    entry, err := DB.LookupType(key, store.TypeZSet)
+    if entry == nil {
+        return nil, err
+    }
+    return entry.Value.(*store.SortedSet[string, int64, string]), nil
 }
 
 func Zadd(args []resp.Value) resp.Value {
@@ -27,15 +22,27 @@ func Zadd(args []resp.Value) resp.Value {
         return resp.Value{Typ: "error", Str: "ERR wrong number of arguments for 'zadd' command"}
     }
     key := args[0].Bulk
-    zset := getOrCreateSortedSet(key)
-    sortedSetStoreMu.Lock()
-    defer sortedSetStoreMu.Unlock()
-    count := 0
+    // Validate every score up front so that a bad one does not leave the set
+    // half updated (or an empty set behind)
     for i := 1; i < len(args); i += 2 {
-        score, err := strconv.ParseInt(args[i].Bulk, 10, 64)
-        if err != nil {
+        if _, err := strconv.ParseInt(args[i].Bulk, 10, 64); err != nil {
             return resp.Value{Typ: "error", Str: "ERR invalid score value for 'zadd' command"}
         }
+    }
+    DB.Lock()
+    defer DB.Unlock()
+    zset, err := lookupSortedSet(key)
+    if err != nil {
+        return wrongTypeError()
+    }
+    if zset == nil {
+        zset = store.NewSortedSet[string, int64, string]()
+        DB.Put(key, store.TypeZSet, zset)
+        Notify(NotifyNew, "new", key)
+    }
+    count := 0
+    for i := 1; i < len(args); i += 2 {
+        score, _ := strconv.ParseInt(args[i].Bulk, 10, 64)
         member := args[i+1].Bulk
         _, exists := zset.Dict[member]
         zset.AddOrUpdate(member, score, member)
@@ -43,6 +50,8 @@ func Zadd(args []resp.Value) resp.Value {
             count++
         }
     }
+    DB.Touch(key)
+    Notify(NotifyZSet, "zadd", key)
     return resp.Value{Typ: "integer", Num: count}
 }
 
@@ -51,15 +60,15 @@ func Zrem(args []resp.Value) resp.Value {
         return resp.Value{Typ: "error", Str: "ERR wrong number of arguments for 'zrem' command"}
     }
     key := args[0].Bulk
-    sortedSetStoreMu.Lock()
-    zset, exists := sortedSetStore[key]
-    sortedSetStoreMu.Unlock()
-
-    if !exists {
+    DB.Lock()
+    defer DB.Unlock()
+    zset, err := lookupSortedSet(key)
+    if err != nil {
+        return wrongTypeError()
+    }
+    if zset == nil {
         return resp.Value{Typ: "integer", Num: 0}
     }
-    sortedSetStoreMu.Lock()
-    defer sortedSetStoreMu.Unlock()
     count := 0
     for i := 1; i < len(args); i++ {
         member := args[i].Bulk
@@ -69,6 +78,14 @@ func Zrem(args []resp.Value) resp.Value {
             count++
         }
     }
+    if count > 0 {
+        DB.Touch(key)
+        Notify(NotifyZSet, "zrem", key)
+    }
+    if zset.Length == 0 {
+        DB.Remove(key)
+        Notify(NotifyGeneric, "del", key)
+    }
     return resp.Value{Typ: "integer", Num: count}
 }
 
@@ -82,14 +99,15 @@ func Zrange(args []resp.Value) resp.Value {
     if err1 != nil || err2 != nil {
         return resp.Value{Typ: "error", Str: "ERR invalid range values for 'zrange' command"}
     }
-    sortedSetStoreMu.Lock()
-    zset, exists := sortedSetStore[key]
-    sortedSetStoreMu.Unlock()
-    if !exists {
+    DB.RLock()
+    defer DB.RUnlock()
+    zset, err := lookupSortedSet(key)
+    if err != nil {
+        return wrongTypeError()
+    }
+    if zset == nil {
         return resp.Value{Typ: "array", Array: []resp.Value{}}
     }
-    sortedSetStoreMu.Lock()
-    defer sortedSetStoreMu.Unlock()
     members := zset.GetRangeByRank(start, end, false)
     result := make([]resp.Value, 0, len(members))
     for _, member := range members {
@@ -108,16 +126,19 @@ func ZupdateScore(args []resp.Value) resp.Value {
     if err != nil {
         return resp.Value{Typ: "error", Str: "ERR invalid score value for 'zupdateScore' command"}
     }
-    sortedSetStoreMu.Lock()
-    zset, exists := sortedSetStore[key]
-    sortedSetStoreMu.Unlock()
-    if !exists {
+    DB.Lock()
+    defer DB.Unlock()
+    zset, err := lookupSortedSet(key)
+    if err != nil {
+        return wrongTypeError()
+    }
+    if zset == nil {
         return resp.Value{Typ: "error", Str: "ERR sorted set does not exist"}
     }
-    sortedSetStoreMu.Lock()
-    defer sortedSetStoreMu.Unlock()
     if _, exists := zset.Dict[member]; exists {
         zset.AddOrUpdate(member, newScore, member)
+        DB.Touch(key)
+        Notify(NotifyZSet, "zupdate", key)
         return resp.Value{Typ: "string", Str: "OK"}
     }
     return resp.Value{Typ: "error", Str: "ERR member does not exist in sorted set"}
@@ -133,14 +154,15 @@ func ZtopK(args []resp.Value) resp.Value {
     if err != nil || k <= 0 {
         return resp.Value{Typ: "error", Str: "ERR invalid value for K"}
     }
-    sortedSetStoreMu.Lock()
-    zset, exists := sortedSetStore[key]
-    sortedSetStoreMu.Unlock()
-    if !exists {
+    DB.RLock()
+    defer DB.RUnlock()
+    zset, err := lookupSortedSet(key)
+    if err != nil {
+        return wrongTypeError()
+    }
+    if zset == nil {
         return resp.Value{Typ: "array", Array: []resp.Value{}}
     }
-    sortedSetStoreMu.Lock()
-    defer sortedSetStoreMu.Unlock()
     members := zset.GetRangeByRank(0, k, false)
 	result := make([]resp.Value, 0, len(members))
     for _, member := range members {
@@ -154,10 +176,13 @@ func Zranktop(args []resp.Value) resp.Value {
     }
     key := args[0].Bulk
     member := args[1].Bulk
-    sortedSetStoreMu.Lock()
-    zset, exists := sortedSetStore[key]
-    sortedSetStoreMu.Unlock()
-    if !exists {
+    DB.RLock()
+    defer DB.RUnlock()
+    zset, err := lookupSortedSet(key)
+    if err != nil {
+        return wrongTypeError()
+    }
+    if zset == nil {
         return resp.Value{Typ: "error", Str: "ERR sorted set does not exist"}
     }
     rank, found := zset.FindRank(member, true)
@@ -172,10 +197,13 @@ func Zrankbottom(args []resp.Value) resp.Value {
     }
     key := args[0].Bulk
     member := args[1].Bulk
-    sortedSetStoreMu.Lock()
-    zset, exists := sortedSetStore[key]
-    sortedSetStoreMu.Unlock()
-    if !exists {
+    DB.RLock()
+    defer DB.RUnlock()
+    zset, err := lookupSortedSet(key)
+    if err != nil {
+        return wrongTypeError()
+    }
+    if zset == nil {
         return resp.Value{Typ: "error", Str: "ERR sorted set does not exist"}
     }
     rank, found := zset.FindRank(member, false)
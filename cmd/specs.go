@@ -0,0 +1,51 @@
+package cmd
+
+// specs describes the commands of handlers. The columns are those of Spec:
+// arity, flags, the first key, the last key and the step between keys, then
+// the summary and the group.
+var specs = map[string]Spec{
+	"PING":         {-1, 0, 0, 0, 0, "Returns the server's liveliness response.", "connection"},
+	"SET":          {-3, FlagWrite, 1, 1, 1, "Sets the string value of a key, ignoring its type. The key is created if it doesn't exist.", "string"},
+	"GET":          {2, 0, 1, 1, 1, "Returns the string value of a key.", "string"},
+	"HSET":         {4, FlagWrite, 1, 1, 1, "Creates or modifies the value of a field in a hash.", "hash"},
+	"HGET":         {3, 0, 1, 1, 1, "Returns the value of a field in a hash.", "hash"},
+	"HGETALL":      {2, 0, 1, 1, 1, "Returns all fields and values in a hash.", "hash"},
+	"LPUSH":        {-3, FlagWrite, 1, 1, 1, "Prepends one or more elements to a list. Creates the key if it doesn't exist.", "list"},
+	"LPOP":         {-2, FlagWrite, 1, 1, 1, "Returns the first elements in a list after removing it. Deletes the list if the last element was popped.", "list"},
+	"RPUSH":        {-3, FlagWrite, 1, 1, 1, "Appends one or more elements to a list. Creates the key if it doesn't exist.", "list"},
+	"RPOP":         {-2, FlagWrite, 1, 1, 1, "Returns and removes the last elements of a list. Deletes the list if the last element was popped.", "list"},
+	"LLEN":         {2, 0, 1, 1, 1, "Returns the length of a list.", "list"},
+	"LRANGE":       {4, 0, 1, 1, 1, "Returns a range of elements from a list.", "list"},
+	"BLPOP":        {-3, FlagWrite | FlagBlocking, 1, -2, 1, "Removes and returns the first element in a list. Blocks until an element is available otherwise. Deletes the list if the last element was popped.", "list"},
+	"EXPIRE":       {-3, FlagWrite, 1, 1, 1, "Sets the expiration time of a key in seconds.", "generic"},
+	"PEXPIRE":      {-3, FlagWrite, 1, 1, 1, "Sets the expiration time of a key in milliseconds.", "generic"},
+	"EXPIREAT":     {-3, FlagWrite, 1, 1, 1, "Sets the expiration time of a key to a Unix timestamp.", "generic"},
+	"PEXPIREAT":    {-3, FlagWrite, 1, 1, 1, "Sets the expiration time of a key to a Unix milliseconds timestamp.", "generic"},
+	"TTL":          {2, 0, 1, 1, 1, "Returns the expiration time in seconds of a key.", "generic"},
+	"PTTL":         {2, 0, 1, 1, 1, "Returns the expiration time in milliseconds of a key.", "generic"},
+	"EXPIRETIME":   {2, 0, 1, 1, 1, "Returns the expiration time of a key as a Unix timestamp.", "generic"},
+	"PEXPIRETIME":  {2, 0, 1, 1, 1, "Returns the expiration time of a key as a Unix milliseconds timestamp.", "generic"},
+	"PERSIST":      {2, FlagWrite, 1, 1, 1, "Removes the expiration time of a key.", "generic"},
+	"DEL":          {-2, FlagWrite, 1, -1, 1, "Deletes one or more keys.", "generic"},
+	"UNLINK":       {-2, FlagWrite, 1, -1, 1, "Deletes one or more keys.", "generic"},
+	"EXISTS":       {-2, 0, 1, -1, 1, "Determines whether one or more keys exist.", "generic"},
+	"TYPE":         {2, 0, 1, 1, 1, "Determines the type of value stored at a key.", "generic"},
+	"ZADD":         {-4, FlagWrite, 1, 1, 1, "Adds one or more members to a sorted set, or updates their scores. Creates the key if it doesn't exist.", "sorted-set"},
+	"ZREM":         {-3, FlagWrite, 1, 1, 1, "Removes one or more members from a sorted set. Deletes the sorted set if all members were removed.", "sorted-set"},
+	"ZRANGE":       {4, 0, 1, 1, 1, "Returns members in a sorted set within a range of indexes.", "sorted-set"},
+	"ZUPDATE":      {4, FlagWrite, 1, 1, 1, "Changes the score of a member of a sorted set.", "sorted-set"},
+	"ZTOPK":        {3, 0, 1, 1, 1, "Returns the members of a sorted set with the highest scores.", "sorted-set"},
+	"ZRANKTOP":     {3, 0, 1, 1, 1, "Returns the rank of a member of a sorted set, counting from the highest score.", "sorted-set"},
+	"ZRANKBOTTOM":  {3, 0, 1, 1, 1, "Returns the rank of a member of a sorted set, counting from the lowest score.", "sorted-set"},
+	"SETBIT":       {4, FlagWrite, 1, 1, 1, "Sets or clears the bit at offset of the string value. Creates the key if it doesn't exist.", "bitmap"},
+	"GETBIT":       {3, 0, 1, 1, 1, "Returns a bit value by offset.", "bitmap"},
+	"BITCOUNT":     {2, 0, 1, 1, 1, "Counts the number of set bits (population counting) in a string.", "bitmap"},
+	"BF.ADD":       {3, FlagWrite, 1, 1, 1, "Adds an item to a Bloom Filter.", "bf"},
+	"BF.EXISTS":    {3, 0, 1, 1, 1, "Checks whether an item exists in a Bloom Filter.", "bf"},
+	"BF.MADD":      {-3, FlagWrite, 1, 1, 1, "Adds one or more items to a Bloom Filter. A filter will be created if it does not exist.", "bf"},
+	"BF.MEXISTS":   {-3, 0, 1, 1, 1, "Checks whether one or more items exist in a Bloom Filter.", "bf"},
+	"BF.INSERT":    {-3, FlagWrite, 1, 1, 1, "Adds one or more items to a Bloom Filter. A filter will be created if it does not exist.", "bf"},
+	"BF.RESERVE":   {3, FlagWrite, 1, 1, 1, "Creates a new Bloom Filter.", "bf"},
+	"BF.SCANDUMP":  {3, 0, 1, 1, 1, "Begins an incremental save of the bloom filter.", "bf"},
+	"BF.LOADCHUNK": {4, FlagWrite, 1, 1, 1, "Restores a filter previously saved using SCANDUMP.", "bf"},
+}
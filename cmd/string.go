@@ -2,17 +2,15 @@ package cmd
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/IAmRiteshKoushik/bluedis/resp"
+	"github.com/IAmRiteshKoushik/bluedis/store"
 )
 
-var SETs = make(map[string]Values)
-var SETsMu = sync.RWMutex{}
-
 func Set(args []resp.Value) resp.Value {
 	if len(args) < 2 {
 		return resp.Value{
@@ -22,113 +20,60 @@ func Set(args []resp.Value) resp.Value {
 	}
 
 	key := args[0].Bulk
-	value := Values{Content: args[1].Bulk}
-	expiry := false
+	content := args[1].Bulk
+	var begone time.Time
 
 	for i := 2; i < len(args); i += 2 {
 		if i+1 < len(args) {
-			switch strings.ToUpper(args[i].Bulk) {
-			case "PX":
-				expiry = true
-				ms, err := strconv.ParseInt(args[i+1].Bulk, 10, 64)
-				if err != nil {
-					return resp.Value{Typ: "error", Str: "ERR invalid PX value"}
-				}
-				value.Begone = time.Now().Add(time.Duration(ms) * time.Millisecond)
-			case "EX":
-				expiry = true
-				s, err := strconv.Atoi(args[i+1].Bulk)
-				if err != nil {
-					return resp.Value{Typ: "error", Str: "ERR invalid EX value"}
-				}
-				value.Begone = time.Now().Add(time.Duration(s) * time.Second)
+			option := strings.ToUpper(args[i].Bulk)
+			var unit time.Duration
+			switch option {
+			case "EX", "EXAT":
+				unit = time.Second
+			case "PX", "PXAT":
+				unit = time.Millisecond
+			default:
+				continue
 			}
-		}
-	}
-
-	value.HasExpiry = expiry
-
-	SETsMu.Lock()
-	SETs[key] = value
-	SETsMu.Unlock()
-
-	fmt.Printf("SET: key=%s, value=%s, expiry=%v, Begone=%v\n", key, value.Content, value.HasExpiry, value.Begone)
-
-	return resp.Value{Typ: "string", Str: "OK"}
-}
-
-func ExpireHandler(args []resp.Value) resp.Value {
-	if len(args) < 2 || len(args) > 3 {
-		return resp.Value{
-			Typ: "error",
-			Str: "ERR wrong number of arguments for 'expire' command",
-		}
-	}
-
-	key := args[0].Bulk
-	seconds, err := strconv.Atoi(args[1].Bulk)
-	if err != nil {
-		return resp.Value{
-			Typ: "error",
-			Str: "ERR value is not an integer or out of range",
-		}
-	}
-
-	var flag string
-	if len(args) == 3 {
-		flag = strings.ToUpper(args[2].Bulk)
-		if flag != "NX" && flag != "XX" && flag != "GT" && flag != "LT" {
-			return resp.Value{
-				Typ: "error",
-				Str: "ERR invalid flag value",
+			n, err := strconv.ParseInt(args[i+1].Bulk, 10, 64)
+			if err != nil || n <= 0 || n > math.MaxInt64/int64(unit) {
+				return resp.Value{Typ: "error", Str: "ERR invalid expire time in 'set' command"}
+			}
+			// EXAT and PXAT take a Unix timestamp rather than a TTL. They are
+			// also what a SET with a TTL is logged as in the AOF.
+			if strings.HasSuffix(option, "AT") {
+				begone = time.UnixMilli(0).Add(time.Duration(n) * unit)
+			} else {
+				begone = time.Now().Add(time.Duration(n) * unit)
 			}
 		}
 	}
 
-	SETsMu.Lock()
-	defer SETsMu.Unlock()
-	value, ok := SETs[key]
-	if !ok {
-		return resp.Value{Typ: "integer", Num: 0} // Key does not exist
-	}
-
-	now := time.Now()
-	newExpiry := now.Add(time.Duration(seconds) * time.Second)
-
-	applyExpiry := false
-	switch flag {
-	case "":
-		applyExpiry = true
-	case "NX":
-		if !value.HasExpiry {
-			applyExpiry = true
-		}
-	case "XX":
-		if value.HasExpiry {
-			applyExpiry = true
+	// SET overwrites the key whatever type of value (and TTL) it held before.
+	// A deadline that has already passed, which happens when replaying the
+	// AOF after the server was down for a while, leaves no key at all.
+	DB.Lock()
+	_, existed := DB.Lookup(key)
+	if !begone.IsZero() && !begone.After(time.Now()) {
+		DB.Remove(key)
+		Notify(NotifyString, "set", key)
+		Notify(NotifyGeneric, "del", key)
+	} else {
+		DB.Put(key, store.TypeString, content)
+		if !existed {
+			Notify(NotifyNew, "new", key)
 		}
-	case "GT":
-		if !value.HasExpiry || newExpiry.After(value.Begone) {
-			applyExpiry = true
-		}
-	case "LT":
-		if !value.HasExpiry || newExpiry.Before(value.Begone) {
-			applyExpiry = true
+		Notify(NotifyString, "set", key)
+		if !begone.IsZero() {
+			DB.SetExpire(key, begone)
+			Notify(NotifyGeneric, "expire", key)
 		}
 	}
+	DB.Unlock()
 
-	fmt.Println("EXPIRE: key=", key, "expiryTime=", newExpiry, "SETs[key]=", SETs[key])
-
-	if applyExpiry {
-		value.HasExpiry = true
-		value.Begone = newExpiry
-		SETs[key] = value
-		return resp.Value{Typ: "integer", Num: 1}
-	}
+	fmt.Printf("SET: key=%s, value=%s, expiry=%v, Begone=%v\n", key, content, !begone.IsZero(), begone)
 
-	fmt.Println("EXPIRE: key=", key, "expiryTime=", newExpiry, "SETs[key]=", SETs[key])
-
-	return resp.Value{Typ: "integer", Num: 0}
+	return resp.Value{Typ: "string", Str: "OK"}
 }
 
 func Get(args []resp.Value) resp.Value {
@@ -140,69 +85,34 @@ func Get(args []resp.Value) resp.Value {
 	}
 
 	key := args[0].Bulk
-	SETsMu.RLock()
-	value, ok := SETs[key]
-	SETsMu.RUnlock()
+	DB.RLock()
+	entry, err := DB.LookupType(key, store.TypeString)
+	var content string
+	if entry != nil {
+		content = entry.Value.(string)
+	}
+	stale := DB.Expired(key)
+	DB.RUnlock()
 
-	if ok && value.HasExpiry && time.Now().After(value.Begone) {
-		// Key needs to be-gone for good
-		SETsMu.Lock()
-		delete(SETs, key)
-		SETsMu.Unlock()
+	if err != nil {
+		return wrongTypeError()
+	}
+
+	if stale {
+		// Key needs to be-gone for good. ExpireIfNeeded checks again under the
+		// write lock in case another client replaced it in the meantime.
+		DB.Lock()
+		DB.ExpireIfNeeded(key)
+		DB.Unlock()
 		return resp.Value{Typ: "null"}
 	}
 
-	if !ok {
+	if entry == nil {
 		return resp.Value{Typ: "null"}
 	}
 
 	return resp.Value{
 		Typ:  "bulk",
-		Bulk: value.Content,
+		Bulk: content,
 	}
 }
-
-func Delete(args []resp.Value) resp.Value {
-    if len(args) < 1 {
-        return resp.Value{
-            Typ: "error",
-            Str: "ERR wrong number of arguments for 'del' command",
-        }
-    }
-    deletedCount := 0
-    for _, arg := range args {
-        key := arg.Bulk
-
-        // Remove key from SETs
-        SETsMu.Lock()
-        if _, ok := SETs[key]; ok {
-            delete(SETs, key)
-            fmt.Println("DEL: key=", key)
-            deletedCount++
-        }
-        SETsMu.Unlock()
-
-        // Remove key from listStore
-        ListStoreMu.Lock()
-        if _, ok := ListStore[key]; ok {
-            delete(ListStore, key)
-            fmt.Println("DEL: list key=", key)
-            deletedCount++
-        }
-        ListStoreMu.Unlock()
-
-		// Remove key from bitMapStore
-		BitMapStoreMu.Lock()
-		if _, ok := BitMapStore[key]; ok {
-			delete(BitMapStore, key)
-			fmt.Println("DEL: bitMap key=", key)
-			deletedCount++
-		}
-		BitMapStoreMu.Unlock()
-    }
-    fmt.Println("DEL: deletedCount=", deletedCount)
-    return resp.Value{
-        Typ: "integer",
-        Num: deletedCount,
-    }
-}
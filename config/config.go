@@ -0,0 +1,460 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/IAmRiteshKoushik/bluedis/aof"
+	"github.com/IAmRiteshKoushik/bluedis/cmd"
+)
+
+// Config holds the server settings. They start out with the defaults below,
+// can be overridden by a redis.conf style file and by command line options,
+// and the mutable ones can be changed at runtime with CONFIG SET.
+type Config struct {
+	mu sync.RWMutex
+
+	Port                     int
+	AppendFilename           string
+	AppendDirname            string
+	AppendFsync              string
+	AutoAofRewritePercentage int
+	AutoAofRewriteMinSize    int64
+	AofLoadTruncated         bool
+	AofUseRDBPreamble        bool
+	AofArchiveHistory        bool
+	DBFilename               string
+	SaveRules                []SaveRule
+	ReplicaReadOnly          bool
+	ReplBacklogSize          int64
+	NotifyKeyspaceEvents     int // cmd.Notify flags
+}
+
+// SaveRule asks for a snapshot once at least Changes writes happened and
+// Seconds passed since the last one.
+type SaveRule struct {
+	Seconds int
+	Changes int64
+}
+
+// Default returns the configuration used when nothing else is specified.
+func Default() *Config {
+	return &Config{
+		Port:                     6379,
+		AppendFilename:           "database.aof",
+		AppendDirname:            "appendonlydir",
+		AppendFsync:              aof.FsyncEverySec,
+		AutoAofRewritePercentage: aof.DefaultRewritePercentage,
+		AutoAofRewriteMinSize:    aof.DefaultRewriteMinSize,
+		AofLoadTruncated:         true,
+		AofUseRDBPreamble:        true,
+		DBFilename:               "dump.rdb",
+		SaveRules:                []SaveRule{{3600, 1}, {300, 100}, {60, 10000}},
+		ReplicaReadOnly:          true,
+		ReplBacklogSize:          1024 * 1024,
+	}
+}
+
+// param describes a single setting by the name used in config files, CONFIG
+// GET and CONFIG SET.
+type param struct {
+	name    string
+	mutable bool // Whether CONFIG SET may change it while running
+	multi   bool // Repeated lines in a config file add up instead of replacing each other
+	get     func(c *Config) string
+	set     func(c *Config, value string) error
+}
+
+var params = []param{
+	{
+		name: "port",
+		get:  func(c *Config) string { return strconv.Itoa(c.Port) },
+		set: func(c *Config, value string) error {
+			port, err := strconv.Atoi(value)
+			if err != nil || port < 0 || port > 65535 {
+				return fmt.Errorf("argument must be between 0 and 65535")
+			}
+			c.Port = port
+			return nil
+		},
+	},
+	{
+		name: "appendfilename",
+		get:  func(c *Config) string { return c.AppendFilename },
+		set: func(c *Config, value string) error {
+			if value == "" || strings.ContainsRune(value, '/') {
+				return fmt.Errorf("appendfilename can't be a path, just a filename")
+			}
+			// The manifest separates its fields with spaces
+			if strings.ContainsAny(value, " \t") {
+				return fmt.Errorf("appendfilename can't contain spaces")
+			}
+			c.AppendFilename = value
+			return nil
+		},
+	},
+	{
+		name: "appenddirname",
+		get:  func(c *Config) string { return c.AppendDirname },
+		set: func(c *Config, value string) error {
+			if value == "" || strings.ContainsRune(value, '/') {
+				return fmt.Errorf("appenddirname can't be a path, just a directory name")
+			}
+			c.AppendDirname = value
+			return nil
+		},
+	},
+	{
+		name:    "appendfsync",
+		mutable: true,
+		get:     func(c *Config) string { return c.AppendFsync },
+		set: func(c *Config, value string) error {
+			value = strings.ToLower(value)
+			switch value {
+			case aof.FsyncAlways, aof.FsyncEverySec, aof.FsyncNo:
+				c.AppendFsync = value
+				return nil
+			}
+			return fmt.Errorf("argument must be one of the following: always, everysec, no")
+		},
+	},
+	{
+		name:    "auto-aof-rewrite-percentage",
+		mutable: true,
+		get:     func(c *Config) string { return strconv.Itoa(c.AutoAofRewritePercentage) },
+		set: func(c *Config, value string) error {
+			percentage, err := strconv.Atoi(value)
+			if err != nil || percentage < 0 {
+				return fmt.Errorf("argument must be a non-negative integer")
+			}
+			c.AutoAofRewritePercentage = percentage
+			return nil
+		},
+	},
+	{
+		name:    "auto-aof-rewrite-min-size",
+		mutable: true,
+		get:     func(c *Config) string { return strconv.FormatInt(c.AutoAofRewriteMinSize, 10) },
+		set: func(c *Config, value string) error {
+			size, err := ParseMemory(value)
+			if err != nil {
+				return err
+			}
+			c.AutoAofRewriteMinSize = size
+			return nil
+		},
+	},
+	{
+		name:    "aof-load-truncated",
+		mutable: true,
+		get:     func(c *Config) string { return formatBool(c.AofLoadTruncated) },
+		set: func(c *Config, value string) error {
+			return parseBool(value, &c.AofLoadTruncated)
+		},
+	},
+	{
+		name:    "aof-use-rdb-preamble",
+		mutable: true,
+		get:     func(c *Config) string { return formatBool(c.AofUseRDBPreamble) },
+		set: func(c *Config, value string) error {
+			return parseBool(value, &c.AofUseRDBPreamble)
+		},
+	},
+	{
+		name:    "aof-archive-history",
+		mutable: true,
+		get:     func(c *Config) string { return formatBool(c.AofArchiveHistory) },
+		set: func(c *Config, value string) error {
+			return parseBool(value, &c.AofArchiveHistory)
+		},
+	},
+	{
+		name: "dbfilename",
+		get:  func(c *Config) string { return c.DBFilename },
+		set: func(c *Config, value string) error {
+			if value == "" || strings.ContainsRune(value, '/') {
+				return fmt.Errorf("dbfilename can't be a path, just a filename")
+			}
+			c.DBFilename = value
+			return nil
+		},
+	},
+	{
+		name:    "save",
+		mutable: true,
+		multi:   true,
+		get: func(c *Config) string {
+			rules := make([]string, 0, len(c.SaveRules))
+			for _, rule := range c.SaveRules {
+				rules = append(rules, fmt.Sprintf("%d %d", rule.Seconds, rule.Changes))
+			}
+			return strings.Join(rules, " ")
+		},
+		set: func(c *Config, value string) error {
+			rules, err := parseSaveRules(value)
+			if err != nil {
+				return err
+			}
+			c.SaveRules = rules
+			return nil
+		},
+	},
+	{
+		name:    "replica-read-only",
+		mutable: true,
+		get:     func(c *Config) string { return formatBool(c.ReplicaReadOnly) },
+		set: func(c *Config, value string) error {
+			return parseBool(value, &c.ReplicaReadOnly)
+		},
+	},
+	{
+		name:    "repl-backlog-size",
+		mutable: true,
+		get:     func(c *Config) string { return strconv.FormatInt(c.ReplBacklogSize, 10) },
+		set: func(c *Config, value string) error {
+			size, err := ParseMemory(value)
+			if err != nil {
+				return err
+			}
+			if size > math.MaxInt32 {
+				return fmt.Errorf("argument must be less than 2gb")
+			}
+			c.ReplBacklogSize = size
+			return nil
+		},
+	},
+	{
+		name:    "notify-keyspace-events",
+		mutable: true,
+		get:     func(c *Config) string { return cmd.FormatKeyspaceEvents(c.NotifyKeyspaceEvents) },
+		set: func(c *Config, value string) error {
+			flags, err := cmd.ParseKeyspaceEvents(value)
+			if err != nil {
+				return err
+			}
+			c.NotifyKeyspaceEvents = flags
+			return nil
+		},
+	},
+}
+
+// parseBool parses a yes/no setting into b.
+func parseBool(value string, b *bool) error {
+	switch strings.ToLower(value) {
+	case "yes":
+		*b = true
+	case "no":
+		*b = false
+	default:
+		return fmt.Errorf("argument must be 'yes' or 'no'")
+	}
+	return nil
+}
+
+func formatBool(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// parseSaveRules parses "<seconds> <changes> [<seconds> <changes> ...]". An
+// empty string disables snapshots.
+func parseSaveRules(value string) ([]SaveRule, error) {
+	fields := strings.Fields(value)
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("invalid save parameters")
+	}
+
+	rules := make([]SaveRule, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err1 := strconv.Atoi(fields[i])
+		changes, err2 := strconv.ParseInt(fields[i+1], 10, 64)
+		if err1 != nil || err2 != nil || seconds < 1 || changes < 0 {
+			return nil, fmt.Errorf("invalid save parameters")
+		}
+		rules = append(rules, SaveRule{Seconds: seconds, Changes: changes})
+	}
+	return rules, nil
+}
+
+func lookupParam(name string) (*param, bool) {
+	name = strings.ToLower(name)
+	for i := range params {
+		if params[i].name == name {
+			return &params[i], true
+		}
+	}
+	return nil, false
+}
+
+// Get returns the value of every setting whose name matches one of the glob
+// patterns, as used by CONFIG GET.
+func (c *Config) Get(patterns ...string) map[string]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	values := make(map[string]string)
+	for _, p := range params {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(strings.ToLower(pattern), p.name); ok {
+				values[p.name] = p.get(c)
+				break
+			}
+		}
+	}
+	return values
+}
+
+// Set changes a setting at runtime, as CONFIG SET does. Settings that only
+// take effect at startup are refused.
+func (c *Config) Set(name, value string) error {
+	p, ok := lookupParam(name)
+	if !ok {
+		return fmt.Errorf("Unknown option or number of arguments for CONFIG SET - '%s'", name)
+	}
+	if !p.mutable {
+		return fmt.Errorf("can't set immutable config '%s'", p.name)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return p.set(c, value)
+}
+
+// Snapshot returns a copy of the current settings that can be read without
+// locking.
+func (c *Config) Snapshot() Config {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return Config{
+		Port:                     c.Port,
+		AppendFilename:           c.AppendFilename,
+		AppendDirname:            c.AppendDirname,
+		AppendFsync:              c.AppendFsync,
+		AutoAofRewritePercentage: c.AutoAofRewritePercentage,
+		AutoAofRewriteMinSize:    c.AutoAofRewriteMinSize,
+		AofLoadTruncated:         c.AofLoadTruncated,
+		AofUseRDBPreamble:        c.AofUseRDBPreamble,
+		AofArchiveHistory:        c.AofArchiveHistory,
+		DBFilename:               c.DBFilename,
+		SaveRules:                append([]SaveRule(nil), c.SaveRules...),
+		ReplicaReadOnly:          c.ReplicaReadOnly,
+		ReplBacklogSize:          c.ReplBacklogSize,
+		NotifyKeyspaceEvents:     c.NotifyKeyspaceEvents,
+	}
+}
+
+// Load builds the configuration from the command line, which follows the
+// redis-server conventions: an optional path to a config file first, then
+// any number of "--name value" options that take precedence over the file.
+func Load(args []string) (*Config, error) {
+	c := Default()
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "--") {
+		if err := c.loadFile(args[0]); err != nil {
+			return nil, err
+		}
+		args = args[1:]
+	}
+
+	for len(args) > 0 {
+		name := strings.TrimPrefix(args[0], "--")
+		if name == args[0] || len(args) < 2 {
+			return nil, fmt.Errorf("invalid option '%s', expected --name value", args[0])
+		}
+		if err := c.apply(name, args[1]); err != nil {
+			return nil, err
+		}
+		args = args[2:]
+	}
+
+	return c, nil
+}
+
+// loadFile reads "name value" lines from a config file. Blank lines and lines
+// starting with # are ignored. Values may be quoted and may contain spaces, as
+// in save "900 1".
+func (c *Config) loadFile(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return fmt.Errorf("%s:%d: expected 'name value', got '%s'", filename, lineNo, line)
+		}
+		name := strings.ToLower(fields[0])
+		value := strings.Trim(strings.Join(fields[1:], " "), `"`)
+
+		// The first line for a multi valued setting replaces the default, the
+		// ones after it add to it
+		if p, ok := lookupParam(name); ok && p.multi && seen[name] && value != "" {
+			value = p.get(c) + " " + value
+		}
+		seen[name] = true
+
+		if err := c.apply(name, value); err != nil {
+			return fmt.Errorf("%s:%d: %v", filename, lineNo, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func (c *Config) apply(name, value string) error {
+	p, ok := lookupParam(name)
+	if !ok {
+		return fmt.Errorf("unknown option '%s'", name)
+	}
+	if err := p.set(c, value); err != nil {
+		return fmt.Errorf("invalid value for '%s': %v", p.name, err)
+	}
+	return nil
+}
+
+// ParseMemory parses a byte count with an optional unit, such as 64mb or 1gb,
+// the way redis.conf writes memory sizes.
+func ParseMemory(value string) (int64, error) {
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+
+	lower := strings.ToLower(value)
+	multiplier := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(lower, unit.suffix) {
+			lower = strings.TrimSuffix(lower, unit.suffix)
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("argument must be a memory value")
+	}
+	return n * multiplier, nil
+}
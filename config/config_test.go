@@ -0,0 +1,194 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseMemory(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+		ok    bool
+	}{
+		{"0", 0, true},
+		{"100", 100, true},
+		{"100b", 100, true},
+		{"1k", 1000, true},
+		{"1kb", 1024, true},
+		{"64mb", 64 * 1024 * 1024, true},
+		{"64MB", 64 * 1024 * 1024, true},
+		{"2m", 2000 * 1000, true},
+		{"1gb", 1024 * 1024 * 1024, true},
+		{"1g", 1000 * 1000 * 1000, true},
+		{"", 0, false},
+		{"mb", 0, false},
+		{"-1", 0, false},
+		{"1tb", 0, false},
+		{"ten", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseMemory(tt.value)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseMemory(%q) = %d, %v; want %d, ok %v", tt.value, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "redis.conf")
+	os.WriteFile(file, []byte(`# A comment, then a blank line
+
+port 7000
+appendfilename "other.aof"
+appendfsync ALWAYS
+auto-aof-rewrite-min-size 1mb
+`), 0644)
+
+	defaults := Default().Get("*")
+	tests := []struct {
+		name string
+		args []string
+		want map[string]string // Settings that differ from the defaults
+	}{
+		{"defaults", nil, nil},
+		{"file", []string{file}, map[string]string{
+			"port": "7000", "appendfilename": "other.aof", "appendfsync": "always",
+			"auto-aof-rewrite-min-size": "1048576",
+		}},
+		{"options override the file", []string{file, "--port", "7001", "--appendfsync", "no"}, map[string]string{
+			"port": "7001", "appendfilename": "other.aof", "appendfsync": "no",
+			"auto-aof-rewrite-min-size": "1048576",
+		}},
+		{"options alone", []string{"--auto-aof-rewrite-percentage", "0"}, map[string]string{
+			"auto-aof-rewrite-percentage": "0",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Load(tt.args)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			for name, value := range c.Get("*") {
+				want, ok := tt.want[name]
+				if !ok {
+					want = defaults[name]
+				}
+				if value != want {
+					t.Errorf("%s = %q, want %q", name, value, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	bad := filepath.Join(t.TempDir(), "bad.conf")
+	os.WriteFile(bad, []byte("port 6379 6380\n"), 0644)
+
+	for _, args := range [][]string{
+		{"--port"},
+		{"--port", "65536"},
+		{"--appendfsync", "sometimes"},
+		{"--appendfilename", "dir/file.aof"},
+		{"--appendfilename", "my file.aof"},
+		{"--appenddirname", "a/b"},
+		{"--aof-use-rdb-preamble", "maybe"},
+		{"--nosuchoption", "1"},
+		{"port", "6379"},
+		{bad},
+		{filepath.Join(t.TempDir(), "missing.conf")},
+	} {
+		if _, err := Load(args); err == nil {
+			t.Errorf("Load(%q) succeeded, want an error", args)
+		}
+	}
+}
+
+func TestGetSet(t *testing.T) {
+	c := Default()
+
+	if got := c.Get("append*"); len(got) != 3 || got["appendfsync"] != "everysec" ||
+		got["appendfilename"] != "database.aof" || got["appenddirname"] != "appendonlydir" {
+		t.Errorf("Get(append*) = %v", got)
+	}
+	if got := c.Get("PORT", "port", "nosuch"); len(got) != 1 || got["port"] != "6379" {
+		t.Errorf("Get(PORT, port, nosuch) = %v", got)
+	}
+
+	if err := c.Set("APPENDFSYNC", "No"); err != nil || c.Snapshot().AppendFsync != "no" {
+		t.Errorf("Set(APPENDFSYNC, No) = %v, appendfsync is %q", err, c.Snapshot().AppendFsync)
+	}
+	for _, tt := range [][2]string{
+		{"port", "7000"},
+		{"appendfilename", "other.aof"},
+		{"appendfsync", "sometimes"},
+		{"auto-aof-rewrite-percentage", "-1"},
+		{"nosuchoption", "1"},
+	} {
+		if err := c.Set(tt[0], tt[1]); err == nil {
+			t.Errorf("Set(%s, %s) succeeded, want an error", tt[0], tt[1])
+		}
+	}
+	if got := c.Get("port", "appendfilename", "auto-aof-rewrite-percentage"); got["port"] != "6379" ||
+		got["appendfilename"] != "database.aof" || got["auto-aof-rewrite-percentage"] != "100" {
+		t.Errorf("failed Sets changed the config to %v", got)
+	}
+}
+
+func TestSaveRules(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "redis.conf")
+	os.WriteFile(file, []byte("save 900 1\nsave \"300 10\"\n"), 0644)
+	c, err := Load([]string{file})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	// The first line replaces the default rules, the second adds to it
+	if got := c.Get("save")["save"]; got != "900 1 300 10" {
+		t.Errorf("save = %q, want 900 1 300 10", got)
+	}
+
+	tests := []struct {
+		value string
+		want  string
+		ok    bool
+	}{
+		{"60 100", "60 100", true},
+		{"60 100 10 1000", "60 100 10 1000", true},
+		{"", "", true}, // Disables snapshots
+		{"60", "", false},
+		{"0 1", "", false},
+		{"60 -1", "", false},
+		{"sixty 1", "", false},
+	}
+	for _, tt := range tests {
+		c := Default()
+		err := c.Set("save", tt.value)
+		if (err == nil) != tt.ok {
+			t.Errorf("Set(save, %q) = %v, want ok %v", tt.value, err, tt.ok)
+			continue
+		}
+		if got := c.Get("save")["save"]; tt.ok && got != tt.want {
+			t.Errorf("save = %q after Set(save, %q), want %q", got, tt.value, tt.want)
+		}
+	}
+}
+
+func TestBoolSettings(t *testing.T) {
+	c := Default()
+	for _, tt := range []struct{ value, want string }{{"no", "no"}, {"YES", "yes"}, {"No", "no"}} {
+		if err := c.Set("aof-load-truncated", tt.value); err != nil {
+			t.Errorf("Set(aof-load-truncated, %s): %v", tt.value, err)
+		}
+		if got := c.Get("aof-load-truncated")["aof-load-truncated"]; got != tt.want {
+			t.Errorf("aof-load-truncated = %q after setting it to %s", got, tt.value)
+		}
+	}
+	for _, value := range []string{"", "true", "1"} {
+		if err := c.Set("aof-load-truncated", value); err == nil {
+			t.Errorf("Set(aof-load-truncated, %q) succeeded", value)
+		}
+	}
+}
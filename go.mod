@@ -5,5 +5,7 @@ go 1.23.2
 require (
 	github.com/pierrec/xxHash v0.1.5
 	github.com/twmb/murmur3 v1.1.8
-  golang.org/x/exp v0.0.0-20250106191152-7588d65b2ba8
-)
\ No newline at end of file
+	golang.org/x/exp v0.0.0-20250106191152-7588d65b2ba8
+)
+
+require github.com/yuin/gopher-lua v1.1.1
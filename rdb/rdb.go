@@ -0,0 +1,485 @@
+// Package rdb reads and writes point-in-time snapshots of the keyspace in a
+// compact binary format.
+//
+// A snapshot starts with a magic string and a format version, followed by one
+// record per function library, one record per key and an end marker. A library
+// record is the function opcode, the name of the library and its code. A key
+// record is an optional expiry opcode with
+// the absolute deadline in Unix milliseconds, then a type byte, the key and
+// the value encoded according to its type. Lengths and counts are unsigned
+// varints and strings are length prefixed. The file ends with a CRC-64 of
+// everything before it, so that a truncated or corrupted snapshot is detected
+// instead of being loaded half way.
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/IAmRiteshKoushik/bluedis/store"
+)
+
+const (
+	magic   = "BLUEDIS"
+	version = 2 // Version 1 had no function libraries
+)
+
+// Opcodes and value types of the records
+const (
+	opFunction = 0xF5
+	opExpireMs = 0xFC
+	opEOF      = 0xFF
+
+	typeString = 0
+	typeHash   = 1
+	typeList   = 2
+	typeZSet   = 3
+	typeBitMap = 4
+	typeBloom  = 5
+)
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+// ErrCorrupt is returned when a snapshot fails its checksum or cannot be
+// decoded.
+var ErrCorrupt = errors.New("snapshot is corrupt")
+
+// Save writes every live key of ks to w. The caller must hold the read lock of
+// ks, or own it outright as with a clone.
+func Save(w io.Writer, ks *store.Keyspace) error {
+	bw := bufio.NewWriter(w)
+	e := &encoder{w: bw, crc: crc64.New(crcTable)}
+
+	e.writeRaw([]byte(magic))
+	e.writeByte(version)
+	e.writeLibraries(ks.Libraries())
+
+	err := ks.ForEach(func(key string, entry *store.Entry) error {
+		if entry.HasExpiry() {
+			e.writeByte(opExpireMs)
+			var deadline [8]byte
+			binary.LittleEndian.PutUint64(deadline[:], uint64(entry.ExpireAt().UnixMilli()))
+			e.writeRaw(deadline[:])
+		}
+		if err := e.writeEntry(key, entry); err != nil {
+			return err
+		}
+		return e.err
+	})
+	if err != nil {
+		return err
+	}
+
+	e.writeByte(opEOF)
+	if e.err != nil {
+		return e.err
+	}
+
+	var sum [8]byte
+	binary.LittleEndian.PutUint64(sum[:], e.crc.Sum64())
+	if _, err := bw.Write(sum[:]); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// SaveFile writes a snapshot of ks to path. The snapshot goes to a temporary
+// file first which is synced and renamed over path, so path always holds a
+// complete snapshot even if the server dies half way through. Each save gets
+// a temporary file of its own.
+func SaveFile(path string, ks *store.Keyspace) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "temp-*.rdb")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+
+	err = Save(f, ks)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// Make the rename itself durable
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// Load reads a snapshot from r, replaces the contents of ks with it and returns
+// the number of keys loaded. Keys whose deadline passed while the snapshot sat
+// on disk are skipped. The snapshot is decoded into a keyspace of its own and
+// only swapped in once its checksum matches, so ks is left alone when it turns
+// out to be corrupt. The caller must hold the write lock of ks.
+func Load(r io.Reader, ks *store.Keyspace) (int, error) {
+	d := &decoder{r: bufio.NewReader(r), crc: crc64.New(crcTable)}
+	fresh := store.NewKeyspace()
+
+	header := d.readRaw(len(magic) + 1)
+	if d.err != nil || string(header[:len(magic)]) != magic {
+		return 0, fmt.Errorf("%w: not a snapshot file", ErrCorrupt)
+	}
+	if header[len(magic)] == 0 || header[len(magic)] > version {
+		return 0, fmt.Errorf("unsupported snapshot version %d", header[len(magic)])
+	}
+
+	now := time.Now()
+	loaded := 0
+	for {
+		op := d.readByte()
+		if d.err != nil {
+			return loaded, d.corrupt()
+		}
+		if op == opEOF {
+			break
+		}
+		if op == opFunction {
+			name, code := d.readString(), d.readString()
+			if d.err != nil {
+				return loaded, d.corrupt()
+			}
+			fresh.SetLibrary(name, code)
+			continue
+		}
+
+		var expireAt time.Time
+		if op == opExpireMs {
+			deadline := d.readRaw(8)
+			if d.err != nil {
+				return loaded, d.corrupt()
+			}
+			expireAt = time.UnixMilli(int64(binary.LittleEndian.Uint64(deadline)))
+			op = d.readByte()
+		}
+
+		key := d.readString()
+		typ, value := d.readValue(op, key)
+		if d.err != nil {
+			return loaded, d.corrupt()
+		}
+
+		if !expireAt.IsZero() && !expireAt.After(now) {
+			continue
+		}
+		fresh.Put(key, typ, value)
+		if !expireAt.IsZero() {
+			fresh.SetExpire(key, expireAt)
+		}
+		loaded++
+	}
+
+	expected := d.crc.Sum64()
+	var sum [8]byte
+	if _, err := io.ReadFull(d.r, sum[:]); err != nil {
+		return loaded, fmt.Errorf("%w: missing checksum", ErrCorrupt)
+	}
+	if binary.LittleEndian.Uint64(sum[:]) != expected {
+		return loaded, fmt.Errorf("%w: checksum mismatch", ErrCorrupt)
+	}
+	ks.Replace(fresh)
+	return loaded, nil
+}
+
+// LoadFile loads the snapshot at path into ks, see Load. A missing file is
+// reported with an error satisfying errors.Is(err, os.ErrNotExist).
+func LoadFile(path string, ks *store.Keyspace) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return Load(f, ks)
+}
+
+// DumpLibraries serializes function libraries, given their code by name, the
+// way FUNCTION DUMP returns them: one record per library, the end marker, the
+// format version and a checksum.
+func DumpLibraries(libraries map[string]string) []byte {
+	var b bytes.Buffer
+	e := &encoder{w: &b, crc: crc64.New(crcTable)}
+	e.writeLibraries(libraries)
+	e.writeByte(opEOF)
+	e.writeByte(version)
+
+	var sum [8]byte
+	binary.LittleEndian.PutUint64(sum[:], e.crc.Sum64())
+	b.Write(sum[:])
+	return b.Bytes()
+}
+
+// RestoreLibraries reads back the output of DumpLibraries.
+func RestoreLibraries(payload []byte) (map[string]string, error) {
+	if len(payload) < 10 {
+		return nil, ErrCorrupt
+	}
+	body, sum := payload[:len(payload)-8], payload[len(payload)-8:]
+	if crc64.Checksum(body, crcTable) != binary.LittleEndian.Uint64(sum) || body[len(body)-1] > version {
+		return nil, ErrCorrupt
+	}
+
+	d := &decoder{r: bufio.NewReader(bytes.NewReader(body[:len(body)-1])), crc: crc64.New(crcTable)}
+	libraries := make(map[string]string)
+	for {
+		op := d.readByte()
+		if d.err != nil {
+			return nil, d.corrupt()
+		}
+		if op == opEOF {
+			return libraries, nil
+		}
+		if op != opFunction {
+			return nil, fmt.Errorf("%w: unknown opcode %d", ErrCorrupt, op)
+		}
+		name, code := d.readString(), d.readString()
+		if d.err != nil {
+			return nil, d.corrupt()
+		}
+		libraries[name] = code
+	}
+}
+
+// encoder writes records while keeping a running checksum. The first error
+// sticks, so that callers only need to check it once in a while.
+type encoder struct {
+	w   io.Writer
+	crc hash.Hash64
+	err error
+}
+
+func (e *encoder) writeRaw(p []byte) {
+	if e.err != nil {
+		return
+	}
+	e.crc.Write(p)
+	_, e.err = e.w.Write(p)
+}
+
+func (e *encoder) writeByte(b byte) {
+	e.writeRaw([]byte{b})
+}
+
+func (e *encoder) writeUvarint(n uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	e.writeRaw(buf[:binary.PutUvarint(buf[:], n)])
+}
+
+func (e *encoder) writeVarint(n int64) {
+	var buf [binary.MaxVarintLen64]byte
+	e.writeRaw(buf[:binary.PutVarint(buf[:], n)])
+}
+
+func (e *encoder) writeString(s string) {
+	e.writeUvarint(uint64(len(s)))
+	e.writeRaw([]byte(s))
+}
+
+// writeLibraries writes a record for each library, sorted by name.
+func (e *encoder) writeLibraries(libraries map[string]string) {
+	names := make([]string, 0, len(libraries))
+	for name := range libraries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		e.writeByte(opFunction)
+		e.writeString(name)
+		e.writeString(libraries[name])
+	}
+}
+
+func (e *encoder) writeEntry(key string, entry *store.Entry) error {
+	switch value := entry.Value.(type) {
+	case string:
+		e.writeByte(typeString)
+		e.writeString(key)
+		e.writeString(value)
+
+	case map[string]string:
+		e.writeByte(typeHash)
+		e.writeString(key)
+		e.writeUvarint(uint64(len(value)))
+		for field, v := range value {
+			e.writeString(field)
+			e.writeString(v)
+		}
+
+	case *store.DoublyLinkedList:
+		values := value.Values()
+		e.writeByte(typeList)
+		e.writeString(key)
+		e.writeUvarint(uint64(len(values)))
+		for _, v := range values {
+			e.writeString(fmt.Sprintf("%v", v))
+		}
+
+	case *store.SortedSet[string, int64, string]:
+		nodes := value.Nodes()
+		e.writeByte(typeZSet)
+		e.writeString(key)
+		e.writeUvarint(uint64(len(nodes)))
+		for _, node := range nodes {
+			e.writeString(node.Key)
+			e.writeVarint(node.Score)
+			e.writeString(node.Value)
+		}
+
+	case *store.StringBitMap:
+		e.writeByte(typeBitMap)
+		e.writeString(key)
+		e.writeString(string(value.Bytes(key)))
+
+	case *store.BloomFilter:
+		e.writeByte(typeBloom)
+		e.writeString(key)
+		e.writeString(string(value.Bytes()))
+
+	default:
+		return fmt.Errorf("cannot save key '%s' of type %s", key, entry.Type)
+	}
+	return nil
+}
+
+// decoder is the reading counterpart of encoder.
+type decoder struct {
+	r   *bufio.Reader
+	crc hash.Hash64
+	err error
+}
+
+func (d *decoder) corrupt() error {
+	if errors.Is(d.err, io.EOF) || errors.Is(d.err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: unexpected end of file", ErrCorrupt)
+	}
+	return d.err
+}
+
+func (d *decoder) readRaw(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	p := make([]byte, n)
+	if _, err := io.ReadFull(d.r, p); err != nil {
+		d.err = err
+		return nil
+	}
+	d.crc.Write(p)
+	return p
+}
+
+func (d *decoder) readByte() byte {
+	p := d.readRaw(1)
+	if p == nil {
+		return 0
+	}
+	return p[0]
+}
+
+// ReadByte lets binary.ReadUvarint read through the checksum.
+func (d *decoder) ReadByte() (byte, error) {
+	b := d.readByte()
+	return b, d.err
+}
+
+func (d *decoder) readUvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	n, err := binary.ReadUvarint(d)
+	if err != nil && d.err == nil {
+		d.err = fmt.Errorf("%w: bad length", ErrCorrupt)
+	}
+	return n
+}
+
+func (d *decoder) readVarint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	n, err := binary.ReadVarint(d)
+	if err != nil && d.err == nil {
+		d.err = fmt.Errorf("%w: bad number", ErrCorrupt)
+	}
+	return n
+}
+
+// Upper bound on a single string, so that a corrupted length cannot make the
+// loader allocate the whole memory of the machine
+const maxStringLen = 512 * 1024 * 1024
+
+func (d *decoder) readString() string {
+	n := d.readUvarint()
+	if n > maxStringLen {
+		if d.err == nil {
+			d.err = fmt.Errorf("%w: string too long", ErrCorrupt)
+		}
+		return ""
+	}
+	return string(d.readRaw(int(n)))
+}
+
+func (d *decoder) readValue(typ byte, key string) (string, interface{}) {
+	switch typ {
+	case typeString:
+		return store.TypeString, d.readString()
+
+	case typeHash:
+		n := d.readUvarint()
+		hash := make(map[string]string)
+		for i := uint64(0); i < n && d.err == nil; i++ {
+			field := d.readString()
+			hash[field] = d.readString()
+		}
+		return store.TypeHash, hash
+
+	case typeList:
+		n := d.readUvarint()
+		list := store.NewDoublyLinkedList()
+		for i := uint64(0); i < n && d.err == nil; i++ {
+			list.PushRight(d.readString())
+		}
+		return store.TypeList, list
+
+	case typeZSet:
+		n := d.readUvarint()
+		zset := store.NewSortedSet[string, int64, string]()
+		for i := uint64(0); i < n && d.err == nil; i++ {
+			member := d.readString()
+			score := d.readVarint()
+			value := d.readString()
+			zset.AddOrUpdate(member, score, value)
+		}
+		return store.TypeZSet, zset
+
+	case typeBitMap:
+		return store.TypeBitMap, store.NewStringBitMapFromBytes(key, []byte(d.readString()))
+
+	case typeBloom:
+		return store.TypeBloom, store.NewBloomFilterFromBytes([]byte(d.readString()))
+	}
+
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: unknown value type %d", ErrCorrupt, typ)
+	}
+	return "", nil
+}
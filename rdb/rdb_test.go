@@ -0,0 +1,216 @@
+package rdb
+
+import (
+	"bytes"
+	"errors"
+	"maps"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IAmRiteshKoushik/bluedis/resp"
+	"github.com/IAmRiteshKoushik/bluedis/store"
+)
+
+// sample returns a keyspace holding a value of every type, a key with a TTL
+// and a function library.
+func sample() *store.Keyspace {
+	ks := store.NewKeyspace()
+	ks.Put("str", store.TypeString, "value")
+	ks.Put("hash", store.TypeHash, map[string]string{"f1": "v1", "f2": "v2"})
+
+	list := store.NewDoublyLinkedList()
+	for _, v := range []string{"a", "b", "c"} {
+		list.PushRight(v)
+	}
+	ks.Put("list", store.TypeList, list)
+
+	zset := store.NewSortedSet[string, int64, string]()
+	zset.AddOrUpdate("one", 1, "one")
+	zset.AddOrUpdate("two", 2, "two")
+	ks.Put("zset", store.TypeZSet, zset)
+
+	bitmap := store.NewStringBitMap()
+	bitmap.SetBit("bitmap", 9, true)
+	ks.Put("bitmap", store.TypeBitMap, bitmap)
+
+	bf := store.NewBloomFilter(64)
+	bf.Add(resp.Value{Typ: "bulk", Bulk: "item"})
+	ks.Put("bloom", store.TypeBloom, bf)
+
+	ks.Put("volatile", store.TypeString, "soon gone")
+	ks.SetExpire("volatile", time.UnixMilli(4102444800000)) // 2100-01-01
+
+	ks.SetLibrary("lib", "#!lua name=lib\nredis.register_function('f', function() return 1 end)")
+	return ks
+}
+
+func saveSample(t *testing.T) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := Save(&b, sample()); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	return b.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	want := sample()
+	ks := store.NewKeyspace()
+	n, err := Load(bytes.NewReader(saveSample(t)), ks)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if n != want.Len() {
+		t.Errorf("Load returned %d keys, want %d", n, want.Len())
+	}
+
+	tests := []struct {
+		key   string
+		check func(entry *store.Entry) bool
+	}{
+		{"str", func(e *store.Entry) bool { return e.Value == "value" }},
+		{"hash", func(e *store.Entry) bool {
+			return maps.Equal(e.Value.(map[string]string), map[string]string{"f1": "v1", "f2": "v2"})
+		}},
+		{"list", func(e *store.Entry) bool {
+			values := e.Value.(*store.DoublyLinkedList).Values()
+			return len(values) == 3 && values[0] == "a" && values[1] == "b" && values[2] == "c"
+		}},
+		{"zset", func(e *store.Entry) bool {
+			nodes := e.Value.(*store.SortedSet[string, int64, string]).Nodes()
+			return len(nodes) == 2 && nodes[0].Key == "one" && nodes[0].Score == 1 && nodes[1].Key == "two" && nodes[1].Score == 2
+		}},
+		{"bitmap", func(e *store.Entry) bool {
+			original, _ := want.Lookup("bitmap")
+			return bytes.Equal(e.Value.(*store.StringBitMap).Bytes("bitmap"), original.Value.(*store.StringBitMap).Bytes("bitmap"))
+		}},
+		{"bloom", func(e *store.Entry) bool {
+			return e.Value.(*store.BloomFilter).Exists(resp.Value{Typ: "bulk", Bulk: "item"})
+		}},
+		{"volatile", func(e *store.Entry) bool {
+			original, _ := want.Lookup("volatile")
+			return e.Value == "soon gone" && e.ExpireAt().Equal(original.ExpireAt())
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			entry, ok := ks.Lookup(tt.key)
+			if !ok {
+				t.Fatalf("key %q missing after Load", tt.key)
+			}
+			if !tt.check(entry) {
+				t.Errorf("key %q loaded as %#v", tt.key, entry.Value)
+			}
+		})
+	}
+	if !maps.Equal(ks.Libraries(), want.Libraries()) {
+		t.Errorf("libraries loaded as %v, want %v", ks.Libraries(), want.Libraries())
+	}
+}
+
+func TestSaveFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump.rdb")
+	for i := 0; i < 2; i++ {
+		if err := SaveFile(path, sample()); err != nil {
+			t.Fatalf("SaveFile: %v", err)
+		}
+	}
+
+	// Saves running at the same time do not write over each other
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = SaveFile(path, sample())
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		t.Fatalf("concurrent SaveFile: %v", err)
+	}
+
+	ks := store.NewKeyspace()
+	if _, err := LoadFile(path, ks); err != nil {
+		t.Fatalf("LoadFile: %v", err)
+	}
+	if _, ok := ks.Lookup("str"); !ok {
+		t.Errorf("key missing after LoadFile")
+	}
+
+	// Nothing is left behind but the snapshot itself
+	matches, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "temp-*.rdb"))
+	if len(matches) != 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+}
+
+func TestLoadRejectsDamage(t *testing.T) {
+	data := saveSample(t)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"bad magic", append([]byte("REDIS"), data[5:]...)},
+		{"flipped byte", func() []byte {
+			d := bytes.Clone(data)
+			d[len(d)/2] ^= 0xFF
+			return d
+		}()},
+		{"bad checksum", func() []byte {
+			d := bytes.Clone(data)
+			d[len(d)-1] ^= 0xFF
+			return d
+		}()},
+		{"missing checksum", data[:len(data)-8]},
+		{"truncated", data[:len(data)/2]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ks := store.NewKeyspace()
+			ks.Put("existing", store.TypeString, "v")
+			_, err := Load(bytes.NewReader(tt.data), ks)
+			if !errors.Is(err, ErrCorrupt) {
+				t.Fatalf("Load returned %v, want ErrCorrupt", err)
+			}
+			// Nothing of a damaged snapshot is loaded
+			if keys := ks.Keys(); len(keys) != 1 || keys[0] != "existing" {
+				t.Errorf("keyspace holds %q after a failed Load", keys)
+			}
+			if libraries := ks.Libraries(); len(libraries) != 0 {
+				t.Errorf("libraries %v loaded from a damaged snapshot", libraries)
+			}
+		})
+	}
+}
+
+func TestDumpLibraries(t *testing.T) {
+	libraries := map[string]string{
+		"one": "#!lua name=one\nredis.register_function('a', function() end)",
+		"two": "#!lua name=two\nredis.register_function('b', function() end)",
+	}
+	payload := DumpLibraries(libraries)
+
+	restored, err := RestoreLibraries(payload)
+	if err != nil {
+		t.Fatalf("RestoreLibraries: %v", err)
+	}
+	if !maps.Equal(restored, libraries) {
+		t.Errorf("RestoreLibraries = %v, want %v", restored, libraries)
+	}
+
+	for _, damaged := range [][]byte{
+		payload[:5],
+		payload[:len(payload)-1],
+		append(bytes.Clone(payload[:len(payload)-1]), payload[len(payload)-1]^0xFF),
+	} {
+		if _, err := RestoreLibraries(damaged); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("RestoreLibraries(%q) returned %v, want ErrCorrupt", damaged, err)
+		}
+	}
+}
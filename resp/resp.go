@@ -2,6 +2,7 @@ package resp
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
@@ -100,6 +101,13 @@ func (v Value) marshalInteger() []byte {
 	return []byte(fmt.Sprintf(":%d\r\n", v.Num)) // The format is ":<integer>\r\n" as specified by the Redis Serialization Protocol.
 }
 
+// ErrProtocol is wrapped by every error caused by malformed input, as opposed
+// to the underlying reader failing or running out of data.
+var ErrProtocol = errors.New("protocol error")
+
+// Largest bulk string accepted, the same limit as Redis' proto-max-bulk-len
+const maxBulkLen = 512 * 1024 * 1024
+
 type Resp struct {
 	reader *bufio.Reader
 }
@@ -112,6 +120,35 @@ func NewResp(rd io.Reader) *Resp {
 	}
 }
 
+// Buffered returns the number of bytes that have been read from the underlying
+// reader but not parsed yet. Subtracting it from the bytes read so far gives
+// the offset of the next value.
+func (r *Resp) Buffered() int {
+	return r.reader.Buffered()
+}
+
+// ReadPayload reads the header of a bulk string that is not followed by CRLF,
+// which is how a primary sends its snapshot to a replica, and returns a reader
+// for the length bytes that follow. They have to be read in full before the
+// next call to Read.
+func (r *Resp) ReadPayload() (io.Reader, int64, error) {
+	_type, err := r.reader.ReadByte()
+	if err != nil {
+		return nil, 0, err
+	}
+	if _type != BULK {
+		return nil, 0, fmt.Errorf("%w: expected a bulk payload, got '%c'", ErrProtocol, _type)
+	}
+	length, _, err := r.readInteger()
+	if err != nil {
+		return nil, 0, err
+	}
+	if length < 0 {
+		return nil, 0, fmt.Errorf("%w: invalid payload length", ErrProtocol)
+	}
+	return io.LimitReader(r.reader, int64(length)), int64(length), nil
+}
+
 func (r *Resp) readLine() (line []byte, n int, err error) {
 	// Read line from buffer. We read one byte at a time until we reach '\r',
 	// which indicates the end of the line. Then we return the line without the
@@ -133,6 +170,10 @@ func (r *Resp) readLine() (line []byte, n int, err error) {
 		}
 	}
 
+	if line[len(line)-1] != '\n' {
+		return nil, n, fmt.Errorf("%w: expected CRLF at the end of the line", ErrProtocol)
+	}
+
 	return line[:len(line)-2], n, nil
 }
 
@@ -148,7 +189,7 @@ func (r *Resp) readInteger() (x int, n int, err error) {
 
 	i64, err := strconv.ParseInt(string(line), 10, 64)
 	if err != nil {
-		return 0, n, err
+		return 0, n, fmt.Errorf("%w: invalid integer '%s'", ErrProtocol, line)
 	}
 	return int(i64), n, nil
 }
@@ -177,8 +218,7 @@ func (r *Resp) Read() (Value, error) {
 	case ERROR:
 		return r.readError()
 	default:
-		fmt.Printf("Unknown type: %v", string(_type))
-		return Value{}, nil
+		return Value{}, fmt.Errorf("%w: unknown type '%c'", ErrProtocol, _type)
 	}
 }
 
@@ -198,9 +238,13 @@ func (r *Resp) readArray() (v Value, err error) {
 	if err != nil {
 		return v, err
 	}
+	if length < 0 {
+		return v, fmt.Errorf("%w: invalid multibulk length", ErrProtocol)
+	}
 
-	// for each line, parse and read the Value
-	v.Array = make([]Value, length)
+	// for each line, parse and read the Value. The length comes from the
+	// peer, so it is not trusted with allocating the whole array up front.
+	v.Array = make([]Value, 0, min(length, 1024))
 	for i := 0; i < length; i++ {
 		val, err := r.Read()
 		if err != nil {
@@ -208,7 +252,7 @@ func (r *Resp) readArray() (v Value, err error) {
 		}
 
 		// add parsed value to array
-		v.Array[i] = val
+		v.Array = append(v.Array, val)
 	}
 
 	return v, nil
@@ -232,6 +276,9 @@ func (r *Resp) readBulk() (v Value, err error) {
 		v.Typ = "null"
 		return v, nil
 	}
+	if length < 0 || length > maxBulkLen {
+		return v, fmt.Errorf("%w: invalid bulk length", ErrProtocol)
+	}
 
 	bulk := make([]byte, length)
 	_, err = io.ReadFull(r.reader, bulk)
@@ -243,7 +290,13 @@ func (r *Resp) readBulk() (v Value, err error) {
 	// Read the trailing CRLF so that the pointer is effectively moved to the
 	// next bulk string correctly. Otherwise, the pointer would be stuck at '\r'
 	// and Read method would not work properly
-	r.readLine()
+	crlf := make([]byte, 2)
+	if _, err := io.ReadFull(r.reader, crlf); err != nil {
+		return v, err
+	}
+	if crlf[0] != '\r' || crlf[1] != '\n' {
+		return v, fmt.Errorf("%w: expected CRLF after bulk string", ErrProtocol)
+	}
 
 	return v, nil
 }
@@ -0,0 +1,64 @@
+package resp
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Value
+	}{
+		{"+OK\r\n", Value{Typ: "string", Str: "OK"}},
+		{"-ERR bad\r\n", Value{Typ: "error", Str: "ERR bad"}},
+		{":42\r\n", Value{Typ: "integer", Num: 42}},
+		{"$5\r\nhello\r\n", Value{Typ: "bulk", Bulk: "hello"}},
+		{"$0\r\n\r\n", Value{Typ: "bulk", Bulk: ""}},
+		{"$-1\r\n", Value{Typ: "null"}},
+		{"*2\r\n$3\r\nGET\r\n$1\r\nk\r\n", Value{Typ: "array", Array: []Value{{Typ: "bulk", Bulk: "GET"}, {Typ: "bulk", Bulk: "k"}}}},
+		{"*0\r\n", Value{Typ: "array", Array: []Value{}}},
+	}
+	for _, tt := range tests {
+		got, err := NewResp(strings.NewReader(tt.input)).Read()
+		if err != nil {
+			t.Errorf("Read(%q): %v", tt.input, err)
+			continue
+		}
+		if string(got.Marshal()) != string(tt.want.Marshal()) || got.Typ != tt.want.Typ {
+			t.Errorf("Read(%q) = %+v, want %+v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		protocol bool // A protocol error rather than running out of input
+	}{
+		{"", false},
+		{"*2\r\n$3\r\nGET\r\n", false},
+		{"$5\r\nhel", false},
+		{"?what\r\n", true},
+		{":12a\r\n", true},
+		{"*-2\r\n", true},
+		{"$-2\r\n", true},
+		{"$999999999999\r\n", true},
+		{"*1\r\n$3\rGET\r\n", true},
+	}
+	for _, tt := range tests {
+		_, err := NewResp(strings.NewReader(tt.input)).Read()
+		if err == nil {
+			t.Errorf("Read(%q) succeeded, want an error", tt.input)
+			continue
+		}
+		if errors.Is(err, ErrProtocol) != tt.protocol {
+			t.Errorf("Read(%q) = %v, protocol error %v", tt.input, err, tt.protocol)
+		}
+		if !tt.protocol && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("Read(%q) = %v, want it to run out of input", tt.input, err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+// Smallest backlog allowed, the same as Redis
+const minBacklogSize = 16 * 1024
+
+// backlog holds the most recent part of the stream of writes in a ring
+// buffer, so that a replica that lost its connection can pick up where it left
+// off with PSYNC instead of starting over with a full sync.
+type backlog struct {
+	buf     []byte // The ring; its length is the capacity of the backlog
+	next    int    // Where the next byte goes in buf
+	histlen int    // How many bytes of buf hold data
+	end     int64  // Stream offset right after the last byte held
+}
+
+func newBacklog(size int, offset int64) *backlog {
+	return &backlog{buf: make([]byte, max(size, minBacklogSize)), end: offset}
+}
+
+// start returns the stream offset of the oldest byte held.
+func (b *backlog) start() int64 {
+	return b.end - int64(b.histlen)
+}
+
+// write appends data, which continues the stream from the end of the backlog,
+// pushing the oldest bytes out when it is full.
+func (b *backlog) write(data []byte) {
+	b.end += int64(len(data))
+	if len(data) > len(b.buf) {
+		data = data[len(data)-len(b.buf):]
+	}
+	for len(data) > 0 {
+		n := copy(b.buf[b.next:], data)
+		data = data[n:]
+		b.next = (b.next + n) % len(b.buf)
+		b.histlen = min(b.histlen+n, len(b.buf))
+	}
+}
+
+// since returns a copy of the stream from offset on. It reports false if the
+// backlog does not reach back that far, or offset lies in the future.
+func (b *backlog) since(offset int64) ([]byte, bool) {
+	if offset < b.start() || offset > b.end {
+		return nil, false
+	}
+
+	n := int(b.end - offset)
+	data := make([]byte, 0, n)
+	from := (b.next - n + len(b.buf)) % len(b.buf)
+	if from+n <= len(b.buf) {
+		return append(data, b.buf[from:from+n]...), true
+	}
+	data = append(data, b.buf[from:]...)
+	return append(data, b.buf[:b.next]...), true
+}
+
+// reset empties the backlog, which goes on from offset.
+func (b *backlog) reset(offset int64) {
+	b.next = 0
+	b.histlen = 0
+	b.end = offset
+}
+
+// resize changes the capacity of the backlog, keeping as much of the most
+// recent history as fits.
+func (b *backlog) resize(size int) {
+	size = max(size, minBacklogSize)
+	if size == len(b.buf) {
+		return
+	}
+
+	keep, _ := b.since(b.end - int64(min(b.histlen, size)))
+	resized := newBacklog(size, b.end-int64(len(keep)))
+	resized.write(keep)
+	*b = *resized
+}
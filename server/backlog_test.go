@@ -0,0 +1,134 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+)
+
+// stream returns n bytes of a stream starting at offset, each byte telling
+// where it sits in it, so that a misplaced byte shows.
+func stream(offset int64, n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte((offset + int64(i)) % 251)
+	}
+	return data
+}
+
+// checkBacklog compares b against the last bytes of want, the whole stream
+// written to it from offset on.
+func checkBacklog(t *testing.T, b *backlog, offset int64, want []byte) {
+	t.Helper()
+	end := offset + int64(len(want))
+	if b.end != end {
+		t.Fatalf("backlog ends at %d, want %d", b.end, end)
+	}
+	held := min(len(want), len(b.buf))
+	if b.start() != end-int64(held) {
+		t.Fatalf("backlog starts at %d, want %d", b.start(), end-int64(held))
+	}
+
+	for _, from := range []int64{b.start(), b.start() + 1, (b.start() + end) / 2, end - 1, end} {
+		if from < b.start() || from > end {
+			continue
+		}
+		data, ok := b.since(from)
+		if !ok {
+			t.Errorf("since(%d) = false, want the stream from there", from)
+			continue
+		}
+		if !bytes.Equal(data, want[from-offset:]) {
+			t.Errorf("since(%d) returned %d bytes that differ from the stream", from, len(data))
+		}
+	}
+	if _, ok := b.since(b.start() - 1); ok {
+		t.Errorf("since(%d) = true, before the start of the backlog", b.start()-1)
+	}
+	if _, ok := b.since(end + 1); ok {
+		t.Errorf("since(%d) = true, past the end of the backlog", end+1)
+	}
+}
+
+func TestBacklog(t *testing.T) {
+	size := minBacklogSize
+	tests := []struct {
+		name   string
+		offset int64
+		writes []int
+	}{
+		{name: "empty", offset: 0, writes: nil},
+		{name: "partly full", offset: 0, writes: []int{10, 100, 1000}},
+		{name: "exactly full", offset: 0, writes: []int{size / 2, size / 2}},
+		{name: "wraps around", offset: 0, writes: []int{size - 10, 30, 7}},
+		{name: "wraps many times", offset: 0, writes: []int{size / 3, size / 3, size / 3, size / 3, size / 3, size / 3, 5}},
+		{name: "write larger than the backlog", offset: 0, writes: []int{100, 3*size + 17}},
+		{name: "starts part way into the stream", offset: 123456, writes: []int{size - 1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBacklog(size, tt.offset)
+			var written []byte
+			for _, n := range tt.writes {
+				data := stream(tt.offset+int64(len(written)), n)
+				b.write(data)
+				written = append(written, data...)
+				checkBacklog(t, b, tt.offset, written)
+			}
+			checkBacklog(t, b, tt.offset, written)
+		})
+	}
+}
+
+func TestBacklogMinimumSize(t *testing.T) {
+	if b := newBacklog(1, 0); len(b.buf) != minBacklogSize {
+		t.Errorf("backlog of %d bytes, want at least %d", len(b.buf), minBacklogSize)
+	}
+}
+
+func TestBacklogReset(t *testing.T) {
+	b := newBacklog(minBacklogSize, 0)
+	b.write(stream(0, 5000))
+	b.reset(9000)
+
+	if _, ok := b.since(4000); ok {
+		t.Errorf("since returned data from before the reset")
+	}
+	checkBacklog(t, b, 9000, nil)
+
+	data := stream(9000, 300)
+	b.write(data)
+	checkBacklog(t, b, 9000, data)
+}
+
+func TestBacklogResize(t *testing.T) {
+	tests := []struct {
+		name    string
+		written int
+		size    int
+	}{
+		{name: "grow", written: 3 * minBacklogSize, size: 2 * minBacklogSize},
+		{name: "shrink keeping everything", written: minBacklogSize, size: minBacklogSize},
+		{name: "shrink dropping the oldest", written: 3 * minBacklogSize, size: minBacklogSize},
+		{name: "below the minimum", written: 100, size: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBacklog(2*minBacklogSize, 0)
+			// Leave the ring wrapped around, so that the history is copied
+			// in two parts
+			written := stream(0, tt.written)
+			b.write(written[:tt.written/3])
+			b.write(written[tt.written/3:])
+
+			b.resize(tt.size)
+			if len(b.buf) != max(tt.size, minBacklogSize) {
+				t.Fatalf("backlog holds %d bytes after resize, want %d", len(b.buf), max(tt.size, minBacklogSize))
+			}
+			checkBacklog(t, b, 0, written)
+
+			more := stream(int64(len(written)), minBacklogSize/2)
+			b.write(more)
+			checkBacklog(t, b, 0, append(written, more...))
+		})
+	}
+}
@@ -0,0 +1,331 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IAmRiteshKoushik/bluedis/cmd"
+	"github.com/IAmRiteshKoushik/bluedis/resp"
+)
+
+// blockingPollInterval is how often a client parked on a blocking command
+// retries it while waiting for data to show up.
+const blockingPollInterval = 50 * time.Millisecond
+
+// Client holds the state of a single connection. Every client is served by its
+// own goroutine, which reads commands off the socket one at a time and writes
+// the replies back in order.
+type Client struct {
+	id     int64
+	server *Server
+	conn   net.Conn
+	reader *resp.Resp
+	writer *resp.Writer
+
+	closeOnce sync.Once
+	done      chan struct{} // Closed once the connection is being torn down
+
+	// Set when the connection belongs to a replica of this server
+	listeningPort int // As announced with REPLCONF listening-port
+	replica       *replica
+
+	// Replication offset right after the last write of this client, which
+	// WAIT waits for replicas to reach
+	writeOffset int64
+
+	// Transaction state, see multi.go
+	inMulti      bool
+	multiAborted bool // A command failed to queue, so EXEC has to refuse
+	queued       []queuedCommand
+	execUnit     *atomicUnit // Set while EXEC runs the queued commands
+
+	// Keys watched by the client, each mapped to whether it was already
+	// expired at the time, and whether any of them changed since. Both are
+	// guarded by the server's watchMu, see watch.go.
+	watching     map[string]bool
+	watchTouched bool
+
+	// Channels, patterns and shard channels the client is subscribed to, see
+	// pubsub.go. They are only changed by the client goroutine, with the
+	// server's pubsubMu held.
+	channels      map[string]bool
+	patterns      map[string]bool
+	shardChannels map[string]bool
+
+	// Set once the client subscribes to anything. From then on all of its
+	// output goes through it, in order with the messages pushed to it.
+	out *outbox
+}
+
+// clientCommand is a command that acts on the connection it arrives on rather
+// than on the dataset alone. It runs on the client goroutine outside of call,
+// and can reply on its own: the second return value of run is false when
+// there is nothing left to reply.
+type clientCommand struct {
+	spec cmd.Spec
+	run  func(c *Client, args []resp.Value) (resp.Value, bool)
+}
+
+var clientCommands = map[string]clientCommand{
+	"REPLCONF": {cmd.Spec{Arity: -1, Flags: cmd.FlagNoScript | cmd.FlagNoMulti, Summary: "An internal command for configuring the replication stream.", Group: "server"}, (*Client).replconf},
+	"PSYNC":    {cmd.Spec{Arity: 3, Flags: cmd.FlagNoScript | cmd.FlagNoMulti, Summary: "An internal command used in replication.", Group: "server"}, (*Client).psync},
+	"SYNC":     {cmd.Spec{Arity: 1, Flags: cmd.FlagNoScript | cmd.FlagNoMulti, Summary: "An internal command used in replication.", Group: "server"}, (*Client).sync},
+	"WAIT":     {cmd.Spec{Arity: 3, Flags: cmd.FlagNoScript | cmd.FlagNoMulti, Summary: "Blocks until the asynchronous replication of all preceding write commands sent by the connection is completed.", Group: "generic"}, (*Client).wait},
+	"MULTI":    {cmd.Spec{Arity: 1, Flags: cmd.FlagNoScript, Summary: "Starts a transaction.", Group: "transactions"}, (*Client).multi},
+	"EXEC":     {cmd.Spec{Arity: 1, Flags: cmd.FlagNoScript, Summary: "Executes all commands in a transaction.", Group: "transactions"}, (*Client).exec},
+	"DISCARD":  {cmd.Spec{Arity: 1, Flags: cmd.FlagNoScript, Summary: "Discards a transaction.", Group: "transactions"}, (*Client).discard},
+	"WATCH":    {cmd.Spec{Arity: -2, Flags: cmd.FlagNoScript | cmd.FlagNoMulti, FirstKey: 1, LastKey: -1, KeyStep: 1, Summary: "Monitors changes to keys to determine the execution of a transaction.", Group: "transactions"}, (*Client).watch},
+	"UNWATCH":  {cmd.Spec{Arity: 1, Flags: cmd.FlagNoScript, Summary: "Forgets about watched keys of a transaction.", Group: "transactions"}, (*Client).unwatchCommand},
+	"EVAL":     {cmd.Spec{Arity: -3, Flags: cmd.FlagMovableKeys | cmd.FlagNoScript, FirstKey: 3, Summary: "Executes a server-side Lua script.", Group: "scripting"}, (*Client).eval},
+	"EVALSHA":  {cmd.Spec{Arity: -3, Flags: cmd.FlagMovableKeys | cmd.FlagNoScript, FirstKey: 3, Summary: "Executes a server-side Lua script by SHA1 digest.", Group: "scripting"}, (*Client).evalsha},
+	"SCRIPT":   {cmd.Spec{Arity: -2, Flags: cmd.FlagNoScript, Summary: "Manages the server-side Lua script cache.", Group: "scripting"}, (*Client).script},
+	"FUNCTION": {functionSpec, (*Client).function},
+	"FCALL":    {cmd.Spec{Arity: -3, Flags: cmd.FlagMovableKeys | cmd.FlagNoScript, FirstKey: 3, Summary: "Invokes a function.", Group: "scripting"}, (*Client).fcall},
+	"FCALL_RO": {cmd.Spec{Arity: -3, Flags: cmd.FlagMovableKeys | cmd.FlagNoScript, FirstKey: 3, Summary: "Invokes a read-only function.", Group: "scripting"}, (*Client).fcallRO},
+
+	"SUBSCRIBE": {cmd.Spec{Arity: -2, Flags: cmd.FlagNoScript | cmd.FlagNoMulti, Summary: "Listens for messages published to channels.", Group: "pubsub"}, func(c *Client, args []resp.Value) (resp.Value, bool) {
+		return c.subscribe("subscribe", args)
+	}},
+	"PSUBSCRIBE": {cmd.Spec{Arity: -2, Flags: cmd.FlagNoScript | cmd.FlagNoMulti, Summary: "Listens for messages published to channels that match one or more patterns.", Group: "pubsub"}, func(c *Client, args []resp.Value) (resp.Value, bool) {
+		return c.subscribe("psubscribe", args)
+	}},
+	"UNSUBSCRIBE": {cmd.Spec{Arity: -1, Flags: cmd.FlagNoScript | cmd.FlagNoMulti, Summary: "Stops listening to messages posted to channels.", Group: "pubsub"}, func(c *Client, args []resp.Value) (resp.Value, bool) {
+		return c.unsubscribe("unsubscribe", args)
+	}},
+	"PUNSUBSCRIBE": {cmd.Spec{Arity: -1, Flags: cmd.FlagNoScript | cmd.FlagNoMulti, Summary: "Stops listening to messages published to channels that match one or more patterns.", Group: "pubsub"}, func(c *Client, args []resp.Value) (resp.Value, bool) {
+		return c.unsubscribe("punsubscribe", args)
+	}},
+	"SSUBSCRIBE": {cmd.Spec{Arity: -2, Flags: cmd.FlagNoScript | cmd.FlagNoMulti, FirstKey: 1, LastKey: -1, KeyStep: 1, Summary: "Listens for messages published to shard channels.", Group: "pubsub"}, func(c *Client, args []resp.Value) (resp.Value, bool) {
+		return c.subscribe("ssubscribe", args)
+	}},
+	"SUNSUBSCRIBE": {cmd.Spec{Arity: -1, Flags: cmd.FlagNoScript | cmd.FlagNoMulti, FirstKey: 1, LastKey: -1, KeyStep: 1, Summary: "Stops listening to messages posted to shard channels.", Group: "pubsub"}, func(c *Client, args []resp.Value) (resp.Value, bool) {
+		return c.unsubscribe("sunsubscribe", args)
+	}},
+}
+
+// transactionCommands are run right away between MULTI and EXEC rather than
+// queued.
+var transactionCommands = map[string]bool{
+	"MULTI":   true,
+	"EXEC":    true,
+	"DISCARD": true,
+	"WATCH":   true,
+}
+
+func newClient(s *Server, id int64, conn net.Conn) *Client {
+	return &Client{
+		id:     id,
+		server: s,
+		conn:   conn,
+		// The reader has to live as long as the connection. It buffers ahead, so
+		// re-creating it per command would drop pipelined requests on the floor.
+		reader: resp.NewResp(conn),
+		writer: resp.NewWriter(conn),
+		done:   make(chan struct{}),
+
+		channels:      make(map[string]bool),
+		patterns:      make(map[string]bool),
+		shardChannels: make(map[string]bool),
+	}
+}
+
+func (c *Client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// serve runs the read-dispatch-reply loop until the client disconnects or the
+// server shuts down.
+func (c *Client) serve() {
+	fmt.Printf("Client %d connected from %s\n", c.id, c.conn.RemoteAddr())
+
+	for {
+		value, err := c.reader.Read()
+		if err != nil {
+			select {
+			case <-c.done:
+				// The server closed the connection from under us while shutting down
+			default:
+				if err != io.EOF {
+					fmt.Println(err)
+				}
+			}
+			fmt.Printf("Client %d disconnected from Bluedis server.\n", c.id)
+			return
+		}
+
+		if value.Typ != "array" {
+			fmt.Println("Invalid request, expected array")
+			continue
+		}
+
+		if len(value.Array) == 0 {
+			fmt.Println("Invalid request, expected array length > 0")
+			continue
+		}
+
+		result, ok := c.dispatch(value)
+		// The stream of writes is all a replica gets from here on
+		if !ok || c.replica != nil {
+			continue
+		}
+		if c.out != nil {
+			c.out.send(result.Marshal())
+		} else if err := c.writer.Write(result); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// dispatch executes a single request. The second return value is false when
+// the request produced no reply.
+func (c *Client) dispatch(value resp.Value) (resp.Value, bool) {
+	command := strings.ToUpper(value.Array[0].Bulk)
+	c.server.totalCommandsProcessed.Add(1)
+
+	// Commands that cannot run with these arguments are turned down before
+	// anything else, which also aborts a transaction they were sent in
+	handler, spec, ok := cmd.Lookup(command)
+	clientCommand, isClientCommand := clientCommands[command]
+	if isClientCommand {
+		spec = clientCommand.spec
+	}
+	if !ok && !isClientCommand {
+		c.multiAborted = c.inMulti
+		return resp.Value{Typ: "error", Str: unknownCommand(value.Array)}, true
+	}
+	if !spec.CheckArity(len(value.Array)) {
+		c.multiAborted = c.inMulti
+		return resp.Value{Typ: "error", Str: fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(command))}, true
+	}
+
+	if c.subscriptions() > 0 {
+		if !subscriberCommands[command] {
+			return resp.Value{Typ: "error", Str: fmt.Sprintf("ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING are allowed in this context", strings.ToLower(command))}, true
+		}
+		if command == "PING" {
+			return subscriberPing(value.Array[1:]), true
+		}
+	}
+	if c.inMulti && !transactionCommands[command] {
+		return c.queue(command, value), true
+	}
+	if isClientCommand {
+		return clientCommand.run(c, value.Array[1:])
+	}
+
+	// A replica only takes writes from its primary, see applyFromPrimary
+	if spec.IsWrite() && c.server.readOnlyReplica() {
+		return resp.Value{Typ: "error", Str: "READONLY You can't write against a read only replica."}, true
+	}
+
+	if spec.IsBlocking() {
+		return c.block(command, handler, value.Array[1:]), true
+	}
+	return c.call(command, handler, value.Array[1:]), true
+}
+
+// unknownCommand returns the error for a command that does not exist, which
+// quotes the command as sent and the start of its arguments, the way Redis
+// does.
+func unknownCommand(command []resp.Value) string {
+	const maxArgs = 128
+
+	name := command[0].Bulk
+	if len(name) > maxArgs {
+		name = name[:maxArgs]
+	}
+	var args strings.Builder
+	for _, arg := range command[1:] {
+		if args.Len() >= maxArgs {
+			break
+		}
+		str := arg.Bulk
+		if len(str) > maxArgs-args.Len() {
+			str = str[:maxArgs-args.Len()]
+		}
+		fmt.Fprintf(&args, "'%s' ", str)
+	}
+	return fmt.Sprintf("ERR unknown command '%s', with args beginning with: %s", name, args.String())
+}
+
+// call runs a command. Write commands that changed the dataset are appended to
+// the AOF once they have run, so a command that failed or turned out to be a
+// no-op (deleting a missing key, say) is not persisted. Write commands run one
+// at a time, which keeps the AOF in the order they took effect in. Inside
+// EXEC, which already holds the exec lock, a command such as FUNCTION LOAD
+// joins the transaction instead.
+func (c *Client) call(command string, handler cmd.Handler, args []resp.Value) resp.Value {
+	if c.execUnit != nil {
+		return c.execUnit.call(command, args)
+	}
+
+	s := c.server
+	s.execMu.RLock()
+	defer s.execMu.RUnlock()
+
+	if !cmd.IsWrite(command) {
+		return handler(args)
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	dirty := cmd.DB.Dirty()
+	result := handler(args)
+	if cmd.DB.Dirty() != dirty {
+		offset, err := s.propagate(effectOf(command, args, result))
+		if err != nil {
+			return aofError(err)
+		}
+		c.writeOffset = offset
+	}
+	return result
+}
+
+// block runs a blocking command such as BLPOP. The handler itself never waits;
+// it replies null when there is nothing to hand out yet. The client goroutine
+// keeps retrying it until it produces a reply, the timeout (the last argument,
+// in seconds, where 0 means wait forever) elapses or the connection goes away.
+// Only this client is parked in the meantime, and the exec lock is only held
+// while an attempt runs.
+func (c *Client) block(command string, handler cmd.Handler, args []resp.Value) resp.Value {
+	result := c.call(command, handler, args)
+	if result.Typ != "null" {
+		return result
+	}
+
+	timeout, _ := strconv.Atoi(args[len(args)-1].Bulk)
+
+	ticker := time.NewTicker(blockingPollInterval)
+	defer ticker.Stop()
+
+	var timerC <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(time.Duration(timeout) * time.Second)
+		defer timer.Stop()
+		timerC = timer.C
+	}
+
+	for {
+		select {
+		case <-timerC:
+			return resp.Value{Typ: "null"}
+		case <-c.done:
+			return resp.Value{Typ: "null"}
+		case <-ticker.C:
+		}
+
+		result = c.call(command, handler, args)
+		if result.Typ != "null" {
+			return result
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/IAmRiteshKoushik/bluedis/cmd"
+	"github.com/IAmRiteshKoushik/bluedis/resp"
+)
+
+// ACL category of the commands of each group, as listed by COMMAND INFO
+var groupCategories = map[string]string{
+	"generic":      "@keyspace",
+	"string":       "@string",
+	"list":         "@list",
+	"hash":         "@hash",
+	"sorted-set":   "@sortedset",
+	"bitmap":       "@bitmap",
+	"bf":           "@bloom",
+	"pubsub":       "@pubsub",
+	"transactions": "@transaction",
+	"scripting":    "@scripting",
+	"server":       "@admin",
+	"connection":   "@connection",
+}
+
+// commandSpecs returns the spec of every command the server knows by name: the
+// registered ones and those handled per connection.
+func commandSpecs() map[string]cmd.Spec {
+	specs := cmd.Specs()
+	for name, command := range clientCommands {
+		specs[name] = command.spec
+	}
+	return specs
+}
+
+// commandCommand describes the commands of the server to clients, from the
+// same specs the server goes by to run them.
+func commandCommand(args []resp.Value) resp.Value {
+	specs := commandSpecs()
+	if len(args) == 0 {
+		return commandInfos(specs, sortedNames(specs))
+	}
+
+	switch subcommand := strings.ToUpper(args[0].Bulk); {
+	case subcommand == "COUNT" && len(args) == 1:
+		return resp.Value{Typ: "integer", Num: len(specs)}
+	case subcommand == "INFO":
+		names := sortedNames(specs)
+		if len(args) > 1 {
+			names = names[:0]
+			for _, arg := range args[1:] {
+				names = append(names, strings.ToUpper(arg.Bulk))
+			}
+		}
+		return commandInfos(specs, names)
+	case subcommand == "DOCS":
+		names := sortedNames(specs)
+		if len(args) > 1 {
+			names = names[:0]
+			for _, arg := range args[1:] {
+				names = append(names, strings.ToUpper(arg.Bulk))
+			}
+		}
+		// A flat map of name to docs, leaving out unknown commands
+		reply := resp.Value{Typ: "array", Array: []resp.Value{}}
+		for _, name := range names {
+			spec, ok := specs[name]
+			if !ok {
+				continue
+			}
+			reply.Array = append(reply.Array,
+				resp.Value{Typ: "bulk", Bulk: strings.ToLower(name)},
+				commandDocs(spec))
+		}
+		return reply
+	case subcommand == "LIST":
+		return commandList(specs, args[1:])
+	case subcommand == "GETKEYS" && len(args) > 1:
+		spec, ok := specs[strings.ToUpper(args[1].Bulk)]
+		if !ok {
+			return resp.Value{Typ: "error", Str: "ERR Invalid command specified"}
+		}
+		if !spec.CheckArity(len(args) - 1) {
+			return resp.Value{Typ: "error", Str: "ERR Invalid number of arguments specified for command"}
+		}
+		keys := spec.Keys(args[1:])
+		if len(keys) == 0 {
+			return resp.Value{Typ: "error", Str: "ERR The command has no key arguments"}
+		}
+		return command(keys...)
+	default:
+		return resp.Value{Typ: "error", Str: fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try COMMAND HELP.", args[0].Bulk)}
+	}
+}
+
+// commandList replies to COMMAND LIST, which gives the names of the commands,
+// optionally filtered.
+func commandList(specs map[string]cmd.Spec, args []resp.Value) resp.Value {
+	names := sortedNames(specs)
+	if len(args) == 0 {
+		for i, name := range names {
+			names[i] = strings.ToLower(name)
+		}
+		return command(names...)
+	}
+	if len(args) != 3 || strings.ToUpper(args[0].Bulk) != "FILTERBY" {
+		return resp.Value{Typ: "error", Str: "ERR syntax error"}
+	}
+
+	var match func(name string, spec cmd.Spec) bool
+	switch filter, value := strings.ToUpper(args[1].Bulk), args[2].Bulk; filter {
+	case "MODULE":
+		// Modules are not supported, so none of the commands come from one
+		match = func(string, cmd.Spec) bool { return false }
+	case "ACLCAT":
+		match = func(name string, spec cmd.Spec) bool {
+			for _, category := range commandCategories(spec) {
+				if strings.EqualFold(category, "@"+value) {
+					return true
+				}
+			}
+			return false
+		}
+	case "PATTERN":
+		match = func(name string, spec cmd.Spec) bool {
+			return globMatch(strings.ToLower(value), name)
+		}
+	default:
+		return resp.Value{Typ: "error", Str: "ERR syntax error"}
+	}
+
+	matched := []string{}
+	for _, name := range names {
+		if match(strings.ToLower(name), specs[name]) {
+			matched = append(matched, strings.ToLower(name))
+		}
+	}
+	return command(matched...)
+}
+
+// commandInfos replies with the info of each named command, or a null for those
+// that do not exist.
+func commandInfos(specs map[string]cmd.Spec, names []string) resp.Value {
+	reply := resp.Value{Typ: "array", Array: make([]resp.Value, 0, len(names))}
+	for _, name := range names {
+		spec, ok := specs[name]
+		if !ok {
+			reply.Array = append(reply.Array, resp.Value{Typ: "null"})
+			continue
+		}
+		reply.Array = append(reply.Array, commandInfo(name, spec))
+	}
+	return reply
+}
+
+// commandInfo describes a command the way COMMAND INFO does: its name, arity,
+// flags, first key, last key and key step, ACL categories, then tips, key
+// specs and subcommands, which are left empty.
+func commandInfo(name string, spec cmd.Spec) resp.Value {
+	flags := []string{}
+	switch {
+	case spec.IsWrite():
+		flags = append(flags, "write")
+	case readOnly(spec):
+		flags = append(flags, "readonly")
+	}
+	if spec.IsBlocking() {
+		flags = append(flags, "blocking")
+	}
+	if spec.Flags&cmd.FlagNoScript != 0 {
+		flags = append(flags, "noscript")
+	}
+	if spec.Flags&cmd.FlagNoMulti != 0 {
+		flags = append(flags, "no_multi")
+	}
+	if spec.Flags&cmd.FlagMovableKeys != 0 {
+		flags = append(flags, "movablekeys")
+	}
+
+	// Commands with movable keys say where the keys are in their arguments
+	// instead, as Redis does for EVAL
+	first, last, step := spec.FirstKey, spec.LastKey, spec.KeyStep
+	if spec.Flags&cmd.FlagMovableKeys != 0 {
+		first, last, step = 0, 0, 0
+	}
+
+	empty := resp.Value{Typ: "array", Array: []resp.Value{}}
+	return resp.Value{Typ: "array", Array: []resp.Value{
+		{Typ: "bulk", Bulk: strings.ToLower(name)},
+		{Typ: "integer", Num: spec.Arity},
+		simpleStrings(flags),
+		{Typ: "integer", Num: first},
+		{Typ: "integer", Num: last},
+		{Typ: "integer", Num: step},
+		simpleStrings(commandCategories(spec)),
+		empty,
+		empty,
+		empty,
+	}}
+}
+
+// commandDocs describes a command the way COMMAND DOCS does, as a flat map of
+// the fields it has docs for.
+func commandDocs(spec cmd.Spec) resp.Value {
+	docs := resp.Value{Typ: "array", Array: []resp.Value{}}
+	if spec.Summary != "" {
+		docs.Array = append(docs.Array,
+			resp.Value{Typ: "bulk", Bulk: "summary"},
+			resp.Value{Typ: "bulk", Bulk: spec.Summary})
+	}
+	if spec.Group != "" {
+		docs.Array = append(docs.Array,
+			resp.Value{Typ: "bulk", Bulk: "group"},
+			resp.Value{Typ: "bulk", Bulk: spec.Group})
+	}
+	return docs
+}
+
+// commandCategories returns the ACL categories of a command, derived from its
+// flags and group.
+func commandCategories(spec cmd.Spec) []string {
+	var categories []string
+	switch {
+	case spec.IsWrite():
+		categories = append(categories, "@write")
+	case readOnly(spec):
+		categories = append(categories, "@read")
+	}
+	if spec.IsBlocking() {
+		categories = append(categories, "@blocking")
+	}
+	if category, ok := groupCategories[spec.Group]; ok {
+		categories = append(categories, category)
+	}
+	return categories
+}
+
+// readOnly reports whether a command reads keys without writing any. Whether
+// one with movable keys writes is up to the script it runs.
+func readOnly(spec cmd.Spec) bool {
+	return !spec.IsWrite() && spec.FirstKey > 0 && spec.Flags&cmd.FlagMovableKeys == 0
+}
+
+func sortedNames(specs map[string]cmd.Spec) []string {
+	names := make([]string, 0, len(specs))
+	for name := range specs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func simpleStrings(strs []string) resp.Value {
+	value := resp.Value{Typ: "array", Array: make([]resp.Value, 0, len(strs))}
+	for _, str := range strs {
+		value.Array = append(value.Array, resp.Value{Typ: "string", Str: str})
+	}
+	return value
+}
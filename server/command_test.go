@@ -0,0 +1,103 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCommandInfo(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+
+	tests := []struct {
+		name string
+		want string // Flattened reply, leaving out the empty fields
+	}{
+		{"get", "get 2 readonly 1 1 1 @read @string"},
+		{"SET", "set -3 write 1 1 1 @write @string"},
+		{"blpop", "blpop -3 write blocking 1 -2 1 @write @blocking @list"},
+		{"del", "del -2 write 1 -1 1 @write @keyspace"},
+		{"eval", "eval -3 noscript movablekeys 0 0 0 @scripting"},
+		{"multi", "multi 1 noscript 0 0 0 @transaction"},
+		{"save", "save 1 noscript 0 0 0 @admin"},
+		{"nosuch", "(nil)"},
+	}
+	for _, tt := range tests {
+		got := strings.Join(strings.Fields(flatten(c.do("COMMAND", "INFO", tt.name))), " ")
+		if got != tt.want {
+			t.Errorf("COMMAND INFO %s = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// COMMAND alone describes every command, as many as COMMAND COUNT says
+	count := c.do("COMMAND", "COUNT").Num
+	if all := c.do("COMMAND"); len(all.Array) != count || count == 0 {
+		t.Errorf("COMMAND described %d commands, COMMAND COUNT says %d", len(all.Array), count)
+	}
+	if list := c.do("COMMAND", "LIST"); len(list.Array) != count {
+		t.Errorf("COMMAND LIST gave %d names, want %d", len(list.Array), count)
+	}
+	if got := flatten(c.do("COMMAND", "LIST", "FILTERBY", "PATTERN", "bf.m*")); got != "bf.madd bf.mexists" {
+		t.Errorf("COMMAND LIST FILTERBY PATTERN = %q", got)
+	}
+	if got := flatten(c.do("COMMAND", "LIST", "FILTERBY", "ACLCAT", "transaction")); got != "discard exec multi unwatch watch" {
+		t.Errorf("COMMAND LIST FILTERBY ACLCAT = %q", got)
+	}
+}
+
+func TestCommandDocs(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+
+	got := flatten(c.do("COMMAND", "DOCS", "get", "nosuch", "subscribe"))
+	want := "get summary Returns the string value of a key. group string subscribe summary"
+	if !strings.HasPrefix(got, want) || !strings.HasSuffix(got, "group pubsub") {
+		t.Errorf("COMMAND DOCS = %q, want %q ... group pubsub", got, want)
+	}
+
+	// Every command has docs
+	docs := c.do("COMMAND", "DOCS")
+	if count := c.do("COMMAND", "COUNT").Num; len(docs.Array) != 2*count {
+		t.Errorf("COMMAND DOCS has %d entries, want %d", len(docs.Array)/2, count)
+	}
+	for i := 1; i < len(docs.Array); i += 2 {
+		if len(docs.Array[i].Array) != 4 {
+			t.Errorf("COMMAND DOCS of %s = %q", docs.Array[i-1].Bulk, flatten(docs.Array[i]))
+		}
+	}
+}
+
+func TestCommandGetkeys(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+
+	tests := []struct {
+		args []string
+		want string // Type, then the flattened reply
+	}{
+		{[]string{"GET", "k"}, "array k"},
+		{[]string{"DEL", "a", "b"}, "array a b"},
+		{[]string{"BLPOP", "a", "b", "0"}, "array a b"},
+		{[]string{"EVAL", "return 1", "2", "a", "b", "x"}, "array a b"},
+		{[]string{"FCALL", "f", "1", "a", "x"}, "array a"},
+		{[]string{"PING"}, "error ERR The command has no key arguments"},
+		{[]string{"GET"}, "error ERR Invalid number of arguments"},
+		{[]string{"NOSUCH", "k"}, "error ERR Invalid command specified"},
+	}
+	for _, tt := range tests {
+		reply := c.do(append([]string{"COMMAND", "GETKEYS"}, tt.args...)...)
+		if got := reply.Typ + " " + flatten(reply); !strings.HasPrefix(got, tt.want) {
+			t.Errorf("COMMAND GETKEYS %q = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"COMMAND", "NOSUCH"},
+		{"COMMAND", "COUNT", "1"},
+		{"COMMAND", "LIST", "FILTERBY", "NAME", "x"},
+	} {
+		if reply := c.do(args...); reply.Typ != "error" {
+			t.Errorf("%q replied %+v, want an error", args, reply)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/IAmRiteshKoushik/bluedis/cmd"
+	"github.com/IAmRiteshKoushik/bluedis/resp"
+)
+
+// config implements CONFIG GET pattern [pattern ...] and CONFIG SET name value
+// [name value ...].
+func (s *Server) config(args []resp.Value) resp.Value {
+	if len(args) == 0 {
+		return resp.Value{Typ: "error", Str: "ERR wrong number of arguments for 'config' command"}
+	}
+
+	subcommand := args[0].Bulk
+	args = args[1:]
+
+	switch strings.ToUpper(subcommand) {
+	case "GET":
+		if len(args) == 0 {
+			return resp.Value{Typ: "error", Str: "ERR wrong number of arguments for 'config|get' command"}
+		}
+		return s.configGet(args)
+	case "SET":
+		if len(args) == 0 || len(args)%2 != 0 {
+			return resp.Value{Typ: "error", Str: "ERR wrong number of arguments for 'config|set' command"}
+		}
+		return s.configSet(args)
+	}
+
+	return resp.Value{
+		Typ: "error",
+		Str: fmt.Sprintf("ERR unknown subcommand '%s'. Try CONFIG GET or CONFIG SET.", subcommand),
+	}
+}
+
+func (s *Server) configGet(args []resp.Value) resp.Value {
+	patterns := make([]string, len(args))
+	for i, arg := range args {
+		patterns[i] = arg.Bulk
+	}
+
+	values := s.cfg.Get(patterns...)
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	reply := make([]resp.Value, 0, 2*len(names))
+	for _, name := range names {
+		reply = append(reply,
+			resp.Value{Typ: "bulk", Bulk: name},
+			resp.Value{Typ: "bulk", Bulk: values[name]},
+		)
+	}
+	return resp.Value{Typ: "array", Array: reply}
+}
+
+// configSet applies every name/value pair or none of them: when one is
+// rejected, the ones before it are put back the way they were.
+func (s *Server) configSet(args []resp.Value) resp.Value {
+	var previous [][2]string
+	for i := 0; i < len(args); i += 2 {
+		name, value := strings.ToLower(args[i].Bulk), args[i+1].Bulk
+
+		old := s.cfg.Get(name)[name]
+		if err := s.cfg.Set(name, value); err != nil {
+			for j := len(previous) - 1; j >= 0; j-- {
+				s.cfg.Set(previous[j][0], previous[j][1])
+			}
+			s.applyConfig()
+			return resp.Value{
+				Typ: "error",
+				Str: fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - %v", name, err),
+			}
+		}
+		previous = append(previous, [2]string{name, old})
+	}
+
+	if err := s.applyConfig(); err != nil {
+		return resp.Value{Typ: "error", Str: "ERR " + err.Error()}
+	}
+	return resp.Value{Typ: "string", Str: "OK"}
+}
+
+// applyConfig pushes the current settings to the parts of the server that
+// depend on them.
+func (s *Server) applyConfig() error {
+	cfg := s.cfg.Snapshot()
+	s.aof.SetRewriteThreshold(cfg.AutoAofRewritePercentage, cfg.AutoAofRewriteMinSize)
+	s.aof.SetArchiveHistory(cfg.AofArchiveHistory)
+	cmd.SetKeyspaceEvents(cfg.NotifyKeyspaceEvents)
+
+	s.replMu.Lock()
+	s.backlog.resize(int(cfg.ReplBacklogSize))
+	s.replMu.Unlock()
+
+	return s.aof.SetFsyncPolicy(cfg.AppendFsync)
+}
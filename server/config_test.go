@@ -0,0 +1,75 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfigGet(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+
+	tests := []struct {
+		args []string
+		want string // Name/value pairs of the reply, in order
+	}{
+		{[]string{"appendfsync"}, "appendfsync everysec"},
+		{[]string{"APPENDFSYNC"}, "appendfsync everysec"},
+		{[]string{"append*"}, "appenddirname appendonlydir appendfilename database.aof appendfsync everysec"},
+		{[]string{"auto-aof-rewrite-*"}, "auto-aof-rewrite-min-size 67108864 auto-aof-rewrite-percentage 100"},
+		{[]string{"port", "port"}, "port 0"},
+		{[]string{"nosuchoption"}, ""},
+	}
+	for _, tt := range tests {
+		reply := c.do(append([]string{"CONFIG", "GET"}, tt.args...)...)
+		var got []string
+		for _, v := range reply.Array {
+			got = append(got, v.Bulk)
+		}
+		if reply.Typ != "array" || strings.Join(got, " ") != tt.want {
+			t.Errorf("CONFIG GET %q = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestConfigSet(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+
+	if reply := c.do("CONFIG", "SET", "appendfsync", "always", "auto-aof-rewrite-min-size", "1mb"); reply.Str != "OK" {
+		t.Fatalf("CONFIG SET replied %+v", reply)
+	}
+	if got := c.do("CONFIG", "GET", "appendfsync").Array[1].Bulk; got != "always" {
+		t.Errorf("appendfsync = %q after CONFIG SET, want always", got)
+	}
+	if got := c.do("CONFIG", "GET", "auto-aof-rewrite-min-size").Array[1].Bulk; got != "1048576" {
+		t.Errorf("auto-aof-rewrite-min-size = %q after CONFIG SET, want 1048576", got)
+	}
+	// Writes carry on under the new policy
+	if reply := c.do("SET", t.Name(), "v"); reply.Str != "OK" {
+		t.Errorf("SET under appendfsync always replied %+v", reply)
+	}
+	c.do("DEL", t.Name())
+
+	// A rejected pair undoes the ones before it
+	reply := c.do("CONFIG", "SET", "appendfsync", "no", "auto-aof-rewrite-percentage", "-1")
+	if reply.Typ != "error" || !strings.Contains(reply.Str, "auto-aof-rewrite-percentage") {
+		t.Errorf("CONFIG SET with a bad value replied %+v", reply)
+	}
+	if got := c.do("CONFIG", "GET", "appendfsync").Array[1].Bulk; got != "always" {
+		t.Errorf("appendfsync = %q after a failed CONFIG SET, want always", got)
+	}
+
+	for _, args := range [][]string{
+		{"CONFIG"},
+		{"CONFIG", "GET"},
+		{"CONFIG", "SET", "appendfsync"},
+		{"CONFIG", "SET", "port", "7000"},
+		{"CONFIG", "SET", "nosuchoption", "1"},
+		{"CONFIG", "RESETSTAT"},
+	} {
+		if reply := c.do(args...); reply.Typ != "error" {
+			t.Errorf("%q replied %+v, want an error", args, reply)
+		}
+	}
+}
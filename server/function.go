@@ -0,0 +1,529 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/IAmRiteshKoushik/bluedis/cmd"
+	"github.com/IAmRiteshKoushik/bluedis/rdb"
+	"github.com/IAmRiteshKoushik/bluedis/resp"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+const (
+	// Name function libraries are compiled under, which shows up in their
+	// error messages
+	libraryChunkName = "user_function"
+
+	// How long the code of a library may take to register its functions
+	libraryLoadTimeout = 500 * time.Millisecond
+)
+
+// Flags a function can be registered with. Only no-writes makes a difference
+// here: such a function can be called with FCALL_RO, and cannot write.
+var functionFlags = map[string]bool{
+	"no-writes":             true,
+	"allow-oom":             true,
+	"allow-stale":           true,
+	"no-cluster":            true,
+	"allow-cross-slot-keys": true,
+}
+
+// functionSpec describes FUNCTION both to the registry, which runs it when the
+// AOF is replayed or a primary streams it, and to dispatch, which has it
+// answered by the client command. Scripts cannot call it: flushing the
+// libraries from a function would pull the rug from under it.
+var functionSpec = cmd.Spec{
+	Arity:   -2,
+	Flags:   cmd.FlagWrite | cmd.FlagNoScript,
+	Summary: "Loads, lists, deletes, dumps and restores function libraries.",
+	Group:   "scripting",
+}
+
+// Subcommands of FUNCTION that change the libraries
+var functionWrites = map[string]bool{
+	"LOAD":    true,
+	"DELETE":  true,
+	"FLUSH":   true,
+	"RESTORE": true,
+}
+
+// library is a function library, compiled. The keyspace only keeps the code
+// of libraries, which gets compiled on first use, see syncLibraries.
+type library struct {
+	name      string
+	code      string
+	proto     *lua.FunctionProto
+	functions map[string]*luaFunction
+}
+
+// luaFunction is a function registered by a library.
+type luaFunction struct {
+	name        string
+	library     *library
+	description string
+	flags       []string
+}
+
+func (f *luaFunction) noWrites() bool {
+	return slices.Contains(f.flags, "no-writes")
+}
+
+// compileLibrary compiles the code of a library and runs it to find out
+// which functions it registers. The code starts with a line such as
+// "#!lua name=mylib" naming the engine and the library. The error is meant
+// to be sent to the client as is.
+func compileLibrary(code string) (*library, error) {
+	metadata, body, _ := strings.Cut(code, "\n")
+	fields := strings.Fields(strings.TrimPrefix(metadata, "#!"))
+	if !strings.HasPrefix(metadata, "#!") || len(fields) == 0 {
+		return nil, errors.New("ERR Missing library metadata")
+	}
+	if !strings.EqualFold(fields[0], "lua") {
+		return nil, fmt.Errorf("ERR Engine '%s' not found", fields[0])
+	}
+
+	lib := &library{code: code, functions: make(map[string]*luaFunction)}
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok || key != "name" {
+			return nil, fmt.Errorf("ERR Invalid metadata value given: %s", field)
+		}
+		lib.name = value
+	}
+	if lib.name == "" {
+		return nil, errors.New("ERR Library name was not given")
+	}
+	if !validFunctionName(lib.name) {
+		return nil, errors.New("ERR Library names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+
+	// The metadata line is left empty rather than dropped, so that the line
+	// numbers in error messages stay right
+	chunk, err := parse.Parse(strings.NewReader("\n"+body), libraryChunkName)
+	if err == nil {
+		lib.proto, err = lua.Compile(chunk, libraryChunkName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ERR Error compiling function: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), libraryLoadTimeout)
+	defer cancel()
+	L := newLuaState(ctx, nil)
+	defer L.Close()
+	err = runLibrary(L, lib.proto, func(L *lua.LState, fn *luaFunction, callback *lua.LFunction) {
+		if _, ok := lib.functions[fn.name]; ok {
+			L.RaiseError("Function already exists in the library")
+		}
+		fn.library = lib
+		lib.functions[fn.name] = fn
+	})
+	switch {
+	case ctx.Err() != nil:
+		return nil, errors.New("ERR FUNCTION LOAD timeout")
+	case err != nil:
+		msg := err.Error()
+		if apiErr, ok := err.(*lua.ApiError); ok {
+			msg = apiErr.Object.String()
+		}
+		return nil, fmt.Errorf("ERR Error registering functions: %s", msg)
+	case len(lib.functions) == 0:
+		return nil, errors.New("ERR No functions registered")
+	}
+	return lib, nil
+}
+
+// runLibrary runs the code of a library, which calls register for every
+// function it registers with redis.register_function.
+func runLibrary(L *lua.LState, proto *lua.FunctionProto, register func(L *lua.LState, fn *luaFunction, callback *lua.LFunction)) error {
+	redis := L.GetGlobal("redis").(*lua.LTable)
+	L.SetField(redis, "register_function", L.NewFunction(func(L *lua.LState) int {
+		fn, callback := luaFunctionArgs(L)
+		register(L, fn, callback)
+		return 0
+	}))
+
+	L.Push(L.NewFunctionFromProto(proto))
+	return L.PCall(0, 0, nil)
+}
+
+// luaFunctionArgs reads the arguments of redis.register_function, which are
+// either the name and the callback, or a table that can also hold a
+// description and flags.
+func luaFunctionArgs(L *lua.LState) (*luaFunction, *lua.LFunction) {
+	fn := &luaFunction{}
+	var callback lua.LValue
+	switch L.GetTop() {
+	case 1:
+		L.CheckTable(1).ForEach(func(key, value lua.LValue) {
+			switch key.String() {
+			case "function_name":
+				fn.name = value.String()
+			case "callback":
+				callback = value
+			case "description":
+				fn.description = value.String()
+			case "flags":
+				flags, ok := value.(*lua.LTable)
+				if !ok {
+					L.RaiseError("flags argument to redis.register_function must be a table representing function flags")
+				}
+				flags.ForEach(func(_, flag lua.LValue) {
+					if !functionFlags[flag.String()] {
+						L.RaiseError("unknown flag given")
+					}
+					fn.flags = append(fn.flags, flag.String())
+				})
+			default:
+				L.RaiseError("unknown argument given to redis.register_function")
+			}
+		})
+	case 2:
+		fn.name, callback = L.CheckString(1), L.Get(2)
+	default:
+		L.RaiseError("wrong number of arguments to redis.register_function")
+	}
+
+	if !validFunctionName(fn.name) {
+		L.RaiseError("Function names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+	function, ok := callback.(*lua.LFunction)
+	if !ok {
+		L.RaiseError("callback argument given to redis.register_function must be a function")
+	}
+	return fn, function
+}
+
+// validFunctionName reports whether name can name a library or a function.
+func validFunctionName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// syncLibraries brings the compiled libraries up to date with the code kept
+// in the keyspace, which changes under them when a snapshot gets loaded or a
+// replica syncs with its primary. The caller holds scriptMu.
+func (s *Server) syncLibraries() {
+	cmd.DB.RLock()
+	codes := cmd.DB.Libraries()
+	cmd.DB.RUnlock()
+
+	changed := false
+	for name, lib := range s.libraries {
+		if codes[name] != lib.code {
+			delete(s.libraries, name)
+			changed = true
+		}
+	}
+	for name, code := range codes {
+		if _, ok := s.libraries[name]; ok {
+			continue
+		}
+		lib, err := compileLibrary(code)
+		if err != nil {
+			fmt.Printf("Error loading function library '%s': %v\n", name, err)
+			continue
+		}
+		s.libraries[name] = lib
+		changed = true
+	}
+
+	if changed {
+		s.functions = make(map[string]*luaFunction)
+		for _, lib := range s.libraries {
+			for name, fn := range lib.functions {
+				s.functions[name] = fn
+			}
+		}
+	}
+}
+
+// setLibraries replaces the libraries with next, in the keyspace as well,
+// unless two of them register a function of the same name. The caller holds
+// scriptMu.
+func (s *Server) setLibraries(next map[string]*library) error {
+	functions := make(map[string]*luaFunction)
+	for _, lib := range next {
+		for name, fn := range lib.functions {
+			if _, ok := functions[name]; ok {
+				return fmt.Errorf("ERR Function %s already exists", name)
+			}
+			functions[name] = fn
+		}
+	}
+
+	cmd.DB.Lock()
+	for name := range s.libraries {
+		if next[name] == nil {
+			cmd.DB.RemoveLibrary(name)
+		}
+	}
+	for name, lib := range next {
+		if s.libraries[name] != lib {
+			cmd.DB.SetLibrary(name, lib.code)
+		}
+	}
+	cmd.DB.Unlock()
+
+	s.libraries, s.functions = next, functions
+	return nil
+}
+
+// function implements FUNCTION LOAD [REPLACE] code, DELETE library, FLUSH
+// [ASYNC|SYNC], LIST [WITHCODE] [LIBRARYNAME pattern], DUMP and RESTORE
+// payload [FLUSH|APPEND|REPLACE]. Libraries are part of the keyspace, so the
+// changes made to them are logged and replicated like any other write.
+func (s *Server) function(args []resp.Value) resp.Value {
+	if len(args) == 0 {
+		return resp.Value{Typ: "error", Str: "ERR wrong number of arguments for 'function' command"}
+	}
+
+	s.scriptMu.Lock()
+	defer s.scriptMu.Unlock()
+	s.syncLibraries()
+
+	switch subcommand := strings.ToUpper(args[0].Bulk); {
+	case subcommand == "LOAD" && (len(args) == 2 || len(args) == 3):
+		replace := len(args) == 3
+		if replace && !strings.EqualFold(args[1].Bulk, "REPLACE") {
+			return resp.Value{Typ: "error", Str: fmt.Sprintf("ERR Unknown option given: %s", args[1].Bulk)}
+		}
+		lib, err := compileLibrary(args[len(args)-1].Bulk)
+		if err != nil {
+			return errorReply(err.Error())
+		}
+		if _, ok := s.libraries[lib.name]; ok && !replace {
+			return resp.Value{Typ: "error", Str: fmt.Sprintf("ERR Library '%s' already exists", lib.name)}
+		}
+
+		next := maps.Clone(s.libraries)
+		next[lib.name] = lib
+		if err := s.setLibraries(next); err != nil {
+			return resp.Value{Typ: "error", Str: err.Error()}
+		}
+		return resp.Value{Typ: "bulk", Bulk: lib.name}
+
+	case subcommand == "DELETE" && len(args) == 2:
+		if _, ok := s.libraries[args[1].Bulk]; !ok {
+			return resp.Value{Typ: "error", Str: "ERR Library not found"}
+		}
+		next := maps.Clone(s.libraries)
+		delete(next, args[1].Bulk)
+		s.setLibraries(next)
+		return resp.Value{Typ: "string", Str: "OK"}
+
+	case subcommand == "FLUSH" && len(args) <= 2:
+		if len(args) == 2 {
+			if mode := strings.ToUpper(args[1].Bulk); mode != "ASYNC" && mode != "SYNC" {
+				return resp.Value{Typ: "error", Str: "ERR FUNCTION FLUSH only supports SYNC|ASYNC option"}
+			}
+		}
+		s.setLibraries(make(map[string]*library))
+		return resp.Value{Typ: "string", Str: "OK"}
+
+	case subcommand == "LIST":
+		return s.functionList(args[1:])
+
+	case subcommand == "DUMP" && len(args) == 1:
+		codes := make(map[string]string, len(s.libraries))
+		for name, lib := range s.libraries {
+			codes[name] = lib.code
+		}
+		return resp.Value{Typ: "bulk", Bulk: string(rdb.DumpLibraries(codes))}
+
+	case subcommand == "RESTORE" && (len(args) == 2 || len(args) == 3):
+		policy := "APPEND"
+		if len(args) == 3 {
+			policy = strings.ToUpper(args[2].Bulk)
+		}
+		if policy != "FLUSH" && policy != "APPEND" && policy != "REPLACE" {
+			return resp.Value{Typ: "error", Str: "ERR Wrong restore policy given, value should be either FLUSH, APPEND or REPLACE."}
+		}
+		codes, err := rdb.RestoreLibraries([]byte(args[1].Bulk))
+		if err != nil {
+			return resp.Value{Typ: "error", Str: "ERR payload version or checksum are wrong"}
+		}
+
+		next := maps.Clone(s.libraries)
+		if policy == "FLUSH" {
+			next = make(map[string]*library)
+		}
+		for _, code := range codes {
+			lib, err := compileLibrary(code)
+			if err != nil {
+				return errorReply(err.Error())
+			}
+			if _, ok := next[lib.name]; ok && policy == "APPEND" {
+				return resp.Value{Typ: "error", Str: fmt.Sprintf("ERR Library %s already exists", lib.name)}
+			}
+			next[lib.name] = lib
+		}
+		if err := s.setLibraries(next); err != nil {
+			return resp.Value{Typ: "error", Str: err.Error()}
+		}
+		return resp.Value{Typ: "string", Str: "OK"}
+
+	default:
+		return resp.Value{Typ: "error", Str: fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try FUNCTION HELP.", args[0].Bulk)}
+	}
+}
+
+// functionList implements FUNCTION LIST, which describes the libraries in
+// order of their names. The caller holds scriptMu.
+func (s *Server) functionList(args []resp.Value) resp.Value {
+	withCode, pattern := false, "*"
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Bulk) {
+		case "WITHCODE":
+			withCode = true
+		case "LIBRARYNAME":
+			if i+1 == len(args) {
+				return resp.Value{Typ: "error", Str: "ERR library name argument was not given"}
+			}
+			i++
+			pattern = args[i].Bulk
+		default:
+			return resp.Value{Typ: "error", Str: fmt.Sprintf("ERR Unknown argument %s", args[i].Bulk)}
+		}
+	}
+
+	names := make([]string, 0, len(s.libraries))
+	for name := range s.libraries {
+		if globMatch(pattern, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	reply := resp.Value{Typ: "array", Array: []resp.Value{}}
+	for _, name := range names {
+		lib := s.libraries[name]
+		functions := resp.Value{Typ: "array", Array: []resp.Value{}}
+		for _, fnName := range slices.Sorted(maps.Keys(lib.functions)) {
+			fn := lib.functions[fnName]
+			description := resp.Value{Typ: "null"}
+			if fn.description != "" {
+				description = resp.Value{Typ: "bulk", Bulk: fn.description}
+			}
+			functions.Array = append(functions.Array, resp.Value{Typ: "array", Array: []resp.Value{
+				{Typ: "bulk", Bulk: "name"},
+				{Typ: "bulk", Bulk: fn.name},
+				{Typ: "bulk", Bulk: "description"},
+				description,
+				{Typ: "bulk", Bulk: "flags"},
+				command(fn.flags...),
+			}})
+		}
+
+		entry := resp.Value{Typ: "array", Array: []resp.Value{
+			{Typ: "bulk", Bulk: "library_name"},
+			{Typ: "bulk", Bulk: lib.name},
+			{Typ: "bulk", Bulk: "engine"},
+			{Typ: "bulk", Bulk: "LUA"},
+			{Typ: "bulk", Bulk: "functions"},
+			functions,
+		}}
+		if withCode {
+			entry.Array = append(entry.Array,
+				resp.Value{Typ: "bulk", Bulk: "library_code"},
+				resp.Value{Typ: "bulk", Bulk: lib.code})
+		}
+		reply.Array = append(reply.Array, entry)
+	}
+	return reply
+}
+
+// function implements FUNCTION on behalf of a client. KILL has to get past
+// the function it stops, which holds the exec lock, so it is answered here;
+// everything else goes through call like any other command.
+func (c *Client) function(args []resp.Value) (resp.Value, bool) {
+	if len(args) == 0 {
+		return resp.Value{Typ: "error", Str: "ERR wrong number of arguments for 'function' command"}, true
+	}
+	subcommand := strings.ToUpper(args[0].Bulk)
+	if subcommand == "KILL" && len(args) == 1 {
+		return c.server.killScript(true), true
+	}
+	if functionWrites[subcommand] && c.server.readOnlyReplica() {
+		return resp.Value{Typ: "error", Str: "READONLY You can't write against a read only replica."}, true
+	}
+	return c.call("FUNCTION", c.server.function, args), true
+}
+
+// fcall implements FCALL function numkeys [key ...] [arg ...].
+func (c *Client) fcall(args []resp.Value) (resp.Value, bool) {
+	return c.callFunction("fcall", args), true
+}
+
+// fcallRO implements FCALL_RO, which only calls functions flagged no-writes.
+func (c *Client) fcallRO(args []resp.Value) (resp.Value, bool) {
+	return c.callFunction("fcall_ro", args), true
+}
+
+// callFunction runs a function the same way as a script, see runScript. The
+// library it belongs to runs first, to register its functions all over again
+// in the fresh interpreter; the function then gets the keys and the
+// arguments as its two parameters.
+func (c *Client) callFunction(name string, args []resp.Value) resp.Value {
+	if len(args) < 2 {
+		return resp.Value{Typ: "error", Str: fmt.Sprintf("ERR wrong number of arguments for '%s' command", name)}
+	}
+	numkeys, err := parseNumKeys(args[1].Bulk, len(args)-2)
+	if err != nil {
+		return resp.Value{Typ: "error", Str: err.Error()}
+	}
+	keys, argv := args[2:2+numkeys], args[2+numkeys:]
+
+	s := c.server
+	s.scriptMu.Lock()
+	s.syncLibraries()
+	fn, ok := s.functions[args[0].Bulk]
+	s.scriptMu.Unlock()
+	if !ok {
+		return resp.Value{Typ: "error", Str: "ERR Function not found"}
+	}
+	if name == "fcall_ro" && !fn.noWrites() {
+		return resp.Value{Typ: "error", Str: "ERR Can not execute a script with write flag using *_ro command."}
+	}
+	if !fn.noWrites() && s.readOnlyReplica() {
+		return resp.Value{Typ: "error", Str: "READONLY You can't write against a read only replica."}
+	}
+
+	return c.runScript(&runningScript{function: true, readOnly: fn.noWrites()}, func(L *lua.LState) resp.Value {
+		var callback *lua.LFunction
+		err := runLibrary(L, fn.library.proto, func(L *lua.LState, registered *luaFunction, registeredCallback *lua.LFunction) {
+			if registered.name == fn.name {
+				callback = registeredCallback
+			}
+		})
+		if err != nil {
+			return luaError(err, "call to "+fn.name)
+		}
+		if callback == nil {
+			return resp.Value{Typ: "error", Str: "ERR Function not found"}
+		}
+
+		L.Push(callback)
+		L.Push(luaStrings(L, keys))
+		L.Push(luaStrings(L, argv))
+		if err := L.PCall(2, 1, nil); err != nil {
+			return luaError(err, "call to "+fn.name)
+		}
+		return luaToResp(L.Get(-1))
+	})
+}
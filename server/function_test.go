@@ -0,0 +1,239 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+// testLibrary registers a function that writes, one that only reads, and
+// two that fail.
+const testLibrary = `#!lua name=testlib
+redis.register_function('store', function(keys, args)
+	return redis.call('SET', keys[1], args[1])
+end)
+redis.register_function{
+	function_name = 'fetch',
+	callback = function(keys) return redis.call('GET', keys[1]) end,
+	description = 'reads a key',
+	flags = {'no-writes'},
+}
+redis.register_function('flush', function()
+	return redis.call('FUNCTION', 'FLUSH')
+end)
+redis.register_function{
+	function_name = 'sneaky',
+	callback = function(keys) return redis.call('SET', keys[1], 'x') end,
+	flags = {'no-writes'},
+}
+`
+
+// loadTestLibrary loads testLibrary for the rest of the test.
+func loadTestLibrary(t *testing.T, c *testClient) {
+	t.Helper()
+	if reply := c.do("FUNCTION", "LOAD", testLibrary); reply.Bulk != "testlib" {
+		t.Fatalf("FUNCTION LOAD replied %+v", reply)
+	}
+	t.Cleanup(func() { c.do("FUNCTION", "FLUSH") })
+}
+
+func TestFcall(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	key := t.Name()
+	defer c.do("DEL", key)
+	loadTestLibrary(t, c)
+
+	tests := []struct {
+		args []string
+		want string // Type, then the flattened reply
+	}{
+		{[]string{"FCALL", "store", "1", key, "v"}, "string OK"},
+		{[]string{"FCALL", "fetch", "1", key}, "bulk v"},
+		{[]string{"FCALL_RO", "fetch", "1", key}, "bulk v"},
+		{[]string{"FCALL_RO", "store", "1", key, "w"}, "error ERR Can not execute a script with write flag"},
+		{[]string{"FCALL", "sneaky", "1", key}, "error ERR Write commands are not allowed from read-only scripts"},
+		{[]string{"FCALL", "flush", "0"}, "error ERR This Redis command is not allowed from script"},
+		{[]string{"FCALL", "nosuch", "0"}, "error ERR Function not found"},
+		{[]string{"FCALL", "store", "2", key}, "error ERR Number of keys can't be greater"},
+	}
+	for _, tt := range tests {
+		reply := c.do(tt.args...)
+		if got := reply.Typ + " " + flatten(reply); !strings.HasPrefix(got, tt.want) {
+			t.Errorf("%q = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+	if reply := c.do("GET", key); reply.Bulk != "v" {
+		t.Errorf("GET after the failed calls = %+v, want v", reply)
+	}
+}
+
+func TestFunctionLoad(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	loadTestLibrary(t, c)
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"exists", []string{"FUNCTION", "LOAD", testLibrary}, "ERR Library 'testlib' already exists"},
+		{"no metadata", []string{"FUNCTION", "LOAD", "return 1"}, "ERR Missing library metadata"},
+		{"unknown engine", []string{"FUNCTION", "LOAD", "#!python name=p\n"}, "ERR Engine 'python' not found"},
+		{"function taken", []string{"FUNCTION", "LOAD", "#!lua name=other\nredis.register_function('store', function() end)"}, "ERR Function store already exists"},
+		{"bad option", []string{"FUNCTION", "LOAD", "NOW", testLibrary}, "ERR Unknown option given: NOW"},
+		{"delete missing", []string{"FUNCTION", "DELETE", "nosuch"}, "ERR Library not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if reply := c.do(tt.args...); reply.Typ != "error" || !strings.HasPrefix(reply.Str, tt.want) {
+				t.Errorf("%q replied %+v, want %s", tt.args, reply, tt.want)
+			}
+		})
+	}
+
+	replaced := strings.Replace(testLibrary, "reads a key", "reads a key again", 1)
+	if reply := c.do("FUNCTION", "LOAD", "REPLACE", replaced); reply.Bulk != "testlib" {
+		t.Errorf("FUNCTION LOAD REPLACE replied %+v", reply)
+	}
+	list := flatten(c.do("FUNCTION", "LIST", "LIBRARYNAME", "test*"))
+	for _, want := range []string{"library_name testlib engine LUA", "name fetch description reads a key again flags no-writes"} {
+		if !strings.Contains(list, want) {
+			t.Errorf("FUNCTION LIST = %q, missing %q", list, want)
+		}
+	}
+	if got := flatten(c.do("FUNCTION", "LIST", "LIBRARYNAME", "other*")); got != "" {
+		t.Errorf("FUNCTION LIST of another name = %q", got)
+	}
+
+	if reply := c.do("FUNCTION", "DELETE", "testlib"); reply.Str != "OK" {
+		t.Errorf("FUNCTION DELETE replied %+v", reply)
+	}
+	if reply := c.do("FCALL", "fetch", "1", "k"); reply.Typ != "error" {
+		t.Errorf("FCALL after FUNCTION DELETE replied %+v", reply)
+	}
+}
+
+func TestFunctionDumpRestore(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	loadTestLibrary(t, c)
+
+	payload := c.do("FUNCTION", "DUMP").Bulk
+	if reply := c.do("FUNCTION", "RESTORE", payload); !strings.HasPrefix(reply.Str, "ERR Library testlib already exists") {
+		t.Errorf("FUNCTION RESTORE over an existing library replied %+v", reply)
+	}
+	c.do("FUNCTION", "FLUSH")
+	if reply := c.do("FUNCTION", "RESTORE", payload); reply.Str != "OK" {
+		t.Fatalf("FUNCTION RESTORE replied %+v", reply)
+	}
+	if reply := c.do("FUNCTION", "RESTORE", payload, "REPLACE"); reply.Str != "OK" {
+		t.Errorf("FUNCTION RESTORE REPLACE replied %+v", reply)
+	}
+	if reply := c.do("FCALL_RO", "fetch", "1", "no:such:key"); reply.Typ != "null" {
+		t.Errorf("FCALL after FUNCTION RESTORE replied %+v", reply)
+	}
+
+	for _, args := range [][]string{
+		{"FUNCTION", "RESTORE", "garbage"},
+		{"FUNCTION", "RESTORE", payload, "MERGE"},
+	} {
+		if reply := c.do(args...); reply.Typ != "error" {
+			t.Errorf("%q replied %+v, want an error", args[:2], reply)
+		}
+	}
+}
+
+func TestFunctionPropagation(t *testing.T) {
+	s, addr := startServer(t)
+	c := dial(t, addr)
+	key := t.Name()
+	defer c.do("DEL", key)
+
+	// Loading a library is a write, and calling a function logs what it did
+	before := len(logged(s))
+	loadTestLibrary(t, c)
+	c.do("FCALL", "store", "1", key, "v")
+	c.do("FCALL", "fetch", "1", key)
+
+	got := logged(s)[before:]
+	want := []string{"FUNCTION LOAD", "MULTI", "SET " + key + " v", "EXEC"}
+	if len(got) != len(want) || !strings.HasPrefix(got[0], want[0]) || strings.Join(got[1:], ",") != strings.Join(want[1:], ",") {
+		t.Errorf("logged %q, want %q", got, want)
+	}
+
+	// Libraries survive a rewrite of the AOF
+	c.do("BGREWRITEAOF")
+	waitFor(t, c, "persistence", "aof_rewrites", "1")
+	found := false
+	for _, line := range logged(s) {
+		found = found || strings.HasPrefix(line, "FUNCTION LOAD #!lua name=testlib")
+	}
+	if !found {
+		t.Errorf("rewritten AOF lost the library: %q", logged(s))
+	}
+}
+
+func TestFunctionKill(t *testing.T) {
+	_, addr := startServer(t)
+	c, other := dial(t, addr), dial(t, addr)
+	c.do("FUNCTION", "LOAD", "#!lua name=loop\nredis.register_function{function_name='spin', callback=function() while true do end end, flags={'no-writes'}}")
+	defer other.do("FUNCTION", "FLUSH")
+
+	c.send("FCALL", "spin", "0")
+	for {
+		reply := other.do("FUNCTION", "KILL")
+		if reply.Str == "OK" {
+			break
+		}
+		if !strings.HasPrefix(reply.Str, "NOTBUSY") {
+			t.Fatalf("FUNCTION KILL replied %+v", reply)
+		}
+	}
+	if reply := c.read(); !strings.Contains(reply.Str, "FUNCTION KILL") {
+		t.Errorf("killed FCALL replied %+v", reply)
+	}
+
+	// SCRIPT KILL does not stop functions, nor FUNCTION KILL scripts
+	c.send("EVAL", "while true do end", "0")
+	for {
+		reply := other.do("FUNCTION", "KILL")
+		if strings.HasPrefix(reply.Str, "BUSY") {
+			break
+		}
+		if !strings.HasPrefix(reply.Str, "NOTBUSY") {
+			t.Fatalf("FUNCTION KILL during EVAL replied %+v, want BUSY", reply)
+		}
+	}
+	other.do("SCRIPT", "KILL")
+	c.read()
+}
+
+func TestFunctionInMulti(t *testing.T) {
+	s, addr := startServer(t)
+	c := dial(t, addr)
+	key := t.Name()
+	defer c.do("DEL", key)
+	t.Cleanup(func() { c.do("FUNCTION", "FLUSH") })
+
+	before := len(logged(s))
+	c.do("MULTI")
+	for _, args := range [][]string{
+		{"FUNCTION", "LOAD", testLibrary},
+		{"FCALL", "store", "1", key, "v"},
+		{"FCALL_RO", "fetch", "1", key},
+	} {
+		if reply := c.do(args...); reply.Str != "QUEUED" {
+			t.Errorf("%.2q inside MULTI replied %+v, want QUEUED", args, reply)
+		}
+	}
+	if got := flatten(c.do("EXEC")); got != "testlib OK v" {
+		t.Errorf("EXEC replied %q", got)
+	}
+
+	// The library and what the function did go out as one transaction
+	got := logged(s)[before:]
+	if len(got) != 4 || got[0] != "MULTI" || !strings.HasPrefix(got[1], "FUNCTION LOAD") || got[2] != "SET "+key+" v" || got[3] != "EXEC" {
+		t.Errorf("EXEC logged %q", got)
+	}
+}
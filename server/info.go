@@ -0,0 +1,168 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/IAmRiteshKoushik/bluedis/cmd"
+	"github.com/IAmRiteshKoushik/bluedis/resp"
+)
+
+// infoSection renders one "# Name" block of the INFO reply as field:value
+// lines.
+type infoSection struct {
+	name   string
+	render func(s *Server) []string
+}
+
+// The order here is the order sections appear in the INFO reply
+var infoSections = []infoSection{
+	{"Server", (*Server).infoServer},
+	{"Clients", (*Server).infoClients},
+	{"Persistence", (*Server).infoPersistence},
+	{"Stats", (*Server).infoStats},
+	{"Replication", (*Server).infoReplication},
+	{"Keyspace", (*Server).infoKeyspace},
+}
+
+// info implements INFO [section ...]. Without arguments (or with "all",
+// "default" or "everything") every section is included.
+func (s *Server) info(args []resp.Value) resp.Value {
+	wanted := make(map[string]bool)
+	for _, arg := range args {
+		wanted[strings.ToLower(arg.Bulk)] = true
+	}
+	all := len(wanted) == 0 || wanted["all"] || wanted["default"] || wanted["everything"]
+
+	var b strings.Builder
+	for _, section := range infoSections {
+		if !all && !wanted[strings.ToLower(section.name)] {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString("# " + section.name + "\r\n")
+		for _, line := range section.render(s) {
+			b.WriteString(line + "\r\n")
+		}
+	}
+
+	return resp.Value{Typ: "bulk", Bulk: b.String()}
+}
+
+func (s *Server) infoServer() []string {
+	uptime := time.Since(s.startTime)
+	return []string{
+		"redis_mode:standalone",
+		fmt.Sprintf("process_id:%d", os.Getpid()),
+		fmt.Sprintf("tcp_port:%s", portOf(s.addr)),
+		fmt.Sprintf("uptime_in_seconds:%d", int64(uptime/time.Second)),
+		fmt.Sprintf("uptime_in_days:%d", int64(uptime/(24*time.Hour))),
+		fmt.Sprintf("hz:%d", int(time.Second/cronInterval)),
+	}
+}
+
+func (s *Server) infoClients() []string {
+	s.mu.Lock()
+	connected := len(s.clients)
+	s.mu.Unlock()
+
+	return []string{
+		fmt.Sprintf("connected_clients:%d", connected),
+	}
+}
+
+func (s *Server) infoPersistence() []string {
+	rewrite := s.aof.RewriteInfo()
+
+	inProgress := 0
+	if rewrite.InProgress {
+		inProgress = 1
+	}
+	status := "ok"
+	if rewrite.LastErr != nil {
+		status = "err"
+	}
+	lastRewriteTime := int64(-1)
+	if rewrite.Rewrites > 0 {
+		lastRewriteTime = int64(rewrite.LastRewriteDuration / time.Second)
+	}
+
+	s.saveMu.Lock()
+	changes := cmd.DB.Dirty() - s.lastSaveDirty
+	lastSave := s.lastSave
+	bgsaveStatus := "ok"
+	if s.lastBgsaveErr != nil {
+		bgsaveStatus = "err"
+	}
+	lastBgsaveTime := int64(-1)
+	if !s.lastBgsaveTry.IsZero() {
+		lastBgsaveTime = int64(s.lastBgsaveDuration / time.Second)
+	}
+	s.saveMu.Unlock()
+
+	bgsaveInProgress := 0
+	if s.saving.Load() {
+		bgsaveInProgress = 1
+	}
+
+	return []string{
+		fmt.Sprintf("rdb_changes_since_last_save:%d", changes),
+		fmt.Sprintf("rdb_bgsave_in_progress:%d", bgsaveInProgress),
+		fmt.Sprintf("rdb_last_save_time:%d", lastSave.Unix()),
+		fmt.Sprintf("rdb_last_bgsave_status:%s", bgsaveStatus),
+		fmt.Sprintf("rdb_last_bgsave_time_sec:%d", lastBgsaveTime),
+		"aof_enabled:1",
+		fmt.Sprintf("aof_rewrite_in_progress:%d", inProgress),
+		fmt.Sprintf("aof_rewrites:%d", rewrite.Rewrites),
+		fmt.Sprintf("aof_last_rewrite_time_sec:%d", lastRewriteTime),
+		fmt.Sprintf("aof_last_bgrewrite_status:%s", status),
+		fmt.Sprintf("aof_current_size:%d", rewrite.CurrentSize),
+		fmt.Sprintf("aof_base_size:%d", rewrite.BaseSize),
+	}
+}
+
+func (s *Server) infoStats() []string {
+	cmd.DB.RLock()
+	stats := cmd.DB.Stats()
+	cmd.DB.RUnlock()
+
+	s.pubsubMu.Lock()
+	pubsubChannels, pubsubPatterns := len(s.channels), len(s.patterns)
+	s.pubsubMu.Unlock()
+
+	return []string{
+		fmt.Sprintf("total_connections_received:%d", s.totalConnections.Load()),
+		fmt.Sprintf("total_commands_processed:%d", s.totalCommandsProcessed.Load()),
+		fmt.Sprintf("pubsub_channels:%d", pubsubChannels),
+		fmt.Sprintf("pubsub_patterns:%d", pubsubPatterns),
+		fmt.Sprintf("expired_keys:%d", stats.ExpiredKeys),
+		fmt.Sprintf("expired_stale_perc:%.2f", stats.ExpiredStalePerc*100),
+		fmt.Sprintf("expire_cycle_cpu_milliseconds:%d", stats.ExpireCycleTime.Milliseconds()),
+	}
+}
+
+func (s *Server) infoKeyspace() []string {
+	cmd.DB.RLock()
+	keys := cmd.DB.Len()
+	expires := cmd.DB.VolatileLen()
+	cmd.DB.RUnlock()
+
+	if keys == 0 {
+		return nil
+	}
+	return []string{
+		fmt.Sprintf("db0:keys=%d,expires=%d", keys, expires),
+	}
+}
+
+// portOf returns the port part of a listen address such as ":6379".
+func portOf(addr string) string {
+	if i := strings.LastIndex(addr, ":"); i >= 0 {
+		return addr[i+1:]
+	}
+	return addr
+}
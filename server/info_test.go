@@ -0,0 +1,76 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// infoField returns the value of a field of the INFO reply.
+func infoField(c *testClient, section, field string) string {
+	c.t.Helper()
+	for _, line := range strings.Split(c.do("INFO", section).Bulk, "\r\n") {
+		if value, ok := strings.CutPrefix(line, field+":"); ok {
+			return value
+		}
+	}
+	c.t.Errorf("INFO %s has no %s field", section, field)
+	return ""
+}
+
+func TestInfoSections(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+
+	tests := []struct {
+		args []string
+		want []string // Section headers, in order
+	}{
+		{nil, []string{"# Server", "# Clients", "# Persistence", "# Stats", "# Replication", "# Keyspace"}},
+		{[]string{"all"}, []string{"# Server", "# Clients", "# Persistence", "# Stats", "# Replication", "# Keyspace"}},
+		{[]string{"stats"}, []string{"# Stats"}},
+		{[]string{"KEYSPACE", "server"}, []string{"# Server", "# Keyspace"}},
+		{[]string{"nosuchsection"}, nil},
+	}
+	for _, tt := range tests {
+		var headers []string
+		for _, line := range strings.Split(c.do(append([]string{"INFO"}, tt.args...)...).Bulk, "\r\n") {
+			if strings.HasPrefix(line, "# ") {
+				headers = append(headers, line)
+			}
+		}
+		if strings.Join(headers, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("INFO %q has sections %q, want %q", tt.args, headers, tt.want)
+		}
+	}
+}
+
+func TestActiveExpiry(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	before, _ := strconv.Atoi(infoField(c, "stats", "expired_keys"))
+
+	for i := 0; i < 10; i++ {
+		c.do("SET", t.Name()+strconv.Itoa(i), "v", "PX", "10")
+	}
+	// Nothing reads the keys again: the cron has to find them by itself
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		after, _ := strconv.Atoi(infoField(c, "stats", "expired_keys"))
+		if after-before >= 10 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expired_keys went from %d to %d, want 10 more", before, after)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if got := infoField(c, "stats", "total_commands_processed"); got == "" || got == "0" {
+		t.Errorf("total_commands_processed = %q", got)
+	}
+	if got := infoField(c, "clients", "connected_clients"); got != "1" {
+		t.Errorf("connected_clients = %q, want 1", got)
+	}
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/IAmRiteshKoushik/bluedis/aof"
+	"github.com/IAmRiteshKoushik/bluedis/cmd"
+	"github.com/IAmRiteshKoushik/bluedis/rdb"
+	"github.com/IAmRiteshKoushik/bluedis/resp"
+)
+
+// LoadData rebuilds the dataset at startup. It has to be called before
+// ListenAndServe.
+//
+// The AOF records every write, so whenever it has anything in it, it is the
+// most complete copy of the data and the snapshot is ignored. Otherwise the
+// snapshot is loaded, if there is one, and immediately written out as the
+// base of the AOF: the writes that follow get appended on top of it, and
+// without it the next restart would lose the snapshot's contents.
+func (s *Server) LoadData() error {
+	if s.aof.RewriteInfo().CurrentSize > 0 {
+		if err := s.loadAppendOnlyFile(); err != nil {
+			return err
+		}
+	} else if err := s.loadSnapshot(); err != nil {
+		return err
+	}
+
+	// Loading is not a change that needs saving
+	s.saveMu.Lock()
+	s.lastSaveDirty = cmd.DB.Dirty()
+	s.saveMu.Unlock()
+	return nil
+}
+
+// loadSnapshot loads the RDB file when there is one and seeds the AOF with it.
+func (s *Server) loadSnapshot() error {
+	start := time.Now()
+	path := s.cfg.Snapshot().DBFilename
+
+	cmd.DB.Lock()
+	loaded, err := rdb.LoadFile(path, cmd.DB)
+	cmd.DB.Unlock()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("loading %s: %w", path, err)
+	}
+	fmt.Printf("DB loaded from disk: %.3f seconds, %d keys loaded\n", time.Since(start).Seconds(), loaded)
+
+	if loaded == 0 {
+		return nil
+	}
+	if err := s.aof.BeginRewrite(); err != nil {
+		return err
+	}
+	cmd.DB.RLock()
+	defer cmd.DB.RUnlock()
+	return s.finishRewrite(cmd.DB)
+}
+
+// loadAppendOnlyFile rebuilds the dataset from the AOF: its base, which may be
+// a snapshot, then every command logged since. Every command runs exactly once
+// through the same handlers that serve clients, so replay can never drift from
+// what the commands did the first time around. Nothing is written back to the
+// AOF while loading.
+func (s *Server) loadAppendOnlyFile() error {
+	s.loading.Store(true)
+	defer s.loading.Store(false)
+
+	start := time.Now()
+	keys, loaded, skipped := 0, 0, 0
+
+	loadSnapshot := func(r io.Reader) error {
+		cmd.DB.Lock()
+		defer cmd.DB.Unlock()
+		n, err := rdb.Load(r, cmd.DB)
+		keys += n
+		return err
+	}
+
+	replay := func(value resp.Value) {
+		command := strings.ToUpper(value.Array[0].Bulk)
+		handler, _, ok := cmd.Lookup(command)
+		if !ok {
+			fmt.Printf("Skipping unknown command '%s' in the AOF\n", command)
+			skipped++
+			return
+		}
+
+		// Blocking commands are logged as their non-blocking equivalent, and
+		// their handlers never wait anyway, so everything replays in one go
+		result := handler(value.Array[1:])
+		if result.Typ == "error" {
+			fmt.Printf("Error replaying '%s' from the AOF: %s\n", command, result.Str)
+		}
+		loaded++
+	}
+
+	// A transaction is held back until its EXEC. If the file ends before it,
+	// none of it is applied, and the AOF is cut back to its MULTI below.
+	var tx []resp.Value
+	inTx := false
+	err := s.aof.Load(loadSnapshot, func(value resp.Value) {
+		if value.Typ != "array" || len(value.Array) == 0 {
+			fmt.Println("Skipping invalid entry in the AOF")
+			skipped++
+			return
+		}
+
+		switch command := strings.ToUpper(value.Array[0].Bulk); {
+		case command == "MULTI":
+			inTx = true
+		case command == "EXEC":
+			for _, queued := range tx {
+				replay(queued)
+			}
+			tx, inTx = nil, false
+		case inTx:
+			tx = append(tx, value)
+		default:
+			replay(value)
+		}
+	})
+
+	// A crash in the middle of a write leaves a partial command at the end
+	// of the file. Everything before it is intact, so with aof-load-truncated
+	// the tail is dropped and the server starts with the data up to there.
+	// Damage anywhere else, including the end of any file but the last, means
+	// the AOF cannot be trusted.
+	var corrupt *aof.CorruptError
+	if errors.As(err, &corrupt) {
+		if !corrupt.Truncated || !s.cfg.Snapshot().AofLoadTruncated {
+			return fmt.Errorf("the AOF is damaged (%v). Make a backup of it, then use bluedis-check-aof --fix <manifest> to repair it", corrupt)
+		}
+		fmt.Printf("!!! Warning: short read while loading the AOF (%v) !!!\n", corrupt)
+		fmt.Printf("!!! Truncating the AOF at offset %d !!!\n", corrupt.Offset)
+		if err := s.aof.Truncate(corrupt.File, corrupt.Offset); err != nil {
+			return fmt.Errorf("truncating the AOF: %w", err)
+		}
+	} else if err != nil {
+		return err
+	}
+
+	fmt.Printf("DB loaded from append only file: %.3f seconds, %d commands loaded", time.Since(start).Seconds(), loaded)
+	if keys > 0 {
+		fmt.Printf(", %d keys from the base snapshot", keys)
+	}
+	if skipped > 0 {
+		fmt.Printf(", %d skipped", skipped)
+	}
+	fmt.Println()
+	return nil
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IAmRiteshKoushik/bluedis/aof"
+	"github.com/IAmRiteshKoushik/bluedis/cmd"
+	"github.com/IAmRiteshKoushik/bluedis/resp"
+	"github.com/IAmRiteshKoushik/bluedis/store"
+)
+
+// writeAof creates an AOF in dir holding the given command lines.
+func writeAof(t *testing.T, dir string, lines ...string) {
+	t.Helper()
+	f, err := aof.NewAof(filepath.Join(dir, "appendonlydir"), "database.aof")
+	if err != nil {
+		t.Fatalf("NewAof: %v", err)
+	}
+	defer f.Close()
+	for _, line := range lines {
+		value := resp.Value{Typ: "array"}
+		for _, field := range strings.Fields(line) {
+			value.Array = append(value.Array, resp.Value{Typ: "bulk", Bulk: field})
+		}
+		if err := f.Write(value); err != nil {
+			t.Fatalf("writing %s: %v", line, err)
+		}
+	}
+}
+
+func TestLoadAppendOnlyFile(t *testing.T) {
+	dir := t.TempDir()
+	k := t.Name() + ":"
+	writeAof(t, dir,
+		"SET "+k+"string v",
+		"SET "+k+"volatile v EX 100",
+		"SET "+k+"deleted v",
+		"DEL "+k+"deleted",
+		"RPUSH "+k+"list a b c d",
+		"LPOP "+k+"list",
+		"RPOP "+k+"list 2",
+		"HSET "+k+"hash f v",
+		"ZADD "+k+"zset 1 a",
+		"SETBIT "+k+"bitmap 7 1",
+		"BF.ADD "+k+"bloom a",
+		"EXPIRE "+k+"hash 100",
+		"PERSIST "+k+"hash",
+		"NOSUCHCOMMAND "+k+"string",
+		"LPUSH "+k+"string wrongtype",
+	)
+
+	s := newServer(t, dir)
+	if err := s.LoadData(); err != nil {
+		t.Fatalf("LoadData: %v", err)
+	}
+	size := s.aof.RewriteInfo().CurrentSize
+	c := dial(t, serve(t, s))
+	defer c.do("DEL", k+"string", k+"volatile", k+"list", k+"hash", k+"zset", k+"bitmap", k+"bloom")
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"GET", k + "string"}, "v"},
+		{[]string{"GET", k + "volatile"}, "v"},
+		{[]string{"EXISTS", k + "deleted"}, "0"},
+		{[]string{"LRANGE", k + "list", "0", "-1"}, "b"},
+		{[]string{"HGET", k + "hash", "f"}, "v"},
+		{[]string{"TTL", k + "hash"}, "-1"},
+		{[]string{"TYPE", k + "zset"}, "zset"},
+		{[]string{"GETBIT", k + "bitmap", "7"}, "1"},
+		{[]string{"BF.EXISTS", k + "bloom", "a"}, "1"},
+	}
+	for _, tt := range tests {
+		if got := flatten(c.do(tt.args...)); got != tt.want {
+			t.Errorf("%q after loading = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+	if ttl := c.do("TTL", k+"volatile").Num; ttl <= 0 || ttl > 100 {
+		t.Errorf("TTL after loading SET EX 100 = %d", ttl)
+	}
+	// Replaying writes nothing back
+	if got := s.aof.RewriteInfo().CurrentSize; got != size {
+		t.Errorf("AOF grew from %d to %d bytes while loading", size, got)
+	}
+}
+
+// lastIncr returns the path of the incr file the AOF in dir appends to.
+func lastIncr(t *testing.T, dir string) string {
+	t.Helper()
+	dir = filepath.Join(dir, "appendonlydir")
+	m, err := aof.ReadManifest(aof.ManifestPath(dir, "database.aof"))
+	if err != nil || len(m.Incrs) == 0 {
+		t.Fatalf("reading the manifest: %v", err)
+	}
+	return filepath.Join(dir, m.Incrs[len(m.Incrs)-1].Name)
+}
+
+// flatten renders a reply as a string, with array elements separated by
+// spaces.
+func flatten(v resp.Value) string {
+	switch v.Typ {
+	case "integer":
+		return strconv.Itoa(v.Num)
+	case "string", "error":
+		return v.Str
+	case "array":
+		parts := make([]string, len(v.Array))
+		for i, item := range v.Array {
+			parts[i] = flatten(item)
+		}
+		return strings.Join(parts, " ")
+	case "null":
+		return "(nil)"
+	}
+	return v.Bulk
+}
+
+func TestBlpopLoggedAsLpop(t *testing.T) {
+	s, addr := startServer(t)
+	key := t.Name()
+	blocked, pusher := dial(t, addr), dial(t, addr)
+
+	blocked.send("BLPOP", key, "5")
+	time.Sleep(50 * time.Millisecond)
+	pusher.do("RPUSH", key, "a", "b")
+	if got := flatten(blocked.read()); got != key+" a" {
+		t.Fatalf("BLPOP replied %q", got)
+	}
+	pusher.do("DEL", key)
+
+	if got, want := strings.Join(logged(s), ","), "RPUSH "+key+" a b,LPOP "+key+",DEL "+key; got != want {
+		t.Errorf("AOF = %q, want %q", got, want)
+	}
+}
+
+func TestLoadDamagedAppendOnlyFile(t *testing.T) {
+	tests := []struct {
+		name          string
+		tail          string // Appended to the file after a valid SET
+		loadTruncated string
+		ok            bool
+	}{
+		{"truncated", "*3\r\n$3\r\nSET\r\n$1", "yes", true},
+		{"truncated, not allowed", "*3\r\n$3\r\nSET\r\n$1", "no", false},
+		{"transaction without EXEC", "*1\r\n$5\r\nMULTI\r\n*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n", "yes", true},
+		{"garbage", "+OK\r\n*1\r\n$4\r\nPING\r\n", "yes", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			key := t.Name()
+			writeAof(t, dir, "SET "+key+" v")
+			f, _ := os.OpenFile(lastIncr(t, dir), os.O_APPEND|os.O_WRONLY, 0)
+			f.WriteString(tt.tail)
+			f.Close()
+			defer func() {
+				cmd.DB.Lock()
+				cmd.DB.Remove(key)
+				cmd.DB.Unlock()
+			}()
+
+			s := newServer(t, dir)
+			s.cfg.Set("aof-load-truncated", tt.loadTruncated)
+			err := s.LoadData()
+			if (err == nil) != tt.ok {
+				t.Fatalf("LoadData = %v, want ok %v", err, tt.ok)
+			}
+			if !tt.ok {
+				return
+			}
+			// The partial command or transaction is gone from the file, so
+			// new writes follow straight after the last complete one
+			c := dial(t, serve(t, s))
+			if reply := c.do("GET", key); reply.Bulk != "v" {
+				t.Errorf("GET after loading = %+v", reply)
+			}
+			if reply := c.do("EXISTS", "k"); reply.Num != 0 {
+				t.Errorf("the transaction without EXEC was applied")
+			}
+			c.do("SET", key, "w")
+			if got := logged(s); strings.Join(got, ",") != "SET "+key+" v,SET "+key+" w" {
+				t.Errorf("AOF after truncating = %q", got)
+			}
+		})
+	}
+}
+
+func TestLoadSnapshotBase(t *testing.T) {
+	dir := t.TempDir()
+	k := t.Name() + ":"
+	defer func() {
+		cmd.DB.Lock()
+		cmd.DB.Remove(k + "base")
+		cmd.DB.Remove(k + "incr")
+		cmd.DB.Unlock()
+	}()
+
+	// An AOF whose base is a snapshot, with a command logged after it
+	first := newServer(t, dir)
+	ks := store.NewKeyspace()
+	ks.Put(k+"base", store.TypeString, "from the base")
+	if err := first.aof.BeginRewrite(); err != nil {
+		t.Fatalf("BeginRewrite: %v", err)
+	}
+	if err := first.finishRewrite(ks); err != nil {
+		t.Fatalf("finishRewrite: %v", err)
+	}
+	first.aof.Write(resp.Value{Typ: "array", Array: []resp.Value{
+		{Typ: "bulk", Bulk: "SET"}, {Typ: "bulk", Bulk: k + "incr"}, {Typ: "bulk", Bulk: "from the incr file"},
+	}})
+	first.aof.Close()
+
+	s := newServer(t, dir)
+	if err := s.LoadData(); err != nil {
+		t.Fatalf("LoadData: %v", err)
+	}
+	c := dial(t, serve(t, s))
+	for key, want := range map[string]string{k + "base": "from the base", k + "incr": "from the incr file"} {
+		if reply := c.do("GET", key); reply.Bulk != want {
+			t.Errorf("GET %s after loading = %+v, want %q", key, reply, want)
+		}
+	}
+}
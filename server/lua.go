@@ -0,0 +1,232 @@
+package server
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/IAmRiteshKoushik/bluedis/resp"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Name scripts are compiled under, which shows up in their error messages
+const luaChunkName = "user_script"
+
+// newLuaState returns an interpreter for running a script. Only the libraries
+// that cannot reach outside of the interpreter are loaded, along with the
+// redis library, whose call and pcall run commands through call, or fail if
+// it is nil. It stops with an error as soon as ctx is cancelled.
+func newLuaState(ctx context.Context, call func(args []resp.Value) resp.Value) *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	L.SetContext(ctx)
+
+	redis := L.NewTable()
+	L.SetFuncs(redis, map[string]lua.LGFunction{
+		"call":         func(L *lua.LState) int { return luaCall(L, call, true) },
+		"pcall":        func(L *lua.LState) int { return luaCall(L, call, false) },
+		"error_reply":  luaErrorReply,
+		"status_reply": luaStatusReply,
+		"sha1hex":      luaSha1hex,
+		"log":          luaLog,
+	})
+	for i, level := range []string{"LOG_DEBUG", "LOG_VERBOSE", "LOG_NOTICE", "LOG_WARNING"} {
+		L.SetField(redis, level, lua.LNumber(i))
+	}
+	L.SetGlobal("redis", redis)
+	return L
+}
+
+// luaCall implements redis.call and redis.pcall. When the command fails,
+// redis.call raises the error while redis.pcall returns it as an error table.
+func luaCall(L *lua.LState, call func(args []resp.Value) resp.Value, raise bool) int {
+	if call == nil {
+		L.RaiseError("Redis commands cannot be called from here")
+	}
+	if L.GetTop() == 0 {
+		L.RaiseError("Please specify at least one argument for this redis lib call")
+	}
+
+	args := make([]resp.Value, 0, L.GetTop())
+	for i := 1; i <= L.GetTop(); i++ {
+		switch arg := L.Get(i).(type) {
+		case lua.LString:
+			args = append(args, resp.Value{Typ: "bulk", Bulk: string(arg)})
+		case lua.LNumber:
+			args = append(args, resp.Value{Typ: "bulk", Bulk: arg.String()})
+		default:
+			L.RaiseError("Lua redis lib command arguments must be strings or integers")
+		}
+	}
+
+	result := call(args)
+	if result.Typ == "error" && raise {
+		L.Error(respToLua(L, result), 1)
+	}
+	L.Push(respToLua(L, result))
+	return 1
+}
+
+func luaErrorReply(L *lua.LState) int {
+	reply := L.NewTable()
+	reply.RawSetString("err", lua.LString(L.CheckString(1)))
+	L.Push(reply)
+	return 1
+}
+
+func luaStatusReply(L *lua.LState) int {
+	reply := L.NewTable()
+	reply.RawSetString("ok", lua.LString(L.CheckString(1)))
+	L.Push(reply)
+	return 1
+}
+
+func luaSha1hex(L *lua.LState) int {
+	L.Push(lua.LString(sha1hex(L.CheckString(1))))
+	return 1
+}
+
+func luaLog(L *lua.LState) int {
+	L.CheckInt(1)
+	parts := make([]string, 0, L.GetTop()-1)
+	for i := 2; i <= L.GetTop(); i++ {
+		parts = append(parts, L.Get(i).String())
+	}
+	fmt.Println("Script log:", strings.Join(parts, " "))
+	return 0
+}
+
+// sha1hex returns the name a script is cached under.
+func sha1hex(body string) string {
+	sum := sha1.Sum([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+// luaStrings returns a Lua array holding the given arguments, for KEYS and
+// ARGV.
+func luaStrings(L *lua.LState, args []resp.Value) *lua.LTable {
+	table := L.CreateTable(len(args), 0)
+	for _, arg := range args {
+		table.Append(lua.LString(arg.Bulk))
+	}
+	return table
+}
+
+// respToLua converts the reply of a command into a Lua value, the same way
+// Redis does: nulls become false, and status and error replies tables with
+// an ok or an err field.
+func respToLua(L *lua.LState, value resp.Value) lua.LValue {
+	switch value.Typ {
+	case "integer":
+		return lua.LNumber(value.Num)
+	case "bulk":
+		return lua.LString(value.Bulk)
+	case "string":
+		table := L.NewTable()
+		table.RawSetString("ok", lua.LString(value.Str))
+		return table
+	case "error":
+		table := L.NewTable()
+		table.RawSetString("err", lua.LString(value.Str))
+		return table
+	case "array":
+		table := L.CreateTable(len(value.Array), 0)
+		for _, element := range value.Array {
+			table.Append(respToLua(L, element))
+		}
+		return table
+	default:
+		return lua.LFalse
+	}
+}
+
+// luaToResp converts the value returned by a script into a reply. Numbers are
+// truncated to integers, true becomes 1 and false null, and an array ends at
+// its first nil.
+func luaToResp(value lua.LValue) resp.Value {
+	switch value := value.(type) {
+	case lua.LString:
+		return resp.Value{Typ: "bulk", Bulk: string(value)}
+	case lua.LNumber:
+		return resp.Value{Typ: "integer", Num: int(value)}
+	case lua.LBool:
+		if value {
+			return resp.Value{Typ: "integer", Num: 1}
+		}
+		return resp.Value{Typ: "null"}
+	case *lua.LTable:
+		if msg, ok := value.RawGetString("err").(lua.LString); ok {
+			return errorReply(string(msg))
+		}
+		if msg, ok := value.RawGetString("ok").(lua.LString); ok {
+			return resp.Value{Typ: "string", Str: string(msg)}
+		}
+		reply := resp.Value{Typ: "array", Array: []resp.Value{}}
+		for i := 1; ; i++ {
+			element := value.RawGetInt(i)
+			if element == lua.LNil {
+				break
+			}
+			reply.Array = append(reply.Array, luaToResp(element))
+		}
+		return reply
+	default:
+		return resp.Value{Typ: "null"}
+	}
+}
+
+// luaError turns the error a script failed with into a reply. An error reply
+// raised by redis.call or returned by redis.error_reply is passed on as is.
+func luaError(err error, where string) resp.Value {
+	if apiErr, ok := err.(*lua.ApiError); ok {
+		if table, ok := apiErr.Object.(*lua.LTable); ok {
+			if msg, ok := table.RawGetString("err").(lua.LString); ok {
+				return errorReply(string(msg))
+			}
+		}
+		return errorReply(fmt.Sprintf("ERR Error running script (%s): %s", where, apiErr.Object.String()))
+	}
+	return errorReply(fmt.Sprintf("ERR Error running script (%s): %v", where, err))
+}
+
+// errorReply returns an error reply made of msg, which has to fit on a single
+// line: Lua error messages, for one, can span several.
+func errorReply(msg string) resp.Value {
+	msg = strings.TrimSpace(strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ").Replace(msg))
+	return resp.Value{Typ: "error", Str: msg}
+}
+
+// parseNumKeys checks the numkeys argument of EVAL and friends against the
+// number of arguments that follow it. The error is meant to be sent to the
+// client as is.
+func parseNumKeys(arg string, rest int) (int, error) {
+	numkeys, err := strconv.Atoi(arg)
+	switch {
+	case err != nil:
+		return 0, errors.New("ERR value is not an integer or out of range")
+	case numkeys < 0:
+		return 0, errors.New("ERR Number of keys can't be negative")
+	case numkeys > rest:
+		return 0, errors.New("ERR Number of keys can't be greater than number of args")
+	}
+	return numkeys, nil
+}
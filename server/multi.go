@@ -0,0 +1,169 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/IAmRiteshKoushik/bluedis/cmd"
+	"github.com/IAmRiteshKoushik/bluedis/resp"
+)
+
+// multi implements MULTI. Until EXEC or DISCARD, the commands of the client
+// are checked and queued rather than run.
+func (c *Client) multi(args []resp.Value) (resp.Value, bool) {
+	if c.inMulti {
+		return resp.Value{Typ: "error", Str: "ERR MULTI calls can not be nested"}, true
+	}
+	c.inMulti = true
+	return resp.Value{Typ: "string", Str: "OK"}, true
+}
+
+// discard implements DISCARD, which drops the queued commands.
+func (c *Client) discard(args []resp.Value) (resp.Value, bool) {
+	if !c.inMulti {
+		return resp.Value{Typ: "error", Str: "ERR DISCARD without MULTI"}, true
+	}
+	c.resetMulti()
+	c.unwatch()
+	return resp.Value{Typ: "string", Str: "OK"}, true
+}
+
+// queuedCommand is a command waiting for EXEC, with the function to run it
+// with when it is a client command.
+type queuedCommand struct {
+	value resp.Value
+	run   func(c *Client, args []resp.Value) (resp.Value, bool)
+}
+
+func (c *Client) resetMulti() {
+	c.inMulti = false
+	c.multiAborted = false
+	c.queued = nil
+}
+
+// queue checks a command sent between MULTI and EXEC and queues it. Anything
+// that is bound to fail no matter the data is reported right away and makes
+// EXEC abort the whole transaction, as dispatch does for unknown commands and
+// the wrong number of arguments. That includes the commands flagged no-multi,
+// such as SUBSCRIBE, which cannot run while EXEC holds the exec lock. Blocking
+// commands are fine: inside EXEC they never block, and reply right away with
+// whatever there is, as they do in Redis.
+func (c *Client) queue(command string, value resp.Value) resp.Value {
+	fail := func(msg string) resp.Value {
+		c.multiAborted = true
+		return resp.Value{Typ: "error", Str: msg}
+	}
+
+	_, spec, _ := cmd.Lookup(command)
+	clientCommand, isClientCommand := clientCommands[command]
+	if isClientCommand {
+		spec = clientCommand.spec
+	}
+	if spec.Flags&cmd.FlagNoMulti != 0 {
+		return fail("ERR Command not allowed inside a transaction")
+	}
+	// Client commands that write, such as FUNCTION LOAD, check for
+	// themselves once they run
+	if spec.IsWrite() && !isClientCommand && c.server.readOnlyReplica() {
+		return fail("READONLY You can't write against a read only replica.")
+	}
+
+	c.queued = append(c.queued, queuedCommand{value: value, run: clientCommand.run})
+	return resp.Value{Typ: "string", Str: "QUEUED"}
+}
+
+// exec implements EXEC. The queued commands run back to back while the exec
+// lock is held exclusively, so no other client sees the keyspace half way
+// through, and a command that fails does not stop the ones after it. The
+// changes are propagated between MULTI and EXEC, which the AOF loader and
+// replicas apply as one unit or not at all. Nothing runs at all, and the reply
+// is null, if a key watched by the client changed since WATCH. Client commands
+// such as EVAL run on the connection as usual, with execUnit telling them to
+// add to the transaction rather than take the exec lock themselves.
+func (c *Client) exec(args []resp.Value) (resp.Value, bool) {
+	if !c.inMulti {
+		return resp.Value{Typ: "error", Str: "ERR EXEC without MULTI"}, true
+	}
+	queued, aborted := c.queued, c.multiAborted
+	c.resetMulti()
+	if aborted {
+		c.unwatch()
+		return resp.Value{Typ: "error", Str: "EXECABORT Transaction discarded because of previous errors."}, true
+	}
+
+	s := c.server
+	s.execMu.Lock()
+	defer s.execMu.Unlock()
+
+	changed := c.watchedKeysChanged()
+	c.unwatch()
+	if changed {
+		return resp.Value{Typ: "null"}, true
+	}
+
+	unit := &atomicUnit{server: s}
+	c.execUnit = unit
+	results := make([]resp.Value, 0, len(queued))
+	for _, entry := range queued {
+		args := entry.value.Array[1:]
+		if entry.run != nil {
+			result, _ := entry.run(c, args)
+			results = append(results, result)
+			continue
+		}
+		name := strings.ToUpper(entry.value.Array[0].Bulk)
+		results = append(results, unit.call(name, args))
+	}
+	c.execUnit = nil
+	offset, wrote, err := unit.finish()
+	if err != nil {
+		return aofError(err), true
+	}
+	if wrote {
+		c.writeOffset = offset
+	}
+
+	return resp.Value{Typ: "array", Array: results}, true
+}
+
+// atomicUnit runs the commands of a transaction or a script, whose caller
+// holds the exec lock exclusively, and propagates the changes they make
+// between a MULTI and an EXEC. MULTI goes out along with the first change, so
+// a unit that changes nothing leaves no trace. Once a change fails to reach
+// the AOF, nothing more of the unit is propagated, EXEC included.
+type atomicUnit struct {
+	server *Server
+	wrote  bool
+	err    error
+}
+
+// call runs a single command of the unit.
+func (u *atomicUnit) call(name string, args []resp.Value) resp.Value {
+	handler, spec, _ := cmd.Lookup(name)
+	dirty := cmd.DB.Dirty()
+	result := handler(args)
+
+	if spec.IsWrite() && cmd.DB.Dirty() != dirty && u.err == nil {
+		if !u.wrote {
+			_, u.err = u.server.propagate(command("MULTI"))
+			u.wrote = true
+		}
+		if u.err == nil {
+			_, u.err = u.server.propagate(effectOf(name, args, result))
+		}
+	}
+	return result
+}
+
+// finish closes the unit. It returns the replication offset right after it,
+// whether it changed anything at all, and the error that kept its changes
+// from being persisted, if any.
+func (u *atomicUnit) finish() (int64, bool, error) {
+	if u.err != nil {
+		return 0, true, u.err
+	}
+	if !u.wrote {
+		return 0, false, nil
+	}
+	offset, err := u.server.propagate(command("EXEC"))
+	return offset, true, err
+}
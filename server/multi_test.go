@@ -0,0 +1,202 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/IAmRiteshKoushik/bluedis/cmd"
+	"github.com/IAmRiteshKoushik/bluedis/resp"
+)
+
+func TestMultiExec(t *testing.T) {
+	s, addr := startServer(t)
+	c := dial(t, addr)
+	str, list := t.Name()+":string", t.Name()+":list"
+	defer c.do("DEL", str, list)
+
+	before := len(logged(s))
+	if reply := c.do("MULTI"); reply.Str != "OK" {
+		t.Fatalf("MULTI replied %+v", reply)
+	}
+	for _, args := range [][]string{
+		{"SET", str, "v"},
+		{"GET", str},
+		{"LPUSH", str, "a"}, // Fails on the data, which does not abort
+		{"RPUSH", list, "a", "b"},
+	} {
+		if reply := c.do(args...); reply.Str != "QUEUED" {
+			t.Errorf("%q inside MULTI replied %+v, want QUEUED", args, reply)
+		}
+	}
+
+	// Nothing runs before EXEC
+	if reply := dial(t, addr).do("EXISTS", str); reply.Num != 0 {
+		t.Errorf("a queued command ran before EXEC")
+	}
+
+	reply := c.do("EXEC")
+	if reply.Typ != "array" || len(reply.Array) != 4 {
+		t.Fatalf("EXEC replied %+v", reply)
+	}
+	if reply.Array[1].Bulk != "v" || !strings.HasPrefix(reply.Array[2].Str, "WRONGTYPE") || reply.Array[3].Num != 2 {
+		t.Errorf("EXEC replied %q", flatten(reply))
+	}
+
+	// The writes are logged as one unit
+	want := []string{"MULTI", "SET " + str + " v", "RPUSH " + list + " a b", "EXEC"}
+	if got := logged(s)[before:]; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("EXEC logged %q, want %q", got, want)
+	}
+
+	// A transaction that only reads logs nothing
+	before = len(logged(s))
+	c.do("MULTI")
+	c.do("GET", str)
+	c.do("EXEC")
+	if got := logged(s)[before:]; len(got) != 0 {
+		t.Errorf("read-only EXEC logged %q", got)
+	}
+}
+
+func TestBlockingInMulti(t *testing.T) {
+	s, addr := startServer(t)
+	c := dial(t, addr)
+	key := t.Name()
+	defer c.do("DEL", key)
+
+	before := len(logged(s))
+	c.do("MULTI")
+	for _, args := range [][]string{
+		{"RPUSH", key, "a"},
+		{"BLPOP", key, "0"},
+		{"BLPOP", key, "0"}, // Empty by now, which does not block
+	} {
+		if reply := c.do(args...); reply.Str != "QUEUED" {
+			t.Errorf("%q inside MULTI replied %+v, want QUEUED", args, reply)
+		}
+	}
+	if got := flatten(c.do("EXEC")); got != "1 "+key+" a (nil)" {
+		t.Errorf("EXEC replied %q", got)
+	}
+
+	want := []string{"MULTI", "RPUSH " + key + " a", "LPOP " + key, "EXEC"}
+	if got := logged(s)[before:]; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("EXEC logged %q, want %q", got, want)
+	}
+}
+
+func TestScriptInMulti(t *testing.T) {
+	s, addr := startServer(t)
+	c := dial(t, addr)
+	a, b := t.Name()+":a", t.Name()+":b"
+	defer c.do("DEL", a, b)
+	body := "return redis.call('RPUSH', KEYS[1], 'x')"
+
+	before := len(logged(s))
+	c.do("MULTI")
+	for _, args := range [][]string{
+		{"RPUSH", a, "1"},
+		{"SCRIPT", "LOAD", body},
+		{"EVALSHA", sha1hex(body), "1", a},
+		{"EVAL", "return redis.call('SET', KEYS[1], ARGV[1])", "1", b, "v"},
+	} {
+		if reply := c.do(args...); reply.Str != "QUEUED" {
+			t.Errorf("%q inside MULTI replied %+v, want QUEUED", args, reply)
+		}
+	}
+	if got := flatten(c.do("EXEC")); got != "1 "+sha1hex(body)+" 2 OK" {
+		t.Errorf("EXEC replied %q", got)
+	}
+
+	// The changes of the scripts are part of the transaction
+	want := []string{"MULTI", "RPUSH " + a + " 1", "RPUSH " + a + " x", "SET " + b + " v", "EXEC"}
+	if got := logged(s)[before:]; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("EXEC logged %q, want %q", got, want)
+	}
+}
+
+func TestDiscard(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	key := t.Name()
+
+	c.do("MULTI")
+	c.do("SET", key, "v")
+	if reply := c.do("DISCARD"); reply.Str != "OK" {
+		t.Errorf("DISCARD replied %+v", reply)
+	}
+	if reply := c.do("EXISTS", key); reply.Num != 0 {
+		t.Errorf("a discarded command ran")
+	}
+	for _, command := range []string{"EXEC", "DISCARD"} {
+		if reply := c.do(command); reply.Typ != "error" {
+			t.Errorf("%s without MULTI replied %+v, want an error", command, reply)
+		}
+	}
+}
+
+func TestExecAbort(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	key := t.Name()
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"unknown command", []string{"NOSUCHCOMMAND", key}},
+		{"wrong number of arguments", []string{"GET", key, "extra"}},
+		{"command not allowed", []string{"WAIT", "0", "0"}},
+		{"subscribing", []string{"SUBSCRIBE", "channel"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.do("MULTI")
+			c.do("SET", key, "v")
+			if reply := c.do(tt.args...); reply.Typ != "error" {
+				t.Errorf("%q inside MULTI replied %+v, want an error", tt.args, reply)
+			}
+			if reply := c.do("EXEC"); reply.Typ != "error" || !strings.HasPrefix(reply.Str, "EXECABORT") {
+				t.Errorf("EXEC replied %+v, want EXECABORT", reply)
+			}
+			if reply := c.do("EXISTS", key); reply.Num != 0 {
+				t.Errorf("the aborted transaction ran")
+			}
+		})
+	}
+
+	c.do("MULTI")
+	if reply := c.do("MULTI"); reply.Typ != "error" {
+		t.Errorf("nested MULTI replied %+v, want an error", reply)
+	}
+	c.do("DISCARD")
+}
+
+func TestApplyTransactionFromPrimary(t *testing.T) {
+	s := newServer(t, t.TempDir())
+	s.primary = &primaryLink{state: replStateConnected, done: make(chan struct{})}
+	a, b := t.Name()+":a", t.Name()+":b"
+	defer func() {
+		cmd.DB.Remove(a)
+		cmd.DB.Remove(b)
+	}()
+
+	before := len(logged(s))
+	if _, err := s.applyFromPrimary([]resp.Value{
+		command("MULTI"),
+		command("SET", a, "1"),
+		command("SET", b, "2"),
+		command("EXEC"),
+	}); err != nil {
+		t.Fatalf("applyFromPrimary: %v", err)
+	}
+	want := []string{"MULTI", "SET " + a + " 1", "SET " + b + " 2", "EXEC"}
+	if got := logged(s)[before:]; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("applying a transaction logged %q, want %q", got, want)
+	}
+	for _, key := range []string{a, b} {
+		if _, ok := cmd.DB.Lookup(key); !ok {
+			t.Errorf("%s was not set by the transaction", key)
+		}
+	}
+}
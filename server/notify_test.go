@@ -0,0 +1,33 @@
+package server
+
+import "testing"
+
+func TestKeyspaceNotifications(t *testing.T) {
+	_, addr := startServer(t)
+	c, sub := dial(t, addr), dial(t, addr)
+	key := t.Name()
+	defer c.do("CONFIG", "SET", "notify-keyspace-events", "")
+
+	if reply := c.do("CONFIG", "SET", "notify-keyspace-events", "KEA"); reply.Str != "OK" {
+		t.Fatalf("CONFIG SET notify-keyspace-events replied %+v", reply)
+	}
+	if got := flatten(c.do("CONFIG", "GET", "notify-keyspace-events")); got != "notify-keyspace-events AKE" {
+		t.Errorf("CONFIG GET notify-keyspace-events = %q", got)
+	}
+	sub.send("PSUBSCRIBE", "__key*__:"+key+"*", "__keyevent@0__:expired")
+	expect(t, sub, "psubscribe __key*__:"+key+"* 1")
+	expect(t, sub, "psubscribe __keyevent@0__:expired 2")
+
+	c.do("SET", key, "v")
+	expect(t, sub, "pmessage __key*__:"+key+"* __keyspace@0__:"+key+" set")
+
+	// Expiring is reported by the server rather than by a command
+	c.do("PEXPIRE", key, "1")
+	expect(t, sub, "pmessage __key*__:"+key+"* __keyspace@0__:"+key+" expire")
+	expect(t, sub, "pmessage __key*__:"+key+"* __keyspace@0__:"+key+" expired")
+	expect(t, sub, "pmessage __keyevent@0__:expired __keyevent@0__:expired "+key)
+
+	if reply := c.do("CONFIG", "SET", "notify-keyspace-events", "KEX"); reply.Typ != "error" {
+		t.Errorf("CONFIG SET notify-keyspace-events KEX replied %+v, want an error", reply)
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Pub/sub clients whose output grows past this are not reading fast enough to
+// ever catch up and get disconnected, like client-output-buffer-limit does
+const pubsubOutputLimit = 32 * 1024 * 1024
+
+// outbox queues the output of a client and writes it from a goroutine of its
+// own, so that other clients pushing messages to it are never held up by a
+// slow reader. Once a client has one, all of its output has to go through it
+// to keep replies and pushed messages in order.
+type outbox struct {
+	client *Client
+
+	mu   sync.Mutex
+	buf  []byte
+	wake chan struct{}
+}
+
+func newOutbox(c *Client) *outbox {
+	o := &outbox{client: c, wake: make(chan struct{}, 1)}
+	go o.run()
+	return o
+}
+
+// send queues data for the client.
+func (o *outbox) send(data []byte) {
+	o.mu.Lock()
+	o.buf = append(o.buf, data...)
+	over := len(o.buf) > pubsubOutputLimit
+	if over {
+		o.buf = nil
+	}
+	o.mu.Unlock()
+
+	if over {
+		fmt.Printf("Client %d is too far behind reading its messages, disconnecting it\n", o.client.id)
+		o.client.close()
+		return
+	}
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run writes whatever gets queued until the connection is closed.
+func (o *outbox) run() {
+	for {
+		select {
+		case <-o.client.done:
+			return
+		case <-o.wake:
+		}
+
+		o.mu.Lock()
+		data := o.buf
+		o.buf = nil
+		o.mu.Unlock()
+
+		if _, err := o.client.conn.Write(data); err != nil {
+			fmt.Println(err)
+			o.client.close()
+			return
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/IAmRiteshKoushik/bluedis/cmd"
+	"github.com/IAmRiteshKoushik/bluedis/resp"
+)
+
+// effects turns commands whose outcome depends on more than their arguments
+// into commands that reproduce exactly what they did. Replaying a BLPOP, for
+// one, would depend on the timing of whatever pushed to the list and could
+// wait forever, so the pop it made is logged instead. TTLs are logged as the
+// absolute deadline the command ended up setting, so that replaying the AOF
+// does not restart them from the time of the replay. Each function gets the
+// arguments and the reply of a command that changed the dataset.
+var effects = map[string]func(args []resp.Value, result resp.Value) []resp.Value{
+	"BLPOP": func(args []resp.Value, result resp.Value) []resp.Value {
+		// The reply names the key that was popped from
+		return []resp.Value{
+			{Typ: "bulk", Bulk: "LPOP"},
+			{Typ: "bulk", Bulk: result.Array[0].Bulk},
+		}
+	},
+	"SET":       setEffect,
+	"EXPIRE":    expireEffect,
+	"PEXPIRE":   expireEffect,
+	"EXPIREAT":  expireEffect,
+	"PEXPIREAT": expireEffect,
+}
+
+// setEffect logs a SET with a TTL as SET key value PXAT deadline.
+func setEffect(args []resp.Value, result resp.Value) []resp.Value {
+	key := args[0]
+	expireAt, ok := lookupExpiry(key.Bulk)
+	if !ok {
+		// The deadline had already passed
+		return []resp.Value{{Typ: "bulk", Bulk: "DEL"}, key}
+	}
+
+	value := []resp.Value{{Typ: "bulk", Bulk: "SET"}, key, args[1]}
+	if !expireAt.IsZero() {
+		value = append(value,
+			resp.Value{Typ: "bulk", Bulk: "PXAT"},
+			resp.Value{Typ: "bulk", Bulk: strconv.FormatInt(expireAt.UnixMilli(), 10)},
+		)
+	}
+	return value
+}
+
+// expireEffect logs any command of the EXPIRE family as PEXPIREAT.
+func expireEffect(args []resp.Value, result resp.Value) []resp.Value {
+	key := args[0]
+	expireAt, ok := lookupExpiry(key.Bulk)
+	if !ok {
+		return []resp.Value{{Typ: "bulk", Bulk: "DEL"}, key}
+	}
+	return []resp.Value{
+		{Typ: "bulk", Bulk: "PEXPIREAT"},
+		key,
+		{Typ: "bulk", Bulk: strconv.FormatInt(expireAt.UnixMilli(), 10)},
+	}
+}
+
+// lookupExpiry returns the deadline of key, which is the zero time if the key
+// has no TTL. It reports false if the key does not exist.
+func lookupExpiry(key string) (time.Time, bool) {
+	cmd.DB.RLock()
+	defer cmd.DB.RUnlock()
+
+	entry, ok := cmd.DB.Lookup(key)
+	if !ok {
+		return time.Time{}, false
+	}
+	return entry.ExpireAt(), true
+}
+
+// effectOf returns the command to log for a write command that changed the
+// dataset: its effect-level form where it has one, the command itself
+// otherwise.
+func effectOf(command string, args []resp.Value, result resp.Value) resp.Value {
+	if effect, ok := effects[command]; ok {
+		return resp.Value{Typ: "array", Array: effect(args, result)}
+	}
+	return resp.Value{
+		Typ:   "array",
+		Array: append([]resp.Value{{Typ: "bulk", Bulk: command}}, args...),
+	}
+}
+
+// propagate appends a change to the AOF and sends it to the replicas. The
+// caller must hold the write lock, or the keyspace lock for expired keys,
+// which keeps both in the same order as the changes were made. It returns the
+// replication offset right after the change.
+//
+// A change that could not be written to the AOF, or synced under the always
+// policy, is not sent to the replicas, and the error is returned for the
+// client to get instead of the reply of its command.
+func (s *Server) propagate(value resp.Value) (int64, error) {
+	if err := s.aof.Write(value); err != nil {
+		fmt.Println("Error writing to the AOF:", err)
+		return 0, err
+	}
+
+	s.replMu.Lock()
+	defer s.replMu.Unlock()
+
+	// A replica passes on the stream it gets from its primary instead, see
+	// applyFromPrimary
+	if s.primary == nil {
+		s.feedReplicas(value)
+	}
+	return s.replOffset, nil
+}
+
+// aofError is the reply to a write that could not be persisted.
+func aofError(err error) resp.Value {
+	return resp.Value{Typ: "error", Str: "MISCONF Errors writing to the AOF file: " + err.Error()}
+}
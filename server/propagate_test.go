@@ -0,0 +1,182 @@
+package server
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IAmRiteshKoushik/bluedis/cmd"
+	"github.com/IAmRiteshKoushik/bluedis/rdb"
+	"github.com/IAmRiteshKoushik/bluedis/resp"
+	"github.com/IAmRiteshKoushik/bluedis/store"
+)
+
+// logged returns every command in the AOF of s as a line. A base file in
+// snapshot format shows up as the commands that rebuild what it holds.
+func logged(s *Server) []string {
+	var lines []string
+	add := func(value resp.Value) { lines = append(lines, flatten(value)) }
+	loadSnapshot := func(r io.Reader) error {
+		ks := store.NewKeyspace()
+		if _, err := rdb.Load(r, ks); err != nil {
+			return err
+		}
+		return cmd.RewriteKeyspace(ks, func(value resp.Value) error {
+			add(value)
+			return nil
+		})
+	}
+	if err := s.aof.Load(loadSnapshot, add); err != nil {
+		lines = append(lines, "error: "+err.Error())
+	}
+	return lines
+}
+
+func TestOnlyChangesAreLogged(t *testing.T) {
+	s, addr := startServer(t)
+	c := dial(t, addr)
+	k := t.Name() + ":"
+
+	for _, args := range [][]string{
+		{"SET", k + "s", "v"},
+		{"GET", k + "s"},
+		{"LPUSH", k + "s", "a"}, // WRONGTYPE
+		{"DEL", k + "missing"},
+		{"EXPIRE", k + "missing", "10"},
+		{"PERSIST", k + "s"},
+		{"LPOP", k + "missing"},
+		{"ZREM", k + "missing", "a"},
+		{"SET", k + "s"}, // Wrong number of arguments
+		{"RPUSH", k + "l", "a"},
+		{"EXPIRE", k + "l", "100"},
+	} {
+		c.do(args...)
+	}
+	// The TTL is logged as the deadline it works out to
+	deadline := c.do("PEXPIRETIME", k+"l").Num
+	c.do("DEL", k+"s", k+"l", k+"missing")
+
+	want := []string{
+		"SET " + k + "s v",
+		"RPUSH " + k + "l a",
+		"PEXPIREAT " + k + "l " + strconv.Itoa(deadline),
+		"DEL " + k + "s " + k + "l " + k + "missing",
+	}
+	if got := logged(s); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("AOF = %q, want %q", got, want)
+	}
+}
+
+func bulks(args ...string) []resp.Value {
+	values := make([]resp.Value, len(args))
+	for i, arg := range args {
+		values[i] = resp.Value{Typ: "bulk", Bulk: arg}
+	}
+	return values
+}
+
+func TestPropagateEffects(t *testing.T) {
+	deadline := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	pxat := strconv.FormatInt(deadline.UnixMilli(), 10)
+
+	cmd.DB.Lock()
+	cmd.DB.Put("plain", store.TypeString, "v")
+	cmd.DB.Put("volatile", store.TypeString, "v")
+	cmd.DB.SetExpire("volatile", deadline)
+	cmd.DB.Put("expired", store.TypeString, "v")
+	cmd.DB.SetExpire("expired", time.Now().Add(-time.Second))
+	cmd.DB.Unlock()
+	defer func() {
+		cmd.DB.Lock()
+		for _, key := range []string{"plain", "volatile", "expired"} {
+			cmd.DB.Remove(key)
+		}
+		cmd.DB.Unlock()
+	}()
+
+	tests := []struct {
+		name    string
+		command string
+		args    []resp.Value
+		result  resp.Value
+		want    string
+	}{
+		{
+			name: "logged as is", command: "HSET", args: bulks("h", "f", "v"),
+			want: "HSET h f v",
+		},
+		{
+			name: "SET without TTL", command: "SET", args: bulks("plain", "v"),
+			want: "SET plain v",
+		},
+		{
+			name: "SET with a relative TTL", command: "SET", args: bulks("volatile", "v", "EX", "3600"),
+			want: "SET volatile v PXAT " + pxat,
+		},
+		{
+			name: "SET already expired", command: "SET", args: bulks("expired", "v", "PX", "1"),
+			want: "DEL expired",
+		},
+		{
+			name: "EXPIRE", command: "EXPIRE", args: bulks("volatile", "3600"),
+			want: "PEXPIREAT volatile " + pxat,
+		},
+		{
+			name: "PEXPIRE", command: "PEXPIRE", args: bulks("volatile", "3600000"),
+			want: "PEXPIREAT volatile " + pxat,
+		},
+		{
+			name: "EXPIREAT in the past", command: "EXPIREAT", args: bulks("expired", "1"),
+			want: "DEL expired",
+		},
+		{
+			name: "BLPOP", command: "BLPOP", args: bulks("a", "b", "0"),
+			result: resp.Value{Typ: "array", Array: bulks("b", "item")},
+			want:   "LPOP b",
+		},
+	}
+	s := newServer(t, t.TempDir())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(logged(s))
+			if _, err := s.propagate(effectOf(tt.command, tt.args, tt.result)); err != nil {
+				t.Fatalf("propagate: %v", err)
+			}
+			got := logged(s)
+			if len(got) != before+1 || got[before] != tt.want {
+				t.Errorf("propagate logged %q, want %q", got[before:], tt.want)
+			}
+		})
+	}
+}
+
+// A write that cannot reach the AOF is reported to the client and kept from
+// the replicas
+func TestAofWriteError(t *testing.T) {
+	s, addr := startServer(t)
+	c := dial(t, addr)
+	key := t.Name()
+	defer cmd.DB.Remove(key)
+
+	s.aof.Close()
+	offset := s.replOffset
+	if reply := c.do("SET", key, "v"); !strings.HasPrefix(reply.Str, "MISCONF Errors writing to the AOF file") {
+		t.Errorf("SET replied %+v, want MISCONF", reply)
+	}
+	c.do("MULTI")
+	c.do("SET", key, "w")
+	if reply := c.do("EXEC"); !strings.HasPrefix(reply.Str, "MISCONF") {
+		t.Errorf("EXEC replied %+v, want MISCONF", reply)
+	}
+	if reply := c.do("EVAL", "return redis.call('SET', KEYS[1], 'x')", "1", key); !strings.HasPrefix(reply.Str, "MISCONF") {
+		t.Errorf("EVAL replied %+v, want MISCONF", reply)
+	}
+	if reply := c.do("GET", key); reply.Typ == "error" {
+		t.Errorf("GET replied %+v", reply)
+	}
+	if s.replOffset != offset {
+		t.Errorf("replication offset moved from %d to %d", offset, s.replOffset)
+	}
+}
@@ -0,0 +1,360 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/IAmRiteshKoushik/bluedis/resp"
+)
+
+// Commands a client can still send once it subscribed to something
+var subscriberCommands = map[string]bool{
+	"SUBSCRIBE":    true,
+	"UNSUBSCRIBE":  true,
+	"PSUBSCRIBE":   true,
+	"PUNSUBSCRIBE": true,
+	"SSUBSCRIBE":   true,
+	"SUNSUBSCRIBE": true,
+	"PING":         true,
+}
+
+// subscriptions returns how many channels, patterns and shard channels the
+// client is subscribed to. A client with any is in subscriber mode: messages
+// are pushed to it as they get published, and it can only manage its
+// subscriptions.
+func (c *Client) subscriptions() int {
+	return len(c.channels) + len(c.patterns) + len(c.shardChannels)
+}
+
+// subscriptionsOf returns the subscriptions of the client and of the server
+// that a (un)subscription of the given kind deals with. The caller holds
+// pubsubMu.
+func (c *Client) subscriptionsOf(kind string) (map[string]bool, map[string]map[*Client]struct{}) {
+	s := c.server
+	switch kind {
+	case "psubscribe", "punsubscribe":
+		return c.patterns, s.patterns
+	case "ssubscribe", "sunsubscribe":
+		return c.shardChannels, s.shardChannels
+	default:
+		return c.channels, s.channels
+	}
+}
+
+// subscriberPing implements PING in subscriber mode, which replies with an
+// array so that it cannot be mistaken for a message.
+func subscriberPing(args []resp.Value) resp.Value {
+	message := ""
+	if len(args) > 0 {
+		message = args[0].Bulk
+	}
+	return command("pong", message)
+}
+
+// pushSubscription queues the reply to a (un)subscription: kind, the channel
+// or pattern, or null if there was none, and the subscriptions left. Shard
+// channels are counted on their own.
+func (c *Client) pushSubscription(kind string, name *string) {
+	count := c.subscriptions()
+	if kind == "ssubscribe" || kind == "sunsubscribe" {
+		count = len(c.shardChannels)
+	}
+	value := resp.Value{Typ: "array", Array: []resp.Value{
+		{Typ: "bulk", Bulk: kind},
+		{Typ: "null"},
+		{Typ: "integer", Num: count},
+	}}
+	if name != nil {
+		value.Array[1] = resp.Value{Typ: "bulk", Bulk: *name}
+	}
+	c.out.send(value.Marshal())
+}
+
+// subscribe implements SUBSCRIBE, PSUBSCRIBE and SSUBSCRIBE, replying once per
+// channel or pattern. The replies are queued under pubsubMu, so a message
+// published to a channel can never overtake the confirmation of the
+// subscription.
+//
+// Shard channels are scoped to the hash slot of their name, like keys, so
+// that their traffic can stay on the node owning the slot once the keyspace
+// is partitioned. A single SSUBSCRIBE can therefore only name channels of the
+// same slot.
+func (c *Client) subscribe(kind string, args []resp.Value) (resp.Value, bool) {
+	if len(args) == 0 {
+		return resp.Value{Typ: "error", Str: fmt.Sprintf("ERR wrong number of arguments for '%s' command", kind)}, true
+	}
+	if kind == "ssubscribe" {
+		for _, arg := range args[1:] {
+			if keyHashSlot(arg.Bulk) != keyHashSlot(args[0].Bulk) {
+				return resp.Value{Typ: "error", Str: "CROSSSLOT Keys in request don't hash to the same slot"}, true
+			}
+		}
+	}
+	if c.out == nil {
+		c.out = newOutbox(c)
+	}
+
+	s := c.server
+	s.pubsubMu.Lock()
+	defer s.pubsubMu.Unlock()
+
+	mine, all := c.subscriptionsOf(kind)
+	for _, arg := range args {
+		name := arg.Bulk
+		if !mine[name] {
+			mine[name] = true
+			if all[name] == nil {
+				all[name] = make(map[*Client]struct{})
+			}
+			all[name][c] = struct{}{}
+		}
+		c.pushSubscription(kind, &name)
+	}
+	return resp.Value{}, false
+}
+
+// unsubscribe implements UNSUBSCRIBE, PUNSUBSCRIBE and SUNSUBSCRIBE. Without
+// arguments the client leaves every channel, pattern or shard channel.
+func (c *Client) unsubscribe(kind string, args []resp.Value) (resp.Value, bool) {
+	if c.out == nil {
+		c.out = newOutbox(c)
+	}
+
+	s := c.server
+	s.pubsubMu.Lock()
+	defer s.pubsubMu.Unlock()
+
+	mine, all := c.subscriptionsOf(kind)
+	names := make([]string, 0, len(args))
+	for _, arg := range args {
+		names = append(names, arg.Bulk)
+	}
+	if len(args) == 0 {
+		for name := range mine {
+			names = append(names, name)
+		}
+		if len(names) == 0 {
+			c.pushSubscription(kind, nil)
+		}
+	}
+
+	for _, name := range names {
+		if mine[name] {
+			delete(mine, name)
+			delete(all[name], c)
+			if len(all[name]) == 0 {
+				delete(all, name)
+			}
+		}
+		c.pushSubscription(kind, &name)
+	}
+	return resp.Value{}, false
+}
+
+// unsubscribeAll drops every subscription of a client that disconnected.
+func (c *Client) unsubscribeAll() {
+	s := c.server
+	s.pubsubMu.Lock()
+	defer s.pubsubMu.Unlock()
+
+	for _, kind := range []string{"unsubscribe", "punsubscribe", "sunsubscribe"} {
+		mine, all := c.subscriptionsOf(kind)
+		for name := range mine {
+			delete(all[name], c)
+			if len(all[name]) == 0 {
+				delete(all, name)
+			}
+		}
+	}
+}
+
+// publish implements PUBLISH channel message. It replies with the number of
+// clients that received the message. Replicas get it down the write stream
+// and deliver it to their own subscribers, though it is not a write and never
+// makes it to the AOF.
+func (s *Server) publish(args []resp.Value) resp.Value {
+	if len(args) != 2 {
+		return resp.Value{Typ: "error", Str: "ERR wrong number of arguments for 'publish' command"}
+	}
+	channel, message := args[0].Bulk, args[1].Bulk
+	receivers := s.deliver(channel, message)
+
+	s.replMu.Lock()
+	if s.primary == nil {
+		s.feedReplicas(command("PUBLISH", channel, message))
+	}
+	s.replMu.Unlock()
+
+	return resp.Value{Typ: "integer", Num: receivers}
+}
+
+// deliver pushes a message to the subscribers of channel and of the patterns
+// matching it, and returns how many messages were sent. A client subscribed
+// to both gets it once for each.
+func (s *Server) deliver(channel, message string) int {
+	s.pubsubMu.Lock()
+	defer s.pubsubMu.Unlock()
+
+	receivers := 0
+	if clients := s.channels[channel]; len(clients) > 0 {
+		data := command("message", channel, message).Marshal()
+		for c := range clients {
+			c.out.send(data)
+			receivers++
+		}
+	}
+	for pattern, clients := range s.patterns {
+		if !globMatch(pattern, channel) {
+			continue
+		}
+		data := command("pmessage", pattern, channel, message).Marshal()
+		for c := range clients {
+			c.out.send(data)
+			receivers++
+		}
+	}
+	return receivers
+}
+
+// spublish implements SPUBLISH shardchannel message. Shard channels are a
+// namespace of their own: the message only reaches SSUBSCRIBE subscribers,
+// and patterns never match it.
+func (s *Server) spublish(args []resp.Value) resp.Value {
+	if len(args) != 2 {
+		return resp.Value{Typ: "error", Str: "ERR wrong number of arguments for 'spublish' command"}
+	}
+	channel, message := args[0].Bulk, args[1].Bulk
+
+	s.pubsubMu.Lock()
+	clients := s.shardChannels[channel]
+	if len(clients) > 0 {
+		data := command("smessage", channel, message).Marshal()
+		for c := range clients {
+			c.out.send(data)
+		}
+	}
+	receivers := len(clients)
+	s.pubsubMu.Unlock()
+
+	s.replMu.Lock()
+	if s.primary == nil {
+		s.feedReplicas(command("SPUBLISH", channel, message))
+	}
+	s.replMu.Unlock()
+
+	return resp.Value{Typ: "integer", Num: receivers}
+}
+
+// pubsub implements PUBSUB CHANNELS [pattern], PUBSUB NUMSUB [channel ...],
+// PUBSUB NUMPAT, and PUBSUB SHARDCHANNELS [pattern] and PUBSUB SHARDNUMSUB
+// [shardchannel ...] for shard channels.
+func (s *Server) pubsub(args []resp.Value) resp.Value {
+	if len(args) == 0 {
+		return resp.Value{Typ: "error", Str: "ERR wrong number of arguments for 'pubsub' command"}
+	}
+
+	s.pubsubMu.Lock()
+	defer s.pubsubMu.Unlock()
+
+	switch subcommand := strings.ToUpper(args[0].Bulk); {
+	case (subcommand == "CHANNELS" || subcommand == "SHARDCHANNELS") && len(args) <= 2:
+		all := s.channels
+		if subcommand == "SHARDCHANNELS" {
+			all = s.shardChannels
+		}
+		channels := make([]string, 0, len(all))
+		for channel := range all {
+			if len(args) == 1 || globMatch(args[1].Bulk, channel) {
+				channels = append(channels, channel)
+			}
+		}
+		sort.Strings(channels)
+		return command(channels...)
+	case subcommand == "NUMSUB" || subcommand == "SHARDNUMSUB":
+		all := s.channels
+		if subcommand == "SHARDNUMSUB" {
+			all = s.shardChannels
+		}
+		reply := resp.Value{Typ: "array"}
+		for _, arg := range args[1:] {
+			reply.Array = append(reply.Array,
+				resp.Value{Typ: "bulk", Bulk: arg.Bulk},
+				resp.Value{Typ: "integer", Num: len(all[arg.Bulk])})
+		}
+		return reply
+	case subcommand == "NUMPAT" && len(args) == 1:
+		return resp.Value{Typ: "integer", Num: len(s.patterns)}
+	default:
+		return resp.Value{Typ: "error", Str: fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try PUBSUB HELP.", args[0].Bulk)}
+	}
+}
+
+// globMatch reports whether str matches pattern, using the glob syntax of
+// Redis: * and ? wildcards, [abc], [^abc] and [a-z] classes, and \ to match a
+// special character literally.
+func globMatch(pattern, str string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(str); i++ {
+				if globMatch(pattern[1:], str[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+		case '[':
+			if len(str) == 0 {
+				return false
+			}
+			pattern = pattern[1:]
+			negate := len(pattern) > 0 && pattern[0] == '^'
+			if negate {
+				pattern = pattern[1:]
+			}
+			match := false
+			for len(pattern) > 0 && pattern[0] != ']' {
+				switch {
+				case pattern[0] == '\\' && len(pattern) >= 2:
+					match = match || pattern[1] == str[0]
+					pattern = pattern[2:]
+				case len(pattern) >= 3 && pattern[1] == '-':
+					lo, hi := min(pattern[0], pattern[2]), max(pattern[0], pattern[2])
+					match = match || (str[0] >= lo && str[0] <= hi)
+					pattern = pattern[3:]
+				default:
+					match = match || pattern[0] == str[0]
+					pattern = pattern[1:]
+				}
+			}
+			if match == negate {
+				return false
+			}
+			if len(pattern) == 0 {
+				// An unterminated class ends with the pattern
+				return len(str) == 1
+			}
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(str) == 0 || pattern[0] != str[0] {
+				return false
+			}
+		}
+		pattern, str = pattern[1:], str[1:]
+	}
+	return len(str) == 0
+}
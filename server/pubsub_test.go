@@ -0,0 +1,215 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// expect reads the next reply or message of c and compares it, flattened,
+// with want.
+func expect(t *testing.T, c *testClient, want string) {
+	t.Helper()
+	if got := flatten(c.read()); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	_, addr := startServer(t)
+	sub, psub, pub := dial(t, addr), dial(t, addr), dial(t, addr)
+
+	sub.send("SUBSCRIBE", "news", "weather")
+	expect(t, sub, "subscribe news 1")
+	expect(t, sub, "subscribe weather 2")
+	psub.send("PSUBSCRIBE", "news.*")
+	expect(t, psub, "psubscribe news.* 1")
+
+	if reply := pub.do("PUBLISH", "news", "hello"); reply.Num != 1 {
+		t.Errorf("PUBLISH news reached %d clients, want 1", reply.Num)
+	}
+	expect(t, sub, "message news hello")
+	if reply := pub.do("PUBLISH", "news.art", "paint"); reply.Num != 1 {
+		t.Errorf("PUBLISH news.art reached %d clients, want 1", reply.Num)
+	}
+	expect(t, psub, "pmessage news.* news.art paint")
+	if reply := pub.do("PUBLISH", "sports", "goal"); reply.Num != 0 {
+		t.Errorf("PUBLISH sports reached %d clients, want 0", reply.Num)
+	}
+
+	sub.send("UNSUBSCRIBE", "news")
+	expect(t, sub, "unsubscribe news 1")
+	if reply := pub.do("PUBLISH", "news", "again"); reply.Num != 0 {
+		t.Errorf("PUBLISH after UNSUBSCRIBE reached %d clients, want 0", reply.Num)
+	}
+	sub.send("UNSUBSCRIBE")
+	expect(t, sub, "unsubscribe weather 0")
+	sub.send("UNSUBSCRIBE")
+	expect(t, sub, "unsubscribe (nil) 0")
+
+	// Out of subscriber mode, every command is allowed again
+	if reply := sub.do("PING"); reply.Str != "PONG" {
+		t.Errorf("PING after unsubscribing replied %+v", reply)
+	}
+}
+
+func TestSubscriberMode(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+
+	c.send("SUBSCRIBE", "news")
+	expect(t, c, "subscribe news 1")
+	if reply := c.do("GET", "k"); reply.Typ != "error" || !strings.HasPrefix(reply.Str, "ERR Can't execute 'get'") {
+		t.Errorf("GET in subscriber mode replied %+v, want an error", reply)
+	}
+	c.send("PING")
+	expect(t, c, "pong ")
+	c.send("PING", "hi")
+	expect(t, c, "pong hi")
+}
+
+func TestPubsubIntrospection(t *testing.T) {
+	_, addr := startServer(t)
+	sub, psub, c := dial(t, addr), dial(t, addr), dial(t, addr)
+
+	sub.send("SUBSCRIBE", "news.art", "news.music", "sports")
+	for i := 1; i <= 3; i++ {
+		sub.read()
+	}
+	psub.send("PSUBSCRIBE", "news.*", "*")
+	psub.read()
+	psub.read()
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"PUBSUB", "CHANNELS"}, "news.art news.music sports"},
+		{[]string{"PUBSUB", "CHANNELS", "news.*"}, "news.art news.music"},
+		{[]string{"PUBSUB", "NUMSUB", "sports", "weather"}, "sports 1 weather 0"},
+		{[]string{"PUBSUB", "NUMPAT"}, "2"},
+	}
+	for _, tt := range tests {
+		if got := flatten(c.do(tt.args...)); got != tt.want {
+			t.Errorf("%q = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+	if got := infoField(c, "stats", "pubsub_channels"); got != "3" {
+		t.Errorf("pubsub_channels = %q, want 3", got)
+	}
+
+	// Subscriptions go away with the connection
+	sub.conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for flatten(c.do("PUBSUB", "CHANNELS")) != "" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := flatten(c.do("PUBSUB", "CHANNELS")); got != "" {
+		t.Errorf("PUBSUB CHANNELS after the subscriber left = %q", got)
+	}
+	if reply := c.do("PUBSUB", "NOSUCH"); reply.Typ != "error" {
+		t.Errorf("PUBSUB NOSUCH replied %+v, want an error", reply)
+	}
+}
+
+func TestPublishReachesReplicas(t *testing.T) {
+	s, addr := startServer(t)
+	c, replica := dial(t, addr), dial(t, addr)
+	syncReplica(t, replica)
+
+	before := len(logged(s))
+	c.do("PUBLISH", "news", "hello")
+	expect(t, replica, "PUBLISH news hello")
+	if got := logged(s)[before:]; len(got) != 0 {
+		t.Errorf("PUBLISH logged %q, want nothing", got)
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		str     string
+		want    bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"news", "news", true},
+		{"news", "new", false},
+		{"*", "", true},
+		{"*", "anything", true},
+		{"news.*", "news.art", true},
+		{"news.*", "news", false},
+		{"*.art", "news.art", true},
+		{"a**b", "axyzb", true},
+		{"a*b*c", "abbbc", true},
+		{"a*b*c", "abbb", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"?", "", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[b-a]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{"[\\]]", "]", true},
+		{"[abc", "b", true},
+		{"[abc", "bc", false},
+		{"[a]", "", false},
+		{"\\*", "*", true},
+		{"\\*", "a", false},
+		{"a\\?c", "a?c", true},
+		{"a\\?c", "abc", false},
+		{"ab\\", "ab\\", true},
+	}
+	for _, tt := range tests {
+		if got := globMatch(tt.pattern, tt.str); got != tt.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", tt.pattern, tt.str, got, tt.want)
+		}
+	}
+}
+
+func TestShardChannels(t *testing.T) {
+	_, addr := startServer(t)
+	ssub, sub, pub := dial(t, addr), dial(t, addr), dial(t, addr)
+
+	ssub.send("SSUBSCRIBE", "{user1}.news", "{user1}.mail")
+	expect(t, ssub, "ssubscribe {user1}.news 1")
+	expect(t, ssub, "ssubscribe {user1}.mail 2")
+	sub.send("PSUBSCRIBE", "*")
+	expect(t, sub, "psubscribe * 1")
+
+	// Shard channels and plain channels do not mix
+	if reply := pub.do("SPUBLISH", "{user1}.news", "hello"); reply.Num != 1 {
+		t.Errorf("SPUBLISH reached %d clients, want 1", reply.Num)
+	}
+	expect(t, ssub, "smessage {user1}.news hello")
+	if reply := pub.do("PUBLISH", "{user1}.news", "plain"); reply.Num != 1 {
+		t.Errorf("PUBLISH reached %d clients, want only the pattern subscriber", reply.Num)
+	}
+	expect(t, sub, "pmessage * {user1}.news plain")
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"PUBSUB", "SHARDCHANNELS"}, "{user1}.mail {user1}.news"},
+		{[]string{"PUBSUB", "SHARDCHANNELS", "*news"}, "{user1}.news"},
+		{[]string{"PUBSUB", "SHARDNUMSUB", "{user1}.news", "other"}, "{user1}.news 1 other 0"},
+		{[]string{"PUBSUB", "CHANNELS"}, ""},
+	} {
+		if got := flatten(pub.do(tt.args...)); got != tt.want {
+			t.Errorf("%q = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+
+	// Shard subscriptions are counted on their own
+	ssub.send("SUNSUBSCRIBE", "{user1}.news")
+	expect(t, ssub, "sunsubscribe {user1}.news 1")
+
+	c := dial(t, addr)
+	if reply := c.do("SSUBSCRIBE", "foo", "bar"); reply.Typ != "error" || !strings.HasPrefix(reply.Str, "CROSSSLOT") {
+		t.Errorf("SSUBSCRIBE across slots replied %+v, want CROSSSLOT", reply)
+	}
+}
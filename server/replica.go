@@ -0,0 +1,481 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IAmRiteshKoushik/bluedis/cmd"
+	"github.com/IAmRiteshKoushik/bluedis/rdb"
+	"github.com/IAmRiteshKoushik/bluedis/resp"
+	"github.com/IAmRiteshKoushik/bluedis/store"
+)
+
+// States of the link between a replica and its primary, as ROLE reports them
+const (
+	replStateConnect    = "connect"    // Waiting to connect, or to reconnect
+	replStateConnecting = "connecting" // Handshake in progress
+	replStateSync       = "sync"       // Receiving the snapshot
+	replStateConnected  = "connected"  // Applying the stream of writes
+)
+
+const (
+	// A primary that sends nothing for this long, not even its periodic
+	// PING, is considered gone and the replica reconnects
+	replTimeout = 60 * time.Second
+
+	// How long a replica waits before trying to reach its primary again
+	replRetryDelay = time.Second
+
+	// How often a replica tells its primary how far it got
+	replAckPeriod = time.Second
+)
+
+// primaryLink is the replica side of the connection to the primary.
+type primaryLink struct {
+	host string
+	port int
+
+	mu    sync.Mutex
+	state string
+	conn  net.Conn // nil while not connected
+
+	stopOnce sync.Once
+	done     chan struct{} // Closed once the server stops replicating
+}
+
+func (l *primaryLink) getState() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.state
+}
+
+func (l *primaryLink) setState(state string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.state = state
+}
+
+// attach records the connection to the primary so that stop can close it. It
+// reports false if the link was stopped in the meantime.
+func (l *primaryLink) attach(conn net.Conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	select {
+	case <-l.done:
+		return false
+	default:
+	}
+	l.conn = conn
+	l.state = replStateConnecting
+	return true
+}
+
+// stop ends replication from this primary, interrupting whatever the link is
+// doing.
+func (l *primaryLink) stop() {
+	l.stopOnce.Do(func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		close(l.done)
+		if l.conn != nil {
+			l.conn.Close()
+		}
+	})
+}
+
+// linkReader counts the bytes received from the primary, which is how the
+// replica knows its offset in the stream, and gives up on a primary that has
+// gone quiet for longer than replTimeout.
+type linkReader struct {
+	conn net.Conn
+	n    int64
+}
+
+func (r *linkReader) Read(p []byte) (int, error) {
+	r.conn.SetReadDeadline(time.Now().Add(replTimeout))
+	n, err := r.conn.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// replicaof implements REPLICAOF host port, which turns the server into a
+// replica of another one, and REPLICAOF NO ONE, which turns it back into a
+// primary keeping the data it has.
+func (s *Server) replicaof(args []resp.Value) resp.Value {
+	if len(args) != 2 {
+		return resp.Value{Typ: "error", Str: "ERR wrong number of arguments for 'replicaof' command"}
+	}
+
+	if strings.EqualFold(args[0].Bulk, "no") && strings.EqualFold(args[1].Bulk, "one") {
+		s.replMu.Lock()
+		link := s.primary
+		s.primary = nil
+		if link != nil {
+			// The stream this server goes on to produce is not the one it
+			// was following, so it gets a name of its own. The old name is
+			// kept for the other replicas of the old primary, which can
+			// carry on from here with a partial resync.
+			s.replID2 = s.replID
+			s.secondReplOffset = s.replOffset + 1
+			s.replID = newReplID()
+		}
+		s.replMu.Unlock()
+
+		if link != nil {
+			link.stop()
+			fmt.Println("MASTER MODE enabled (user request from replicaof no one)")
+		}
+		return resp.Value{Typ: "string", Str: "OK"}
+	}
+
+	host := args[0].Bulk
+	port, err := strconv.Atoi(args[1].Bulk)
+	if err != nil || port < 0 || port > 65535 {
+		return resp.Value{Typ: "error", Str: "ERR Invalid master port"}
+	}
+
+	s.replMu.Lock()
+	old := s.primary
+	if old != nil && old.host == host && old.port == port {
+		s.replMu.Unlock()
+		return resp.Value{Typ: "string", Str: "OK Already connected to specified master"}
+	}
+	link := &primaryLink{host: host, port: port, state: replStateConnect, done: make(chan struct{})}
+	s.primary = link
+
+	// Replicas of this server have to follow whatever stream it ends up
+	// with. They reconnect, and get a partial resync if that turns out to be
+	// the one they had.
+	replicas := make([]*replica, 0, len(s.replicas))
+	for _, r := range s.replicas {
+		replicas = append(replicas, r)
+	}
+	s.replMu.Unlock()
+
+	if old != nil {
+		old.stop()
+	}
+	for _, r := range replicas {
+		r.client.close()
+	}
+
+	fmt.Printf("Connecting to MASTER %s:%d\n", host, port)
+	s.wg.Add(1)
+	go s.replicate(link)
+	return resp.Value{Typ: "string", Str: "OK"}
+}
+
+// replicate keeps the server in sync with the primary of link, reconnecting
+// whenever the connection drops, until the link is stopped.
+func (s *Server) replicate(link *primaryLink) {
+	defer s.wg.Done()
+
+	for {
+		err := s.syncWithPrimary(link)
+
+		select {
+		case <-link.done:
+			return
+		default:
+		}
+		fmt.Printf("Connection with MASTER %s:%d lost: %v\n", link.host, link.port, err)
+		link.setState(replStateConnect)
+
+		select {
+		case <-link.done:
+			return
+		case <-time.After(replRetryDelay):
+		}
+	}
+}
+
+// syncWithPrimary connects to the primary, loads its dataset and then applies
+// the writes it streams until the connection fails.
+func (s *Server) syncWithPrimary(link *primaryLink) error {
+	addr := net.JoinHostPort(link.host, strconv.Itoa(link.port))
+	conn, err := net.DialTimeout("tcp", addr, replTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if !link.attach(conn) {
+		return nil
+	}
+
+	lr := &linkReader{conn: conn}
+	rd := resp.NewResp(lr)
+	request := func(args ...string) (resp.Value, error) {
+		if _, err := conn.Write(command(args...).Marshal()); err != nil {
+			return resp.Value{}, err
+		}
+		reply, err := rd.Read()
+		if err == nil && reply.Typ == "error" {
+			err = fmt.Errorf("%s", reply.Str)
+		}
+		return reply, err
+	}
+
+	if _, err := request("PING"); err != nil {
+		return err
+	}
+	if _, err := request("REPLCONF", "listening-port", portOf(s.addr)); err != nil {
+		return err
+	}
+	if _, err := request("REPLCONF", "capa", "psync2"); err != nil {
+		return err
+	}
+
+	// Ask to carry on from where this server is in the stream it follows.
+	// That only works out if the primary took part in the same stream, as
+	// when reconnecting, and still has what was missed in its backlog.
+	s.replMu.Lock()
+	replID, offset := s.replID, s.replOffset
+	s.replMu.Unlock()
+
+	reply, err := request("PSYNC", replID, strconv.FormatInt(offset+1, 10))
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(reply.Str)
+	if len(fields) > 0 && fields[0] == "CONTINUE" {
+		s.continueStream(replID, fields[1:])
+		link.setState(replStateConnected)
+		fmt.Println("MASTER <-> REPLICA sync: Master accepted a Partial Resynchronization.")
+		return s.streamFromPrimary(link, conn, lr, rd)
+	}
+	if len(fields) != 3 || fields[0] != "FULLRESYNC" {
+		return fmt.Errorf("unexpected reply to PSYNC: %s", reply.Str)
+	}
+	replID = fields[1]
+	offset, err = strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return fmt.Errorf("unexpected reply to PSYNC: %s", reply.Str)
+	}
+
+	link.setState(replStateSync)
+	fmt.Printf("Full resync from MASTER: %s:%d\n", replID, offset)
+	payload, size, err := rd.ReadPayload()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("MASTER <-> REPLICA sync: receiving %d bytes from master\n", size)
+	if err := s.loadFromPrimary(payload, link, replID, offset); err != nil {
+		return err
+	}
+
+	link.setState(replStateConnected)
+	fmt.Println("MASTER <-> REPLICA sync: Finished with success")
+	return s.streamFromPrimary(link, conn, lr, rd)
+}
+
+// loadFromPrimary replaces the dataset with the snapshot sent by the primary.
+// The snapshot is read into a keyspace of its own first, so a broken transfer
+// leaves the current data alone. The AOF is rewritten from the new dataset
+// before any of the stream is applied, since the commands it holds describe
+// the old one.
+func (s *Server) loadFromPrimary(payload io.Reader, link *primaryLink, replID string, offset int64) error {
+	fresh := store.NewKeyspace()
+	if _, err := rdb.Load(payload, fresh); err != nil {
+		return fmt.Errorf("loading the snapshot from the master: %w", err)
+	}
+	// Keys that had expired were skipped, so the payload may not be used up
+	if _, err := io.Copy(io.Discard, payload); err != nil {
+		return err
+	}
+
+	// A rewrite that is already running would finish with the old dataset
+	// after this one, so wait for it
+	for !s.rewriting.CompareAndSwap(false, true) {
+		select {
+		case <-link.done:
+			return fmt.Errorf("replication stopped")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	defer s.rewriting.Store(false)
+
+	s.execMu.Lock()
+	defer s.execMu.Unlock()
+
+	cmd.DB.Lock()
+	cmd.DB.Replace(fresh)
+	cmd.DB.Unlock()
+
+	s.replMu.Lock()
+	s.replID = replID
+	s.replOffset = offset
+	s.replID2 = strings.Repeat("0", 40)
+	s.secondReplOffset = -1
+	s.backlog.reset(offset)
+	s.replMu.Unlock()
+
+	if err := s.aof.BeginRewrite(); err != nil {
+		return err
+	}
+	cmd.DB.RLock()
+	defer cmd.DB.RUnlock()
+	return s.finishRewrite(cmd.DB)
+}
+
+// continueStream takes note of a +CONTINUE [replid] reply. A primary that
+// was promoted since this server last followed it has a new ID; the stream
+// goes on under that name, and the old one is kept the same way the primary
+// keeps it.
+func (s *Server) continueStream(replID string, fields []string) {
+	if len(fields) == 0 || fields[0] == replID {
+		return
+	}
+
+	s.replMu.Lock()
+	defer s.replMu.Unlock()
+	s.replID2 = replID
+	s.secondReplOffset = s.replOffset + 1
+	s.replID = fields[0]
+
+	// Replicas of this server follow the old name, which no longer matches
+	for _, r := range s.replicas {
+		r.client.close()
+	}
+}
+
+// primaryLinkDown reports whether the server is a replica that is not in
+// sync with its primary, and so has no stream to offer replicas of its own.
+func (s *Server) primaryLinkDown() bool {
+	s.replMu.Lock()
+	defer s.replMu.Unlock()
+	return s.primary != nil && s.primary.getState() != replStateConnected
+}
+
+// streamFromPrimary applies the writes the primary streams, and acknowledges
+// them once a second, until the connection fails or the link is stopped.
+func (s *Server) streamFromPrimary(link *primaryLink, conn net.Conn, lr *linkReader, rd *resp.Resp) error {
+	var writeMu sync.Mutex
+	ack := func() error {
+		s.replMu.Lock()
+		offset := s.replOffset
+		s.replMu.Unlock()
+
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		_, err := conn.Write(command("REPLCONF", "ACK", strconv.FormatInt(offset, 10)).Marshal())
+		return err
+	}
+
+	stopAcks := make(chan struct{})
+	defer close(stopAcks)
+	go func() {
+		ticker := time.NewTicker(replAckPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopAcks:
+				return
+			case <-ticker.C:
+				ack()
+			}
+		}
+	}()
+
+	ack()
+	var tx []resp.Value // A transaction is held back until its EXEC
+	for {
+		value, err := rd.Read()
+		if err != nil {
+			return err
+		}
+		if value.Typ != "array" || len(value.Array) == 0 {
+			return fmt.Errorf("unexpected %s from the master", value.Typ)
+		}
+
+		values := []resp.Value{value}
+		if name := strings.ToUpper(value.Array[0].Bulk); name == "MULTI" || len(tx) > 0 {
+			tx = append(tx, value)
+			if name != "EXEC" {
+				continue
+			}
+			values, tx = tx, nil
+		}
+
+		getack, err := s.applyFromPrimary(values)
+		if err != nil {
+			return err
+		}
+		if getack {
+			if err := ack(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// applyFromPrimary runs commands received from the primary: a single one, or
+// a whole transaction from MULTI to EXEC, which is applied in one go like EXEC
+// does on the primary. They are logged to the AOF like any write and passed on
+// unchanged to this server's own replicas, which keeps them at the same
+// offsets. It reports whether the primary asked for an acknowledgement. If
+// the AOF cannot be written, nothing is passed on and the error is returned,
+// which drops the link to the primary.
+func (s *Server) applyFromPrimary(values []resp.Value) (bool, error) {
+	if len(values) > 1 {
+		s.execMu.Lock()
+		defer s.execMu.Unlock()
+	} else {
+		s.execMu.RLock()
+		defer s.execMu.RUnlock()
+		s.writeMu.Lock()
+		defer s.writeMu.Unlock()
+	}
+
+	getack := false
+	for _, value := range values {
+		name := strings.ToUpper(value.Array[0].Bulk)
+		args := value.Array[1:]
+
+		switch {
+		case name == "REPLCONF":
+			getack = len(args) > 0 && strings.EqualFold(args[0].Bulk, "GETACK")
+		case name == "PING":
+			// Part of the stream, but nothing to apply
+		case name == "MULTI" || name == "EXEC":
+			if err := s.aof.Write(value); err != nil {
+				return false, fmt.Errorf("writing to the AOF: %w", err)
+			}
+		default:
+			handler, _, ok := cmd.Lookup(name)
+			if !ok {
+				fmt.Printf("Skipping unknown command '%s' from the master\n", name)
+				break
+			}
+			dirty := cmd.DB.Dirty()
+			result := handler(args)
+			if cmd.DB.Dirty() != dirty {
+				if err := s.aof.Write(effectOf(name, args, result)); err != nil {
+					return false, fmt.Errorf("writing to the AOF: %w", err)
+				}
+			}
+		}
+	}
+
+	s.replMu.Lock()
+	for _, value := range values {
+		s.feedReplicas(value)
+	}
+	s.replMu.Unlock()
+	return getack, nil
+}
+
+// readOnlyReplica reports whether writes from clients have to be refused
+// because the server is a replica.
+func (s *Server) readOnlyReplica() bool {
+	s.replMu.Lock()
+	replica := s.primary != nil
+	s.replMu.Unlock()
+	return replica && s.cfg.Snapshot().ReplicaReadOnly
+}
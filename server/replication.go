@@ -0,0 +1,454 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IAmRiteshKoushik/bluedis/cmd"
+	"github.com/IAmRiteshKoushik/bluedis/rdb"
+	"github.com/IAmRiteshKoushik/bluedis/resp"
+)
+
+const (
+	// How often a primary pings its replicas, so that they can tell a quiet
+	// primary from a dead one
+	replPingPeriod = 10 * time.Second
+
+	// A replica whose output buffer grows past this is too far behind to ever
+	// catch up and gets disconnected, like client-output-buffer-limit does
+	replicaOutputLimit = 256 * 1024 * 1024
+
+	// How often WAIT checks whether enough replicas caught up
+	waitPollInterval = 10 * time.Millisecond
+)
+
+// replica is the primary side of a connected replica. Writes are queued in
+// buf and sent by a goroutine of their own, so a slow replica never holds up
+// the commands that feed it.
+type replica struct {
+	client        *Client
+	listeningPort int
+	ackOffset     atomic.Int64 // Last offset the replica said it has applied
+
+	mu   sync.Mutex
+	buf  []byte
+	wake chan struct{}
+}
+
+// send queues data for the replica. The caller holds replMu.
+func (r *replica) send(data []byte) {
+	r.mu.Lock()
+	r.buf = append(r.buf, data...)
+	over := len(r.buf) > replicaOutputLimit
+	if over {
+		r.buf = nil
+	}
+	r.mu.Unlock()
+
+	if over {
+		fmt.Printf("Replica %s is too far behind, disconnecting it\n", r.addr())
+		r.client.close()
+		return
+	}
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// stream writes whatever gets queued to the replica until the connection is
+// closed.
+func (r *replica) stream() {
+	for {
+		select {
+		case <-r.client.done:
+			return
+		case <-r.wake:
+		}
+
+		r.mu.Lock()
+		data := r.buf
+		r.buf = nil
+		r.mu.Unlock()
+
+		if _, err := r.client.conn.Write(data); err != nil {
+			fmt.Printf("Error writing to replica %s: %v\n", r.addr(), err)
+			r.client.close()
+			return
+		}
+	}
+}
+
+// addr returns the address the replica is known by in ROLE and INFO.
+func (r *replica) addr() string {
+	host, _, _ := net.SplitHostPort(r.client.conn.RemoteAddr().String())
+	return net.JoinHostPort(host, strconv.Itoa(r.listeningPort))
+}
+
+// newReplID returns a random replication ID, 40 hex characters like Redis.
+func newReplID() string {
+	id := make([]byte, 20)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// feedReplicas appends a command to the write stream: it moves the offset on,
+// keeps the command in the backlog and queues it for every replica. The caller
+// holds replMu.
+func (s *Server) feedReplicas(value resp.Value) {
+	data := value.Marshal()
+	s.replOffset += int64(len(data))
+	s.backlog.write(data)
+	for _, r := range s.replicas {
+		r.send(data)
+	}
+}
+
+// pingReplicas sends a PING down the write stream every replPingPeriod.
+func (s *Server) pingReplicas() {
+	s.replMu.Lock()
+	defer s.replMu.Unlock()
+
+	if s.primary != nil || len(s.replicas) == 0 || time.Since(s.lastReplPing) < replPingPeriod {
+		return
+	}
+	s.lastReplPing = time.Now()
+	s.feedReplicas(command("PING"))
+}
+
+// replconf implements REPLCONF, which replicas use to describe themselves
+// during the handshake and to acknowledge how much of the stream they have
+// applied afterwards.
+func (c *Client) replconf(args []resp.Value) (resp.Value, bool) {
+	if len(args)%2 != 0 {
+		return resp.Value{Typ: "error", Str: "ERR syntax error"}, true
+	}
+
+	for i := 0; i < len(args); i += 2 {
+		option, value := strings.ToLower(args[i].Bulk), args[i+1].Bulk
+		switch option {
+		case "listening-port":
+			port, err := strconv.Atoi(value)
+			if err != nil || port < 0 || port > 65535 {
+				return resp.Value{Typ: "error", Str: "ERR value is not an integer or out of range"}, true
+			}
+			c.listeningPort = port
+		case "capa":
+			// Nothing depends on the capabilities of the replica yet
+		case "ack":
+			// Acknowledgements get no reply, the replica is not waiting for one
+			if offset, err := strconv.ParseInt(value, 10, 64); err == nil && c.replica != nil {
+				c.replica.ackOffset.Store(offset)
+			}
+			return resp.Value{}, false
+		default:
+			return resp.Value{Typ: "error", Str: fmt.Sprintf("ERR Unrecognized REPLCONF option: %s", args[i].Bulk)}, true
+		}
+	}
+	return resp.Value{Typ: "string", Str: "OK"}, true
+}
+
+// psync implements PSYNC replid offset, where offset is the first byte of the
+// stream the replica is missing. If the replica followed the same stream and
+// what it missed is still in the backlog, it gets +CONTINUE and just that.
+// Otherwise it gets a full resynchronization: +FULLRESYNC with the ID and
+// offset of the stream, then the snapshot, then the stream from that offset
+// on.
+func (c *Client) psync(args []resp.Value) (resp.Value, bool) {
+	if len(args) != 2 {
+		return resp.Value{Typ: "error", Str: "ERR wrong number of arguments for 'psync' command"}, true
+	}
+	if c.replica != nil {
+		return resp.Value{}, false
+	}
+	if c.server.primaryLinkDown() {
+		return resp.Value{Typ: "error", Str: "NOMASTERLINK Can't SYNC while not connected with my master"}, true
+	}
+
+	if offset, err := strconv.ParseInt(args[1].Bulk, 10, 64); err == nil && c.partialSync(args[0].Bulk, offset) {
+		return resp.Value{}, false
+	}
+	return c.fullSync(true)
+}
+
+// partialSync turns the connection into a replica link that starts with the
+// part of the stream the replica missed, if the backlog still has it. It
+// reports whether it did.
+func (c *Client) partialSync(replID string, offset int64) bool {
+	s := c.server
+	s.replMu.Lock()
+	defer s.replMu.Unlock()
+
+	// The replica has everything before offset
+	have := offset - 1
+	if replID != s.replID && (replID != s.replID2 || offset > s.secondReplOffset) {
+		return false
+	}
+	missed, ok := s.backlog.since(have)
+	if !ok {
+		return false
+	}
+
+	// The reply goes out ahead of the backlog, through the same queue, so
+	// that nothing fed from now on can overtake it
+	r := &replica{client: c, listeningPort: c.listeningPort, wake: make(chan struct{}, 1)}
+	r.buf = append([]byte(fmt.Sprintf("+CONTINUE %s\r\n", s.replID)), missed...)
+	s.replicas[c.id] = r
+	c.replica = r
+
+	fmt.Printf("Partial resynchronization request from %s accepted. Sending %d bytes of backlog starting from offset %d.\n",
+		r.addr(), len(missed), offset)
+	r.start()
+	return true
+}
+
+// sync implements SYNC, the PSYNC of replicas from before partial
+// resynchronization existed. It skips the +FULLRESYNC line.
+func (c *Client) sync(args []resp.Value) (resp.Value, bool) {
+	if len(args) != 0 {
+		return resp.Value{Typ: "error", Str: "ERR wrong number of arguments for 'sync' command"}, true
+	}
+	return c.fullSync(false)
+}
+
+// fullSync turns the connection into a replica link. The keyspace is cloned
+// and the replica registered while the exec lock is held exclusively, so the
+// snapshot and the start of the stream are the same point in time: every
+// write before it is in the snapshot, every write after it is queued for the
+// replica while the snapshot is being sent.
+func (c *Client) fullSync(announce bool) (resp.Value, bool) {
+	s := c.server
+	if c.replica != nil {
+		return resp.Value{}, false
+	}
+	if s.primaryLinkDown() {
+		return resp.Value{Typ: "error", Str: "NOMASTERLINK Can't SYNC while not connected with my master"}, true
+	}
+
+	r := &replica{client: c, listeningPort: c.listeningPort, wake: make(chan struct{}, 1)}
+
+	s.execMu.Lock()
+	cmd.DB.RLock()
+	snapshot := cmd.DB.Clone()
+	cmd.DB.RUnlock()
+	s.replMu.Lock()
+	replID, offset := s.replID, s.replOffset
+	s.replicas[c.id] = r
+	s.replMu.Unlock()
+	s.execMu.Unlock()
+
+	c.replica = r
+	fmt.Printf("Replica %s asks for synchronization, starting a full resync at offset %d\n", r.addr(), offset)
+
+	var payload bytes.Buffer
+	if err := rdb.Save(&payload, snapshot); err != nil {
+		fmt.Println("Error writing the snapshot for the replica:", err)
+		c.close()
+		return resp.Value{}, false
+	}
+
+	var header bytes.Buffer
+	if announce {
+		fmt.Fprintf(&header, "+FULLRESYNC %s %d\r\n", replID, offset)
+	}
+	fmt.Fprintf(&header, "$%d\r\n", payload.Len())
+	if _, err := c.conn.Write(append(header.Bytes(), payload.Bytes()...)); err != nil {
+		fmt.Printf("Error sending the snapshot to replica %s: %v\n", r.addr(), err)
+		c.close()
+		return resp.Value{}, false
+	}
+	fmt.Printf("Synchronization with replica %s succeeded\n", r.addr())
+
+	// Whatever was queued while the snapshot went out follows it
+	r.start()
+	return resp.Value{}, false
+}
+
+// start sends the replica whatever has been queued for it so far, and then
+// the rest of the stream as it comes.
+func (r *replica) start() {
+	s := r.client.server
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		r.stream()
+	}()
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// role implements ROLE.
+func (s *Server) role(args []resp.Value) resp.Value {
+	if len(args) != 0 {
+		return resp.Value{Typ: "error", Str: "ERR wrong number of arguments for 'role' command"}
+	}
+
+	s.replMu.Lock()
+	defer s.replMu.Unlock()
+
+	if link := s.primary; link != nil {
+		return resp.Value{Typ: "array", Array: []resp.Value{
+			{Typ: "bulk", Bulk: "slave"},
+			{Typ: "bulk", Bulk: link.host},
+			{Typ: "integer", Num: link.port},
+			{Typ: "bulk", Bulk: link.getState()},
+			{Typ: "integer", Num: int(s.replOffset)},
+		}}
+	}
+
+	replicas := make([]resp.Value, 0, len(s.replicas))
+	for _, r := range s.replicas {
+		host, port, _ := net.SplitHostPort(r.addr())
+		replicas = append(replicas, resp.Value{Typ: "array", Array: []resp.Value{
+			{Typ: "bulk", Bulk: host},
+			{Typ: "bulk", Bulk: port},
+			{Typ: "bulk", Bulk: strconv.FormatInt(r.ackOffset.Load(), 10)},
+		}})
+	}
+	return resp.Value{Typ: "array", Array: []resp.Value{
+		{Typ: "bulk", Bulk: "master"},
+		{Typ: "integer", Num: int(s.replOffset)},
+		{Typ: "array", Array: replicas},
+	}}
+}
+
+// infoReplication renders the Replication section of INFO.
+func (s *Server) infoReplication() []string {
+	s.replMu.Lock()
+	defer s.replMu.Unlock()
+
+	var lines []string
+	if link := s.primary; link != nil {
+		status := "down"
+		if link.getState() == replStateConnected {
+			status = "up"
+		}
+		readOnly := 0
+		if s.cfg.Snapshot().ReplicaReadOnly {
+			readOnly = 1
+		}
+		lines = append(lines,
+			"role:slave",
+			fmt.Sprintf("master_host:%s", link.host),
+			fmt.Sprintf("master_port:%d", link.port),
+			fmt.Sprintf("master_link_status:%s", status),
+			fmt.Sprintf("slave_repl_offset:%d", s.replOffset),
+			fmt.Sprintf("slave_read_only:%d", readOnly),
+		)
+	} else {
+		lines = append(lines, "role:master")
+	}
+
+	lines = append(lines, fmt.Sprintf("connected_slaves:%d", len(s.replicas)))
+	i := 0
+	for _, r := range s.replicas {
+		host, port, _ := net.SplitHostPort(r.addr())
+		lines = append(lines, fmt.Sprintf("slave%d:ip=%s,port=%s,state=online,offset=%d", i, host, port, r.ackOffset.Load()))
+		i++
+	}
+	return append(lines,
+		fmt.Sprintf("master_replid:%s", s.replID),
+		fmt.Sprintf("master_replid2:%s", s.replID2),
+		fmt.Sprintf("master_repl_offset:%d", s.replOffset),
+		fmt.Sprintf("second_repl_offset:%d", s.secondReplOffset),
+		"repl_backlog_active:1",
+		fmt.Sprintf("repl_backlog_size:%d", len(s.backlog.buf)),
+		fmt.Sprintf("repl_backlog_first_byte_offset:%d", s.backlog.start()+1),
+		fmt.Sprintf("repl_backlog_histlen:%d", s.backlog.histlen),
+	)
+}
+
+// command builds a command out of its name and arguments.
+func command(args ...string) resp.Value {
+	value := resp.Value{Typ: "array", Array: make([]resp.Value, len(args))}
+	for i, arg := range args {
+		value.Array[i] = resp.Value{Typ: "bulk", Bulk: arg}
+	}
+	return value
+}
+
+// wait implements WAIT numreplicas timeout. It blocks the client until at
+// least numreplicas replicas acknowledged the client's last write, or timeout
+// milliseconds passed (0 waits forever), and replies with how many did.
+// Replicas acknowledge once a second on their own; they are asked to do so
+// right away to keep the wait short.
+func (c *Client) wait(args []resp.Value) (resp.Value, bool) {
+	if len(args) != 2 {
+		return resp.Value{Typ: "error", Str: "ERR wrong number of arguments for 'wait' command"}, true
+	}
+	numReplicas, err1 := strconv.Atoi(args[0].Bulk)
+	timeout, err2 := strconv.ParseInt(args[1].Bulk, 10, 64)
+	if err1 != nil || err2 != nil {
+		return resp.Value{Typ: "error", Str: "ERR value is not an integer or out of range"}, true
+	}
+	if timeout < 0 {
+		return resp.Value{Typ: "error", Str: "ERR timeout is negative"}, true
+	}
+
+	s := c.server
+	s.replMu.Lock()
+	if s.primary != nil {
+		s.replMu.Unlock()
+		return resp.Value{Typ: "error", Str: "ERR WAIT cannot be used with replica instances."}, true
+	}
+	acked := s.ackedReplicas(c.writeOffset)
+	if acked < numReplicas {
+		s.feedReplicas(command("REPLCONF", "GETACK", "*"))
+	}
+	s.replMu.Unlock()
+
+	if acked >= numReplicas {
+		return resp.Value{Typ: "integer", Num: acked}, true
+	}
+
+	ticker := time.NewTicker(waitPollInterval)
+	defer ticker.Stop()
+
+	var timerC <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(time.Duration(timeout) * time.Millisecond)
+		defer timer.Stop()
+		timerC = timer.C
+	}
+
+	for {
+		select {
+		case <-timerC:
+		case <-c.done:
+		case <-ticker.C:
+			s.replMu.Lock()
+			acked = s.ackedReplicas(c.writeOffset)
+			s.replMu.Unlock()
+			if acked < numReplicas {
+				continue
+			}
+		}
+
+		s.replMu.Lock()
+		acked = s.ackedReplicas(c.writeOffset)
+		s.replMu.Unlock()
+		return resp.Value{Typ: "integer", Num: acked}, true
+	}
+}
+
+// ackedReplicas counts the replicas that acknowledged offset. The caller
+// holds replMu.
+func (s *Server) ackedReplicas(offset int64) int {
+	acked := 0
+	for _, r := range s.replicas {
+		if r.ackOffset.Load() >= offset {
+			acked++
+		}
+	}
+	return acked
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IAmRiteshKoushik/bluedis/cmd"
+	"github.com/IAmRiteshKoushik/bluedis/rdb"
+	"github.com/IAmRiteshKoushik/bluedis/resp"
+	"github.com/IAmRiteshKoushik/bluedis/store"
+)
+
+// syncReplica performs the handshake of a replica over c and returns the
+// dataset the primary sent.
+func syncReplica(t *testing.T, c *testClient) *store.Keyspace {
+	t.Helper()
+	if reply := c.do("REPLCONF", "listening-port", "7777"); reply.Str != "OK" {
+		t.Fatalf("REPLCONF listening-port replied %+v", reply)
+	}
+	if reply := c.do("REPLCONF", "capa", "psync2"); reply.Str != "OK" {
+		t.Fatalf("REPLCONF capa replied %+v", reply)
+	}
+	if reply := c.do("PSYNC", "?", "-1"); !strings.HasPrefix(reply.Str, "FULLRESYNC ") {
+		t.Fatalf("PSYNC replied %+v", reply)
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	payload, _, err := c.reader.ReadPayload()
+	if err != nil {
+		t.Fatalf("reading the snapshot: %v", err)
+	}
+	ks := store.NewKeyspace()
+	if _, err := rdb.Load(payload, ks); err != nil {
+		t.Fatalf("loading the snapshot: %v", err)
+	}
+	return ks
+}
+
+func TestFullSync(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	before, after := t.Name()+":before", t.Name()+":after"
+	defer c.do("DEL", before, after)
+	c.do("SET", before, "v")
+
+	replica := dial(t, addr)
+	snapshot := syncReplica(t, replica)
+	if _, ok := snapshot.Lookup(before); !ok {
+		t.Errorf("snapshot is missing %s", before)
+	}
+
+	// Writes after the snapshot reach the replica as the stream, reads do not
+	c.do("GET", before)
+	c.do("SET", after, "v")
+	if got := flatten(replica.read()); got != "SET "+after+" v" {
+		t.Errorf("replica got %q, want SET %s v", got, after)
+	}
+
+	role := c.do("ROLE")
+	if len(role.Array) != 3 || role.Array[0].Bulk != "master" || len(role.Array[2].Array) != 1 ||
+		role.Array[2].Array[0].Array[1].Bulk != "7777" {
+		t.Errorf("ROLE = %q, want a master with the replica on port 7777", flatten(role))
+	}
+	if got := infoField(c, "replication", "connected_slaves"); got != "1" {
+		t.Errorf("connected_slaves = %q, want 1", got)
+	}
+}
+
+func TestReplconfErrors(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+
+	for _, args := range [][]string{
+		{"REPLCONF", "listening-port"},
+		{"REPLCONF", "listening-port", "port"},
+		{"REPLCONF", "nosuchoption", "1"},
+		{"PSYNC", "?"},
+		{"SYNC", "now"},
+	} {
+		if reply := c.do(args...); reply.Typ != "error" {
+			t.Errorf("%q replied %+v, want an error", args, reply)
+		}
+	}
+}
+
+func TestReplicaRefusesWrites(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	key := t.Name()
+	defer c.do("DEL", key)
+
+	// A primary that is not there leaves the replica trying to connect
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	port := strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+	l.Close()
+
+	if reply := c.do("REPLICAOF", "127.0.0.1", port); reply.Str != "OK" {
+		t.Fatalf("REPLICAOF replied %+v", reply)
+	}
+	if role := c.do("ROLE"); len(role.Array) != 5 || role.Array[0].Bulk != "slave" {
+		t.Errorf("ROLE = %q, want a slave", flatten(role))
+	}
+	if reply := c.do("SET", key, "v"); reply.Typ != "error" || !strings.HasPrefix(reply.Str, "READONLY ") {
+		t.Errorf("SET on a replica replied %+v, want READONLY", reply)
+	}
+	if reply := c.do("GET", key); reply.Typ == "error" {
+		t.Errorf("GET on a replica replied %+v", reply)
+	}
+
+	c.do("CONFIG", "SET", "replica-read-only", "no")
+	if reply := c.do("SET", key, "v"); reply.Str != "OK" {
+		t.Errorf("SET on a writable replica replied %+v", reply)
+	}
+	c.do("CONFIG", "SET", "replica-read-only", "yes")
+
+	if reply := c.do("REPLICAOF", "NO", "ONE"); reply.Str != "OK" {
+		t.Fatalf("REPLICAOF NO ONE replied %+v", reply)
+	}
+	if role := c.do("ROLE"); len(role.Array) != 3 || role.Array[0].Bulk != "master" {
+		t.Errorf("ROLE = %q, want a master", flatten(role))
+	}
+	if reply := c.do("DEL", key); reply.Typ == "error" {
+		t.Errorf("DEL after REPLICAOF NO ONE replied %+v", reply)
+	}
+}
+
+func TestApplyFromPrimary(t *testing.T) {
+	s := newServer(t, t.TempDir())
+	s.primary = &primaryLink{state: replStateConnected, done: make(chan struct{})}
+	key := t.Name()
+	defer cmd.DB.Remove(key)
+
+	before := len(logged(s))
+	set := command("SET", key, "v")
+	if getack, err := s.applyFromPrimary([]resp.Value{set}); getack || err != nil {
+		t.Errorf("SET from the primary = %v, %v, want no acknowledgement", getack, err)
+	}
+	if getack, err := s.applyFromPrimary([]resp.Value{command("REPLCONF", "GETACK", "*")}); !getack || err != nil {
+		t.Errorf("REPLCONF GETACK = %v, %v, want an acknowledgement", getack, err)
+	}
+
+	if entry, ok := cmd.DB.Lookup(key); !ok || entry.Value != "v" {
+		t.Errorf("%s was not set by the command from the primary", key)
+	}
+	// Only the write is logged, but the offset covers the whole stream
+	if got := logged(s)[before:]; strings.Join(got, ",") != "SET "+key+" v" {
+		t.Errorf("applying the stream logged %q", got)
+	}
+	want := int64(len(set.Marshal()) + len(command("REPLCONF", "GETACK", "*").Marshal()))
+	if s.replOffset != want {
+		t.Errorf("replica offset = %d, want %d", s.replOffset, want)
+	}
+}
+
+func TestPartialSync(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	key := t.Name()
+	defer c.do("DEL", key)
+
+	replID := infoField(c, "replication", "master_replid")
+	offset, _ := strconv.ParseInt(infoField(c, "replication", "master_repl_offset"), 10, 64)
+	c.do("SET", key, "v")
+
+	// A replica that was at offset gets just what it missed
+	replica := dial(t, addr)
+	if reply := replica.do("PSYNC", replID, strconv.FormatInt(offset+1, 10)); reply.Str != "CONTINUE "+replID {
+		t.Fatalf("PSYNC from the backlog replied %+v, want CONTINUE %s", reply, replID)
+	}
+	if got := flatten(replica.read()); got != "SET "+key+" v" {
+		t.Errorf("replica got %q, want SET %s v", got, key)
+	}
+
+	// Another stream, or an offset the backlog does not reach, needs a full
+	// resync
+	for _, args := range [][]string{
+		{"PSYNC", strings.Repeat("a", 40), strconv.FormatInt(offset+1, 10)},
+		{"PSYNC", replID, strconv.FormatInt(offset+1<<30, 10)},
+	} {
+		replica := dial(t, addr)
+		if reply := replica.do(args...); !strings.HasPrefix(reply.Str, "FULLRESYNC ") {
+			t.Errorf("%q replied %+v, want FULLRESYNC", args, reply)
+		}
+	}
+}
+
+func TestWait(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	key := t.Name()
+	defer c.do("DEL", key)
+
+	if reply := c.do("WAIT", "0", "0"); reply.Typ != "integer" || reply.Num != 0 {
+		t.Errorf("WAIT 0 0 replied %+v, want 0", reply)
+	}
+	c.do("SET", key, "before")
+	if reply := c.do("WAIT", "1", "50"); reply.Typ != "integer" || reply.Num != 0 {
+		t.Errorf("WAIT without replicas replied %+v, want 0 after the timeout", reply)
+	}
+
+	replica := dial(t, addr)
+	syncReplica(t, replica)
+	c.do("SET", key, "v")
+	c.send("WAIT", "1", "5000")
+
+	// The replica gets the write, then is asked how far it got
+	if got := flatten(replica.read()); got != "SET "+key+" v" {
+		t.Fatalf("replica got %q, want SET %s v", got, key)
+	}
+	if got := flatten(replica.read()); got != "REPLCONF GETACK *" {
+		t.Fatalf("replica got %q, want REPLCONF GETACK *", got)
+	}
+	offset := infoField(dial(t, addr), "replication", "master_repl_offset")
+	replica.send("REPLCONF", "ACK", offset)
+	if reply := c.read(); reply.Typ != "integer" || reply.Num != 1 {
+		t.Errorf("WAIT 1 replied %+v, want 1", reply)
+	}
+
+	// Asking for more replicas than there are waits out the timeout
+	if reply := c.do("WAIT", "2", "50"); reply.Typ != "integer" || reply.Num != 1 {
+		t.Errorf("WAIT 2 replied %+v, want 1 after the timeout", reply)
+	}
+	for _, args := range [][]string{{"WAIT", "1"}, {"WAIT", "one", "0"}, {"WAIT", "1", "-1"}} {
+		if reply := c.do(args...); reply.Typ != "error" {
+			t.Errorf("%q replied %+v, want an error", args, reply)
+		}
+	}
+}
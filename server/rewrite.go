@@ -0,0 +1,84 @@
+package server
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/IAmRiteshKoushik/bluedis/aof"
+	"github.com/IAmRiteshKoushik/bluedis/cmd"
+	"github.com/IAmRiteshKoushik/bluedis/rdb"
+	"github.com/IAmRiteshKoushik/bluedis/resp"
+	"github.com/IAmRiteshKoushik/bluedis/store"
+)
+
+// bgrewriteaof implements BGREWRITEAOF. The rewrite itself runs on a
+// goroutine of its own; the reply only says whether it could be started.
+func (s *Server) bgrewriteaof(args []resp.Value) resp.Value {
+	if len(args) != 0 {
+		return resp.Value{Typ: "error", Str: "ERR wrong number of arguments for 'bgrewriteaof' command"}
+	}
+	if err := s.startRewrite(); err != nil {
+		return resp.Value{Typ: "error", Str: err.Error()}
+	}
+	return resp.Value{Typ: "string", Str: "Background append only file rewriting started"}
+}
+
+// startRewrite compacts the AOF into a new base file holding the current
+// keyspace.
+//
+// Go has no fork() to get a copy-on-write view of the data, so the keyspace is
+// cloned instead while the exec lock is held exclusively. That makes the clone
+// and the switch to a new incr file a single point in time: every command
+// that ran before it is in the clone, every command that runs after it goes to
+// the new incr file. Writing the clone out happens in the background while
+// clients carry on.
+func (s *Server) startRewrite() error {
+	if !s.rewriting.CompareAndSwap(false, true) {
+		return fmt.Errorf("ERR Background append only file rewriting already in progress")
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer s.rewriting.Store(false)
+
+		// Waits for the command that asked for the rewrite (and every other
+		// command in flight) to release its shared hold on the lock
+		s.execMu.Lock()
+		cmd.DB.RLock()
+		snapshot := cmd.DB.Clone()
+		cmd.DB.RUnlock()
+		err := s.aof.BeginRewrite()
+		s.execMu.Unlock()
+		if err != nil {
+			fmt.Println("AOF rewrite:", err)
+			return
+		}
+
+		fmt.Println("Background append only file rewriting started")
+		if err := s.finishRewrite(snapshot); err != nil {
+			fmt.Println("Background AOF rewrite failed:", err)
+			return
+		}
+		fmt.Println("Background AOF rewrite finished successfully")
+	}()
+
+	return nil
+}
+
+// finishRewrite writes ks out as the new base of the AOF. With
+// aof-use-rdb-preamble the base is a snapshot, which is smaller and loads
+// faster; without it, the commands that rebuild the keyspace.
+func (s *Server) finishRewrite(ks *store.Keyspace) error {
+	if s.cfg.Snapshot().AofUseRDBPreamble {
+		return s.aof.FinishRewrite(aof.BaseRDB, func(w io.Writer) error {
+			return rdb.Save(w, ks)
+		})
+	}
+	return s.aof.FinishRewrite(aof.BaseAOF, func(w io.Writer) error {
+		return cmd.RewriteKeyspace(ks, func(value resp.Value) error {
+			_, err := w.Write(value.Marshal())
+			return err
+		})
+	})
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestBgrewriteaof(t *testing.T) {
+	for _, preamble := range []string{"yes", "no"} {
+		t.Run("aof-use-rdb-preamble "+preamble, func(t *testing.T) {
+			s, addr := startServer(t)
+			c := dial(t, addr)
+			c.do("CONFIG", "SET", "aof-use-rdb-preamble", preamble)
+			list, gone, after := t.Name()+":list", t.Name()+":gone", t.Name()+":after"
+			defer c.do("DEL", list, after)
+
+			for i := 0; i < 20; i++ {
+				c.do("RPUSH", list, strconv.Itoa(i))
+			}
+			c.do("SET", gone, "v")
+			c.do("DEL", gone)
+
+			if reply := c.do("BGREWRITEAOF"); reply.Typ != "string" {
+				t.Fatalf("BGREWRITEAOF replied %+v", reply)
+			}
+			waitFor(t, c, "persistence", "aof_rewrites", "1")
+			if got := infoField(c, "persistence", "aof_last_bgrewrite_status"); got != "ok" {
+				t.Errorf("aof_last_bgrewrite_status = %q, want ok", got)
+			}
+			// Writes after the rewrite land in the new incr file
+			c.do("SET", after, "v")
+
+			commands := make(map[string]int)
+			for _, line := range logged(s) {
+				fields := strings.Fields(line)
+				commands[fields[0]+" "+fields[1]]++
+			}
+			for command, want := range map[string]int{
+				"RPUSH " + list: 1,
+				"SET " + gone:   0,
+				"DEL " + gone:   0,
+				"SET " + after:  1,
+			} {
+				if commands[command] != want {
+					t.Errorf("rewritten AOF has %d %s, want %d", commands[command], command, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBgrewriteaofAlreadyRunning(t *testing.T) {
+	s, addr := startServer(t)
+	c := dial(t, addr)
+
+	s.rewriting.Store(true)
+	defer s.rewriting.Store(false)
+	if reply := c.do("BGREWRITEAOF"); reply.Typ != "error" {
+		t.Errorf("BGREWRITEAOF during a rewrite replied %+v, want an error", reply)
+	}
+	if reply := c.do("BGREWRITEAOF", "now"); reply.Typ != "error" {
+		t.Errorf("BGREWRITEAOF with an argument replied %+v, want an error", reply)
+	}
+}
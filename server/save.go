@@ -0,0 +1,142 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/IAmRiteshKoushik/bluedis/cmd"
+	"github.com/IAmRiteshKoushik/bluedis/rdb"
+	"github.com/IAmRiteshKoushik/bluedis/resp"
+)
+
+// After a failed background save, the save rules do not trigger another one
+// for this long, so that a full disk does not turn into a save loop
+const bgsaveRetryDelay = 5 * time.Second
+
+// saveCommand implements SAVE, which writes the snapshot before replying.
+// Writers wait for it to finish; readers carry on.
+func (s *Server) saveCommand(args []resp.Value) resp.Value {
+	if len(args) != 0 {
+		return resp.Value{Typ: "error", Str: "ERR wrong number of arguments for 'save' command"}
+	}
+	if !s.saving.CompareAndSwap(false, true) {
+		return resp.Value{Typ: "error", Str: "ERR Background save already in progress"}
+	}
+	defer s.saving.Store(false)
+
+	start := time.Now()
+	cmd.DB.RLock()
+	dirty := cmd.DB.Dirty()
+	err := rdb.SaveFile(s.cfg.Snapshot().DBFilename, cmd.DB)
+	cmd.DB.RUnlock()
+	s.saveFinished(dirty, start, err)
+
+	if err != nil {
+		return resp.Value{Typ: "error", Str: "ERR " + err.Error()}
+	}
+	fmt.Println("DB saved on disk")
+	return resp.Value{Typ: "string", Str: "OK"}
+}
+
+// bgsave implements BGSAVE. The snapshot is written on a goroutine of its
+// own; the reply only says whether it could be started.
+func (s *Server) bgsave(args []resp.Value) resp.Value {
+	if len(args) != 0 {
+		return resp.Value{Typ: "error", Str: "ERR wrong number of arguments for 'bgsave' command"}
+	}
+	if err := s.startBgsave(); err != nil {
+		return resp.Value{Typ: "error", Str: err.Error()}
+	}
+	return resp.Value{Typ: "string", Str: "Background saving started"}
+}
+
+// lastsave implements LASTSAVE, the Unix time of the last successful save.
+func (s *Server) lastsave(args []resp.Value) resp.Value {
+	if len(args) != 0 {
+		return resp.Value{Typ: "error", Str: "ERR wrong number of arguments for 'lastsave' command"}
+	}
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	return resp.Value{Typ: "integer", Num: int(s.lastSave.Unix())}
+}
+
+// startBgsave writes a snapshot in the background. Like an AOF rewrite it
+// works from a clone of the keyspace, which stands in for the copy-on-write
+// pages a forked Redis child would get: clients are only held up for as long
+// as the clone takes, not for the whole write. The clone is taken with the
+// exec lock held exclusively, so that it never catches a transaction or a
+// script half way through.
+func (s *Server) startBgsave() error {
+	if !s.saving.CompareAndSwap(false, true) {
+		return fmt.Errorf("ERR Background save already in progress")
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer s.saving.Store(false)
+
+		// Waits for the command that asked for the save (and every other
+		// command in flight) to release its hold on the lock
+		s.execMu.Lock()
+		cmd.DB.RLock()
+		snapshot := cmd.DB.Clone()
+		dirty := cmd.DB.Dirty()
+		cmd.DB.RUnlock()
+		s.execMu.Unlock()
+
+		fmt.Println("Background saving started")
+		start := time.Now()
+		err := rdb.SaveFile(s.cfg.Snapshot().DBFilename, snapshot)
+		s.saveFinished(dirty, start, err)
+		if err != nil {
+			fmt.Println("Background saving error:", err)
+			return
+		}
+		fmt.Println("Background saving terminated with success")
+	}()
+
+	return nil
+}
+
+// saveFinished records the outcome of a save. dirty is the keyspace change
+// counter as of the moment the snapshot was taken.
+func (s *Server) saveFinished(dirty int64, start time.Time, err error) {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.lastBgsaveErr = err
+	s.lastBgsaveTry = start
+	s.lastBgsaveDuration = time.Since(start)
+	if err == nil {
+		s.lastSave = time.Now()
+		s.lastSaveDirty = dirty
+	}
+}
+
+// checkSaveRules starts a background save when one of the save rules is met:
+// at least that many changes since the last save, and at least that many
+// seconds.
+func (s *Server) checkSaveRules() {
+	if s.saving.Load() {
+		return
+	}
+
+	s.saveMu.Lock()
+	since := time.Since(s.lastSave)
+	changes := cmd.DB.Dirty() - s.lastSaveDirty
+	retrying := s.lastBgsaveErr != nil && time.Since(s.lastBgsaveTry) < bgsaveRetryDelay
+	s.saveMu.Unlock()
+
+	if changes == 0 || retrying {
+		return
+	}
+
+	for _, rule := range s.cfg.Snapshot().SaveRules {
+		if changes >= rule.Changes && since >= time.Duration(rule.Seconds)*time.Second {
+			fmt.Printf("%d changes in %d seconds. Saving...\n", rule.Changes, rule.Seconds)
+			s.startBgsave()
+			return
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/IAmRiteshKoushik/bluedis/cmd"
+	"github.com/IAmRiteshKoushik/bluedis/rdb"
+	"github.com/IAmRiteshKoushik/bluedis/store"
+)
+
+// snapshotHas reports whether the snapshot of s holds key.
+func snapshotHas(t *testing.T, s *Server, key string) bool {
+	t.Helper()
+	ks := store.NewKeyspace()
+	if _, err := rdb.LoadFile(s.cfg.Snapshot().DBFilename, ks); err != nil {
+		t.Fatalf("loading the snapshot: %v", err)
+	}
+	_, ok := ks.Lookup(key)
+	return ok
+}
+
+// waitFor polls INFO until field has the given value.
+func waitFor(t *testing.T, c *testClient, section, field, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := infoField(c, section, field)
+		if got == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s = %q, want %q", field, got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSave(t *testing.T) {
+	s, addr := startServer(t)
+	c := dial(t, addr)
+	key := t.Name()
+	defer c.do("DEL", key)
+
+	before := time.Now().Unix()
+	c.do("SET", key, "v")
+	if got := infoField(c, "persistence", "rdb_changes_since_last_save"); got == "0" {
+		t.Errorf("rdb_changes_since_last_save = 0 after a SET")
+	}
+	if reply := c.do("SAVE"); reply.Str != "OK" {
+		t.Fatalf("SAVE replied %+v", reply)
+	}
+	if !snapshotHas(t, s, key) {
+		t.Errorf("snapshot is missing %s", key)
+	}
+	if at := c.do("LASTSAVE").Num; int64(at) < before {
+		t.Errorf("LASTSAVE = %d, before the SAVE at %d", at, before)
+	}
+	if got := infoField(c, "persistence", "rdb_changes_since_last_save"); got != "0" {
+		t.Errorf("rdb_changes_since_last_save = %q after SAVE, want 0", got)
+	}
+}
+
+func TestBgsave(t *testing.T) {
+	s, addr := startServer(t)
+	c := dial(t, addr)
+	key := t.Name()
+	defer c.do("DEL", key)
+
+	c.do("SET", key, "v")
+	if reply := c.do("BGSAVE"); reply.Str != "Background saving started" {
+		t.Fatalf("BGSAVE replied %+v", reply)
+	}
+	waitFor(t, c, "persistence", "rdb_bgsave_in_progress", "0")
+	if got := infoField(c, "persistence", "rdb_last_bgsave_status"); got != "ok" {
+		t.Errorf("rdb_last_bgsave_status = %q, want ok", got)
+	}
+	if !snapshotHas(t, s, key) {
+		t.Errorf("snapshot is missing %s", key)
+	}
+
+	for _, command := range []string{"SAVE", "BGSAVE", "LASTSAVE"} {
+		if reply := c.do(command, "now"); reply.Typ != "error" {
+			t.Errorf("%s with an argument replied %+v, want an error", command, reply)
+		}
+	}
+}
+
+// A BGSAVE queued in a transaction runs inside EXEC, which holds the exec
+// lock: the snapshot waits for the transaction to end and has all of it
+func TestBgsaveInMulti(t *testing.T) {
+	s, addr := startServer(t)
+	c := dial(t, addr)
+	a, b := t.Name()+":a", t.Name()+":b"
+	defer c.do("DEL", a, b)
+
+	c.do("MULTI")
+	c.do("SET", a, "v")
+	c.do("BGSAVE")
+	c.do("SET", b, "v")
+	if got := flatten(c.do("EXEC")); got != "OK Background saving started OK" {
+		t.Fatalf("EXEC replied %q", got)
+	}
+	waitFor(t, c, "persistence", "rdb_bgsave_in_progress", "0")
+	if !snapshotHas(t, s, a) || !snapshotHas(t, s, b) {
+		t.Errorf("snapshot caught the transaction half way through")
+	}
+}
+
+func TestBgsaveFailure(t *testing.T) {
+	s, addr := startServer(t)
+	c := dial(t, addr)
+
+	// Nowhere to write the snapshot to
+	s.cfg.DBFilename = filepath.Join(t.TempDir(), "missing", "dump.rdb")
+	c.do("BGSAVE")
+	waitFor(t, c, "persistence", "rdb_bgsave_in_progress", "0")
+	if got := infoField(c, "persistence", "rdb_last_bgsave_status"); got != "err" {
+		t.Errorf("rdb_last_bgsave_status = %q, want err", got)
+	}
+	if reply := c.do("SAVE"); reply.Typ != "error" {
+		t.Errorf("SAVE replied %+v, want an error", reply)
+	}
+}
+
+func TestSaveRules(t *testing.T) {
+	s, addr := startServer(t)
+	c := dial(t, addr)
+	key := t.Name()
+	defer c.do("DEL", key)
+
+	if reply := c.do("CONFIG", "SET", "save", "1 1"); reply.Str != "OK" {
+		t.Fatalf("CONFIG SET save replied %+v", reply)
+	}
+	c.do("SET", key, "v")
+
+	// The rule asks for a second since the last save, which is server start
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(s.cfg.Snapshot().DBFilename); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no snapshot was taken")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	waitFor(t, c, "persistence", "rdb_bgsave_in_progress", "0")
+	if !snapshotHas(t, s, key) {
+		t.Errorf("snapshot is missing %s", key)
+	}
+}
+
+func TestLoadSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	key := t.Name()
+	ks := store.NewKeyspace()
+	ks.Put(key, store.TypeString, "from the snapshot")
+	if err := rdb.SaveFile(filepath.Join(dir, "dump.rdb"), ks); err != nil {
+		t.Fatalf("SaveFile: %v", err)
+	}
+	defer func() {
+		cmd.DB.Lock()
+		cmd.DB.Remove(key)
+		cmd.DB.Unlock()
+	}()
+
+	s := newServer(t, dir)
+	if err := s.LoadData(); err != nil {
+		t.Fatalf("LoadData: %v", err)
+	}
+	c := dial(t, serve(t, s))
+	if reply := c.do("GET", key); reply.Bulk != "from the snapshot" {
+		t.Errorf("GET after loading the snapshot = %+v", reply)
+	}
+	// The AOF starts out with what the snapshot held
+	if got := logged(s); len(got) != 1 || got[0] != "SET "+key+" from the snapshot" {
+		t.Errorf("AOF after loading the snapshot = %q", got)
+	}
+	if got := infoField(c, "persistence", "rdb_changes_since_last_save"); got != "0" {
+		t.Errorf("rdb_changes_since_last_save = %q after loading, want 0", got)
+	}
+}
+
+func TestAofWinsOverSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	key := t.Name()
+	ks := store.NewKeyspace()
+	ks.Put(key, store.TypeString, "from the snapshot")
+	rdb.SaveFile(filepath.Join(dir, "dump.rdb"), ks)
+	writeAof(t, dir, "SET "+key+" from-the-aof")
+	defer func() {
+		cmd.DB.Lock()
+		cmd.DB.Remove(key)
+		cmd.DB.Unlock()
+	}()
+
+	s := newServer(t, dir)
+	if err := s.LoadData(); err != nil {
+		t.Fatalf("LoadData: %v", err)
+	}
+	c := dial(t, serve(t, s))
+	if reply := c.do("GET", key); reply.Bulk != "from-the-aof" {
+		t.Errorf("GET with both an AOF and a snapshot = %+v", reply)
+	}
+}
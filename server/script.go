@@ -0,0 +1,251 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/IAmRiteshKoushik/bluedis/cmd"
+	"github.com/IAmRiteshKoushik/bluedis/resp"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// runningScript is the script or function being run, if any, which SCRIPT
+// KILL or FUNCTION KILL respectively can stop as long as it has not written
+// anything yet.
+type runningScript struct {
+	function bool // Run by FCALL rather than EVAL
+	readOnly bool // Not allowed to run write commands
+	cancel   context.CancelFunc
+
+	mu     sync.Mutex // Held while the script runs a command
+	wrote  bool
+	killed bool
+}
+
+// eval implements EVAL script numkeys [key ...] [arg ...]. The script is
+// cached, so that EVALSHA can run it again by its SHA1 digest.
+func (c *Client) eval(args []resp.Value) (resp.Value, bool) {
+	if len(args) < 2 {
+		return resp.Value{Typ: "error", Str: "ERR wrong number of arguments for 'eval' command"}, true
+	}
+	body := args[0].Bulk
+	sha := sha1hex(body)
+
+	s := c.server
+	s.scriptMu.Lock()
+	s.scripts[sha] = body
+	s.scriptMu.Unlock()
+
+	return c.evalScript(sha, body, args[1:]), true
+}
+
+// evalsha implements EVALSHA sha1 numkeys [key ...] [arg ...].
+func (c *Client) evalsha(args []resp.Value) (resp.Value, bool) {
+	if len(args) < 2 {
+		return resp.Value{Typ: "error", Str: "ERR wrong number of arguments for 'evalsha' command"}, true
+	}
+	sha := strings.ToLower(args[0].Bulk)
+
+	s := c.server
+	s.scriptMu.Lock()
+	body, ok := s.scripts[sha]
+	s.scriptMu.Unlock()
+	if !ok {
+		return resp.Value{Typ: "error", Str: "NOSCRIPT No matching script. Please use EVAL."}, true
+	}
+
+	return c.evalScript(sha, body, args[1:]), true
+}
+
+// evalScript runs a script given numkeys and the keys and arguments that
+// follow it.
+func (c *Client) evalScript(sha, body string, args []resp.Value) resp.Value {
+	numkeys, err := parseNumKeys(args[0].Bulk, len(args)-1)
+	if err != nil {
+		return resp.Value{Typ: "error", Str: err.Error()}
+	}
+	keys, argv := args[1:1+numkeys], args[1+numkeys:]
+
+	return c.runScript(&runningScript{}, func(L *lua.LState) resp.Value {
+		L.SetGlobal("KEYS", luaStrings(L, keys))
+		L.SetGlobal("ARGV", luaStrings(L, argv))
+
+		fn, err := L.Load(strings.NewReader(body), luaChunkName)
+		if err != nil {
+			return errorReply(fmt.Sprintf("ERR Error compiling script (new function): %v", err))
+		}
+		L.Push(fn)
+		if err := L.PCall(0, 1, nil); err != nil {
+			return luaError(err, "call to f_"+sha)
+		}
+		return luaToResp(L.Get(-1))
+	})
+}
+
+// runScript runs a script or a function through run, which gets the
+// interpreter to run it in. Scripts run while the exec lock is held
+// exclusively, so that no other client can see or change the keyspace half
+// way through, the same as EXEC. What they change is propagated as the
+// commands they ran, between MULTI and EXEC: a script may well not do the
+// same thing twice. A script queued in a transaction runs as part of it,
+// under the lock EXEC already holds.
+func (c *Client) runScript(running *runningScript, run func(L *lua.LState) resp.Value) resp.Value {
+	s := c.server
+	unit := c.execUnit
+	if unit == nil {
+		s.execMu.Lock()
+		defer s.execMu.Unlock()
+		unit = &atomicUnit{server: s}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	running.cancel = cancel
+	s.scriptMu.Lock()
+	s.runningScript = running
+	s.scriptMu.Unlock()
+	defer func() {
+		s.scriptMu.Lock()
+		s.runningScript = nil
+		s.scriptMu.Unlock()
+	}()
+
+	L := newLuaState(ctx, func(args []resp.Value) resp.Value {
+		running.mu.Lock()
+		defer running.mu.Unlock()
+		if running.killed {
+			return resp.Value{Typ: "error", Str: "ERR Script killed by user with SCRIPT KILL..."}
+		}
+		result := s.scriptCall(unit, args, running.readOnly)
+		running.wrote = unit.wrote
+		return result
+	})
+	defer L.Close()
+	result := run(L)
+
+	if unit != c.execUnit {
+		offset, wrote, err := unit.finish()
+		if err != nil {
+			return aofError(err)
+		}
+		if wrote {
+			c.writeOffset = offset
+		}
+	}
+	if ctx.Err() != nil {
+		if running.function {
+			return resp.Value{Typ: "error", Str: "ERR Script killed by user with FUNCTION KILL..."}
+		}
+		return resp.Value{Typ: "error", Str: "ERR Script killed by user with SCRIPT KILL..."}
+	}
+	return result
+}
+
+// scriptCall runs a command on behalf of a script, with the same checks a
+// command sent by a client goes through. A read-only script cannot run write
+// commands at all, and none can run the commands flagged no-script, such as
+// SAVE or REPLICAOF. Those that act on a connection, which a script does not
+// have, are not registered and so cannot be called either.
+func (s *Server) scriptCall(unit *atomicUnit, args []resp.Value, readOnly bool) resp.Value {
+	name := strings.ToUpper(args[0].Bulk)
+	_, spec, ok := cmd.Lookup(name)
+	if !ok {
+		return resp.Value{Typ: "error", Str: "ERR Unknown Redis command called from script"}
+	}
+	if spec.Flags&cmd.FlagNoScript != 0 {
+		return resp.Value{Typ: "error", Str: "ERR This Redis command is not allowed from script"}
+	}
+	if !spec.CheckArity(len(args)) {
+		return resp.Value{Typ: "error", Str: "ERR Wrong number of args calling Redis command from script"}
+	}
+	if spec.IsWrite() && readOnly {
+		return resp.Value{Typ: "error", Str: "ERR Write commands are not allowed from read-only scripts."}
+	}
+	if spec.IsWrite() && s.readOnlyReplica() {
+		return resp.Value{Typ: "error", Str: "READONLY You can't write against a read only replica."}
+	}
+	return unit.call(name, args[1:])
+}
+
+// script implements SCRIPT LOAD, EXISTS, FLUSH and KILL.
+func (c *Client) script(args []resp.Value) (resp.Value, bool) {
+	if len(args) == 0 {
+		return resp.Value{Typ: "error", Str: "ERR wrong number of arguments for 'script' command"}, true
+	}
+
+	s := c.server
+	switch subcommand := strings.ToUpper(args[0].Bulk); {
+	case subcommand == "LOAD" && len(args) == 2:
+		body := args[1].Bulk
+		L := newLuaState(context.Background(), nil)
+		_, err := L.Load(strings.NewReader(body), luaChunkName)
+		L.Close()
+		if err != nil {
+			return errorReply(fmt.Sprintf("ERR Error compiling script (new function): %v", err)), true
+		}
+
+		sha := sha1hex(body)
+		s.scriptMu.Lock()
+		s.scripts[sha] = body
+		s.scriptMu.Unlock()
+		return resp.Value{Typ: "bulk", Bulk: sha}, true
+
+	case subcommand == "EXISTS" && len(args) >= 2:
+		reply := resp.Value{Typ: "array"}
+		s.scriptMu.Lock()
+		for _, arg := range args[1:] {
+			exists := 0
+			if _, ok := s.scripts[strings.ToLower(arg.Bulk)]; ok {
+				exists = 1
+			}
+			reply.Array = append(reply.Array, resp.Value{Typ: "integer", Num: exists})
+		}
+		s.scriptMu.Unlock()
+		return reply, true
+
+	case subcommand == "FLUSH" && len(args) <= 2:
+		if len(args) == 2 {
+			if mode := strings.ToUpper(args[1].Bulk); mode != "ASYNC" && mode != "SYNC" {
+				return resp.Value{Typ: "error", Str: "ERR SCRIPT FLUSH only support SYNC|ASYNC option"}, true
+			}
+		}
+		s.scriptMu.Lock()
+		s.scripts = make(map[string]string)
+		s.scriptMu.Unlock()
+		return resp.Value{Typ: "string", Str: "OK"}, true
+
+	case subcommand == "KILL" && len(args) == 1:
+		return s.killScript(false), true
+
+	default:
+		return resp.Value{Typ: "error", Str: fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try SCRIPT HELP.", args[0].Bulk)}, true
+	}
+}
+
+// killScript implements SCRIPT KILL, or FUNCTION KILL when function is set,
+// which each only stop what they are named after.
+func (s *Server) killScript(function bool) resp.Value {
+	s.scriptMu.Lock()
+	running := s.runningScript
+	s.scriptMu.Unlock()
+	if running == nil {
+		return resp.Value{Typ: "error", Str: "NOTBUSY No scripts in execution right now."}
+	}
+
+	running.mu.Lock()
+	defer running.mu.Unlock()
+	if running.wrote {
+		return resp.Value{Typ: "error", Str: "UNKILLABLE Sorry the script already executed write commands against the dataset. You can either wait the script termination or kill the server in a hard way using the SHUTDOWN NOSAVE command."}
+	}
+	switch {
+	case running.function && !function:
+		return resp.Value{Typ: "error", Str: "BUSY Redis is busy running a script. You can only call FUNCTION KILL or SHUTDOWN NOSAVE."}
+	case !running.function && function:
+		return resp.Value{Typ: "error", Str: "BUSY Redis is busy running a script. You can only call SCRIPT KILL or SHUTDOWN NOSAVE."}
+	}
+	running.killed = true
+	running.cancel()
+	return resp.Value{Typ: "string", Str: "OK"}
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEval(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	key := t.Name()
+	defer c.do("DEL", key)
+	c.do("SET", key, "stored")
+
+	tests := []struct {
+		name string
+		args []string // After the script
+		body string
+		want string // Type, then the flattened reply
+	}{
+		{"integer", []string{"0"}, "return 42", "integer 42"},
+		{"number", []string{"0"}, "return 3.99", "integer 3"},
+		{"string", []string{"0"}, "return 'hi'", "bulk hi"},
+		{"true", []string{"0"}, "return true", "integer 1"},
+		{"false", []string{"0"}, "return false", "null (nil)"},
+		{"nothing", []string{"0"}, "return", "null (nil)"},
+		{"array ends at nil", []string{"0"}, "return {1, 'a', nil, 2}", "array 1 a"},
+		{"status", []string{"0"}, "return redis.status_reply('FINE')", "string FINE"},
+		{"error", []string{"0"}, "return redis.error_reply('MY failure')", "error MY failure"},
+		{"keys and args", []string{"1", "k", "a", "b"}, "return KEYS[1] .. ARGV[1] .. ARGV[2]", "bulk kab"},
+		{"call", []string{"1", key}, "return redis.call('GET', KEYS[1])", "bulk stored"},
+		{"call a missing key", []string{"0"}, "return redis.call('GET', 'no:such:key') == false", "integer 1"},
+		{"call raises", []string{"1", key}, "return redis.call('LPUSH', KEYS[1], 'a')", "error WRONGTYPE"},
+		{"pcall returns the error", []string{"1", key}, "return redis.pcall('LPUSH', KEYS[1], 'a')['err'] ~= nil", "integer 1"},
+		{"unknown command", []string{"0"}, "return redis.call('NOSUCH')", "error ERR Unknown Redis command"},
+		{"admin command", []string{"0"}, "return redis.call('CONFIG', 'SET', 'appendfsync', 'no')", "error ERR This Redis command is not allowed from script"},
+		{"replication command", []string{"0"}, "return redis.call('REPLICAOF', 'NO', 'ONE')", "error ERR This Redis command is not allowed from script"},
+		{"connection command", []string{"0"}, "return redis.call('MULTI')", "error ERR Unknown Redis command"},
+		{"wrong number of arguments", []string{"0"}, "return redis.call('GET')", "error ERR Wrong number of args"},
+		{"runtime error", []string{"0"}, "return nil + 1", "error ERR Error running script"},
+		{"compile error", []string{"0"}, "return (", "error ERR Error compiling script"},
+		{"numkeys too large", []string{"2", "k"}, "return 1", "error ERR Number of keys can't be greater"},
+		{"negative numkeys", []string{"-1"}, "return 1", "error ERR Number of keys can't be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply := c.do(append([]string{"EVAL", tt.body}, tt.args...)...)
+			if got := reply.Typ + " " + flatten(reply); !strings.HasPrefix(got, tt.want) {
+				t.Errorf("EVAL %q = %q, want %q", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalPropagatesEffects(t *testing.T) {
+	s, addr := startServer(t)
+	c := dial(t, addr)
+	str, list := t.Name()+":string", t.Name()+":list"
+	defer c.do("DEL", str, list)
+
+	// The commands the script ran are logged, in their effect form, as one
+	// transaction
+	before := len(logged(s))
+	reply := c.do("EVAL", `
+		redis.call('GET', KEYS[1])
+		redis.call('SET', KEYS[1], 'v', 'PXAT', ARGV[1])
+		redis.call('RPUSH', KEYS[2], 'a', 'b')
+		return redis.call('LPOP', KEYS[2])`, "2", str, list, "4102444800000")
+	if reply.Bulk != "a" {
+		t.Fatalf("EVAL replied %+v", reply)
+	}
+	want := []string{"MULTI", "SET " + str + " v PXAT 4102444800000", "RPUSH " + list + " a b", "LPOP " + list, "EXEC"}
+	if got := logged(s)[before:]; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("EVAL logged %q, want %q", got, want)
+	}
+
+	// A script that only reads logs nothing
+	before = len(logged(s))
+	c.do("EVAL", "return redis.call('GET', KEYS[1])", "1", str)
+	if got := logged(s)[before:]; len(got) != 0 {
+		t.Errorf("read-only EVAL logged %q", got)
+	}
+}
+
+func TestScriptCache(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	body := "return ARGV[1]"
+
+	sha := c.do("SCRIPT", "LOAD", body).Bulk
+	if sha != sha1hex(body) {
+		t.Fatalf("SCRIPT LOAD returned %q, want %q", sha, sha1hex(body))
+	}
+	if reply := c.do("EVALSHA", strings.ToUpper(sha), "0", "x"); reply.Bulk != "x" {
+		t.Errorf("EVALSHA replied %+v", reply)
+	}
+	if got := flatten(c.do("SCRIPT", "EXISTS", sha, sha1hex("other"))); got != "1 0" {
+		t.Errorf("SCRIPT EXISTS = %q, want 1 0", got)
+	}
+
+	c.do("SCRIPT", "FLUSH")
+	if reply := c.do("EVALSHA", sha, "0", "x"); !strings.HasPrefix(reply.Str, "NOSCRIPT") {
+		t.Errorf("EVALSHA after SCRIPT FLUSH replied %+v, want NOSCRIPT", reply)
+	}
+	// EVAL caches what it runs
+	c.do("EVAL", body, "0", "y")
+	if reply := c.do("EVALSHA", sha, "0", "x"); reply.Bulk != "x" {
+		t.Errorf("EVALSHA after EVAL replied %+v", reply)
+	}
+
+	for _, args := range [][]string{
+		{"SCRIPT", "LOAD", "return ("},
+		{"SCRIPT", "FLUSH", "LATER"},
+		{"SCRIPT", "NOSUCH"},
+	} {
+		if reply := c.do(args...); reply.Typ != "error" {
+			t.Errorf("%q replied %+v, want an error", args, reply)
+		}
+	}
+}
+
+func TestScriptKill(t *testing.T) {
+	_, addr := startServer(t)
+	c, other := dial(t, addr), dial(t, addr)
+
+	if reply := other.do("SCRIPT", "KILL"); !strings.HasPrefix(reply.Str, "NOTBUSY") {
+		t.Errorf("SCRIPT KILL with nothing running replied %+v, want NOTBUSY", reply)
+	}
+
+	c.send("EVAL", "while true do end", "0")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		reply := other.do("SCRIPT", "KILL")
+		if reply.Str == "OK" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("SCRIPT KILL replied %+v, want OK", reply)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if reply := c.read(); !strings.Contains(reply.Str, "killed") {
+		t.Errorf("killed EVAL replied %+v", reply)
+	}
+	if reply := c.do("PING"); reply.Str != "PONG" {
+		t.Errorf("PING after the script was killed replied %+v", reply)
+	}
+}
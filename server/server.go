@@ -0,0 +1,319 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IAmRiteshKoushik/bluedis/aof"
+	"github.com/IAmRiteshKoushik/bluedis/cmd"
+	"github.com/IAmRiteshKoushik/bluedis/config"
+	"github.com/IAmRiteshKoushik/bluedis/resp"
+)
+
+const (
+	// How often background housekeeping such as active expiry runs
+	cronInterval = 100 * time.Millisecond
+
+	// Upper bound on the time a single active expire cycle may take
+	activeExpireBudget = 25 * time.Millisecond
+)
+
+// Server accepts client connections and serves each one on its own goroutine.
+// Commands hold execMu shared while they run, so reads from any number of
+// clients go ahead side by side, guarded only by the keyspace lock. Writes also
+// take writeMu, one at a time, so that they reach the AOF and the replicas in
+// the order they took effect in. EXEC and scripts take execMu exclusively, and
+// have the dataset to themselves until they are done; so do AOF rewrites and
+// full syncs with replicas, for as long as they take to snapshot it.
+type Server struct {
+	addr     string
+	cfg      *config.Config
+	aof      *aof.Aof
+	listener net.Listener
+
+	mu      sync.Mutex
+	clients map[int64]*Client
+	nextID  int64
+	closing bool
+	wg      sync.WaitGroup
+	done    chan struct{} // Closed when the server starts shutting down
+
+	// execMu is held shared by every command for as long as it runs and
+	// writes to the AOF. Taking it exclusively therefore waits for the
+	// commands in flight and keeps new ones out, which gives a consistent
+	// point in time to snapshot the dataset at.
+	execMu    sync.RWMutex
+	writeMu   sync.Mutex  // Held by write commands while they run and get logged
+	rewriting atomic.Bool // An AOF rewrite is scheduled or running
+	loading   atomic.Bool // The AOF is being replayed
+	saving    atomic.Bool // A background save is running
+
+	// Clients watching each key, guarded by watchMu, see watch.go
+	watchMu sync.Mutex
+	watched map[string]map[*Client]struct{}
+
+	// Subscribers of each channel, pattern and shard channel, guarded by
+	// pubsubMu, see pubsub.go
+	pubsubMu      sync.Mutex
+	channels      map[string]map[*Client]struct{}
+	patterns      map[string]map[*Client]struct{}
+	shardChannels map[string]map[*Client]struct{}
+
+	// Cached scripts by SHA1 digest, the function libraries and the
+	// functions they register by name, and the script or function running,
+	// guarded by scriptMu, see script.go and function.go
+	scriptMu      sync.Mutex
+	scripts       map[string]string
+	libraries     map[string]*library
+	functions     map[string]*luaFunction
+	runningScript *runningScript
+
+	// Outcome of the snapshots taken so far, guarded by saveMu
+	saveMu             sync.Mutex
+	lastSave           time.Time // Last successful save, or server start
+	lastSaveDirty      int64     // cmd.DB.Dirty() as of lastSave
+	lastBgsaveErr      error
+	lastBgsaveTry      time.Time
+	lastBgsaveDuration time.Duration
+
+	// Replication state, guarded by replMu. replID names the stream of writes
+	// this server takes part in and replOffset is how far into it it is: how
+	// much it has produced as a primary, or applied as a replica. replID2 is
+	// the stream it followed before being promoted, up to secondReplOffset,
+	// so that the other replicas of its old primary can carry on from it.
+	replMu           sync.Mutex
+	replID           string
+	replOffset       int64
+	replID2          string
+	secondReplOffset int64
+	backlog          *backlog
+	replicas         map[int64]*replica // Connected replicas by client ID
+	primary          *primaryLink       // Set while this server is a replica
+	lastReplPing     time.Time
+
+	startTime              time.Time
+	totalConnections       atomic.Int64
+	totalCommandsProcessed atomic.Int64
+}
+
+func New(cfg *config.Config, aof *aof.Aof) *Server {
+	s := &Server{
+		addr:      fmt.Sprintf(":%d", cfg.Snapshot().Port),
+		cfg:       cfg,
+		aof:       aof,
+		clients:   make(map[int64]*Client),
+		done:      make(chan struct{}),
+		startTime: time.Now(),
+		lastSave:  time.Now(),
+		replID:    newReplID(),
+		replID2:   strings.Repeat("0", 40),
+		backlog:   newBacklog(int(cfg.Snapshot().ReplBacklogSize), 0),
+		replicas:  make(map[int64]*replica),
+		watched:   make(map[string]map[*Client]struct{}),
+		channels:  make(map[string]map[*Client]struct{}),
+		patterns:  make(map[string]map[*Client]struct{}),
+
+		shardChannels: make(map[string]map[*Client]struct{}),
+		scripts:       make(map[string]string),
+		libraries:     make(map[string]*library),
+		functions:     make(map[string]*luaFunction),
+
+		secondReplOffset: -1,
+	}
+
+	// Keys that expire are logged as deletions, so that replaying the AOF
+	// does not bring them back and replicas drop them too. Keys found expired
+	// while replaying are already covered by the file being replayed.
+	cmd.DB.OnExpire = func(key string) {
+		s.touchWatched(key)
+		cmd.Notify(cmd.NotifyExpired, "expired", key)
+		if s.loading.Load() {
+			return
+		}
+		s.propagate(resp.Value{Typ: "array", Array: []resp.Value{
+			{Typ: "bulk", Bulk: "DEL"},
+			{Typ: "bulk", Bulk: key},
+		}})
+	}
+
+	cmd.DB.OnTouch = s.touchWatched
+
+	// Keyspace notifications are published on this server only. Replicas
+	// raise their own while applying the writes of their primary.
+	cmd.OnKeyspaceEvent = func(channel, message string) {
+		s.deliver(channel, message)
+	}
+
+	cmd.Register("INFO", cmd.Spec{Arity: -1, Summary: "Returns information and statistics about the server.", Group: "server"}, s.info)
+	cmd.Register("BGREWRITEAOF", cmd.Spec{Arity: 1, Flags: cmd.FlagNoScript, Summary: "Asynchronously rewrites the append-only file to disk.", Group: "server"}, s.bgrewriteaof)
+	cmd.Register("CONFIG", cmd.Spec{Arity: -2, Flags: cmd.FlagNoScript, Summary: "Gets or sets the effective values of configuration parameters.", Group: "server"}, s.config)
+	cmd.Register("SAVE", cmd.Spec{Arity: 1, Flags: cmd.FlagNoScript, Summary: "Synchronously saves the database(s) to disk.", Group: "server"}, s.saveCommand)
+	cmd.Register("BGSAVE", cmd.Spec{Arity: 1, Flags: cmd.FlagNoScript, Summary: "Asynchronously saves the database(s) to disk.", Group: "server"}, s.bgsave)
+	cmd.Register("LASTSAVE", cmd.Spec{Arity: 1, Summary: "Returns the Unix timestamp of the last successful save to disk.", Group: "server"}, s.lastsave)
+	cmd.Register("REPLICAOF", cmd.Spec{Arity: 3, Flags: cmd.FlagNoScript, Summary: "Configures a server as replica of another, or promotes it to a master.", Group: "server"}, s.replicaof)
+	cmd.Register("SLAVEOF", cmd.Spec{Arity: 3, Flags: cmd.FlagNoScript, Summary: "Sets a Redis server as a replica of another, or promotes it to being a master.", Group: "server"}, s.replicaof)
+	cmd.Register("ROLE", cmd.Spec{Arity: 1, Summary: "Returns the replication role.", Group: "server"}, s.role)
+	cmd.Register("PUBLISH", cmd.Spec{Arity: 3, Summary: "Posts a message to a channel.", Group: "pubsub"}, s.publish)
+	cmd.Register("PUBSUB", cmd.Spec{Arity: -2, Summary: "Inspects the state of the Pub/Sub subsystem.", Group: "pubsub"}, s.pubsub)
+	cmd.Register("SPUBLISH", cmd.Spec{Arity: 3, FirstKey: 1, LastKey: 1, KeyStep: 1, Summary: "Post a message to a shard channel", Group: "pubsub"}, s.spublish)
+	cmd.Register("FUNCTION", functionSpec, s.function)
+	cmd.Register("COMMAND", cmd.Spec{Arity: -1, Summary: "Returns detailed information about all commands.", Group: "server"}, commandCommand)
+
+	if err := s.applyConfig(); err != nil {
+		fmt.Println("Error applying config:", err)
+	}
+
+	return s
+}
+
+// ListenAndServe blocks accepting connections until Close is called, at which
+// point it waits for every connected client to finish before returning nil.
+func (s *Server) ListenAndServe() error {
+	l, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		l.Close()
+		return nil
+	}
+	s.listener = l
+	s.mu.Unlock()
+
+	fmt.Println("Listening on", s.addr)
+
+	s.wg.Add(1)
+	go s.cron()
+
+	for {
+		// Accept blocks until a new client connects. Each connection gets its own
+		// goroutine so that a slow or blocked client never holds up the others.
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closing := s.closing
+			s.mu.Unlock()
+			if closing || errors.Is(err, net.ErrClosed) {
+				break
+			}
+			fmt.Println(err)
+			continue
+		}
+
+		client, ok := s.register(conn)
+		if !ok {
+			conn.Close()
+			break
+		}
+
+		go func() {
+			defer s.wg.Done()
+			defer s.unregister(client)
+			client.serve()
+		}()
+	}
+
+	s.wg.Wait()
+	return nil
+}
+
+// Close stops accepting new connections and disconnects every client. Clients
+// parked in a blocking command are woken up so that they can exit as well.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closing = true
+	close(s.done)
+	listener := s.listener
+	clients := make([]*Client, 0, len(s.clients))
+	for _, c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+
+	s.replMu.Lock()
+	if s.primary != nil {
+		s.primary.stop()
+	}
+	s.replMu.Unlock()
+
+	var err error
+	if listener != nil {
+		err = listener.Close()
+	}
+	for _, c := range clients {
+		c.close()
+	}
+	return err
+}
+
+func (s *Server) register(conn net.Conn) (*Client, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closing {
+		return nil, false
+	}
+
+	s.nextID++
+	c := newClient(s, s.nextID, conn)
+	s.clients[c.id] = c
+	s.wg.Add(1)
+	s.totalConnections.Add(1)
+	return c, true
+}
+
+func (s *Server) unregister(c *Client) {
+	s.mu.Lock()
+	delete(s.clients, c.id)
+	s.mu.Unlock()
+
+	s.replMu.Lock()
+	delete(s.replicas, c.id)
+	s.replMu.Unlock()
+
+	c.unwatch()
+	c.unsubscribeAll()
+	c.close()
+}
+
+// cron runs periodic background work until the server shuts down.
+func (s *Server) cron() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(cronInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			// Expired keys are logged to the AOF as they are removed, which
+			// has to happen on the same side of a rewrite snapshot as the
+			// removal itself
+			s.execMu.RLock()
+			cmd.DB.ActiveExpireCycle(activeExpireBudget)
+			s.execMu.RUnlock()
+
+			if s.aof.NeedsRewrite() && !s.rewriting.Load() {
+				fmt.Println("Starting automatic rewriting of AOF")
+				s.startRewrite()
+			}
+
+			s.checkSaveRules()
+			s.pingReplicas()
+		}
+	}
+}
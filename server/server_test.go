@@ -0,0 +1,281 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IAmRiteshKoushik/bluedis/aof"
+	"github.com/IAmRiteshKoushik/bluedis/cmd"
+	"github.com/IAmRiteshKoushik/bluedis/config"
+	"github.com/IAmRiteshKoushik/bluedis/resp"
+	"github.com/IAmRiteshKoushik/bluedis/store"
+)
+
+// startServer runs a server with an empty AOF on a free port until the test
+// ends, and returns it along with the address it listens on.
+func startServer(t *testing.T) (*Server, string) {
+	t.Helper()
+	s := newServer(t, t.TempDir())
+	return s, serve(t, s)
+}
+
+// newServer creates a server whose AOF and snapshot live in dir, without
+// starting it.
+func newServer(t *testing.T, dir string) *Server {
+	t.Helper()
+	aof, err := aof.NewAof(filepath.Join(dir, "appendonlydir"), "database.aof")
+	if err != nil {
+		t.Fatalf("NewAof: %v", err)
+	}
+	t.Cleanup(func() { aof.Close() })
+
+	cfg := config.Default()
+	cfg.Port = 0 // Any free port
+	cfg.DBFilename = filepath.Join(dir, "dump.rdb")
+	return New(cfg, aof)
+}
+
+// serve runs s on a free port until the test ends, and returns the address it
+// listens on.
+func serve(t *testing.T, s *Server) string {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- s.ListenAndServe() }()
+	t.Cleanup(func() {
+		s.Close()
+		<-done
+	})
+
+	for {
+		s.mu.Lock()
+		l := s.listener
+		s.mu.Unlock()
+		if l != nil {
+			return l.Addr().String()
+		}
+		select {
+		case err := <-done:
+			t.Fatalf("ListenAndServe: %v", err)
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+// testClient talks RESP to a server the way redis-cli would. It reports
+// failures with Errorf rather than Fatalf, so that it can be used from
+// goroutines other than the test's own.
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *resp.Resp
+}
+
+func dial(t *testing.T, addr string) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, conn: conn, reader: resp.NewResp(conn)}
+}
+
+// send writes a command without waiting for the reply.
+func (c *testClient) send(args ...string) {
+	c.t.Helper()
+	value := resp.Value{Typ: "array"}
+	for _, arg := range args {
+		value.Array = append(value.Array, resp.Value{Typ: "bulk", Bulk: arg})
+	}
+	if _, err := c.conn.Write(value.Marshal()); err != nil {
+		c.t.Errorf("sending %q: %v", args, err)
+	}
+}
+
+// read waits for the next reply, for up to five seconds.
+func (c *testClient) read() resp.Value {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	value, err := c.reader.Read()
+	if err != nil {
+		c.t.Errorf("reading a reply: %v", err)
+	}
+	return value
+}
+
+// do sends a command and returns its reply.
+func (c *testClient) do(args ...string) resp.Value {
+	c.t.Helper()
+	c.send(args...)
+	return c.read()
+}
+
+func TestConcurrentClients(t *testing.T) {
+	_, addr := startServer(t)
+	const clients, commands = 16, 50
+	list := t.Name() + ":list"
+
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		c := dial(t, addr)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < commands; j++ {
+				key := fmt.Sprintf("%s:%d:%d", t.Name(), i, j)
+				if reply := c.do("SET", key, key); reply.Str != "OK" {
+					t.Errorf("SET %s replied %+v", key, reply)
+					return
+				}
+				if reply := c.do("GET", key); reply.Bulk != key {
+					t.Errorf("GET %s replied %+v", key, reply)
+					return
+				}
+				if reply := c.do("RPUSH", list, key); reply.Typ != "integer" {
+					t.Errorf("RPUSH %s replied %+v", list, reply)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if reply := dial(t, addr).do("LLEN", list); reply.Num != clients*commands {
+		t.Errorf("LLEN after concurrent pushes = %+v, want %d", reply, clients*commands)
+	}
+}
+
+func TestPipelinedCommands(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	key := t.Name()
+
+	// Every command goes out before any reply is read
+	for i := 0; i < 10; i++ {
+		c.send("SET", key, fmt.Sprint(i))
+		c.send("GET", key)
+	}
+	for i := 0; i < 10; i++ {
+		if reply := c.read(); reply.Str != "OK" {
+			t.Fatalf("reply %d to SET = %+v", i, reply)
+		}
+		if reply := c.read(); reply.Bulk != fmt.Sprint(i) {
+			t.Fatalf("reply %d to GET = %+v, want %d", i, reply, i)
+		}
+	}
+}
+
+func TestBlockedClientDoesNotHoldUpOthers(t *testing.T) {
+	_, addr := startServer(t)
+	blocked, other := dial(t, addr), dial(t, addr)
+	list := t.Name()
+
+	blocked.send("BLPOP", list, "0")
+	for i := 0; i < 3; i++ {
+		if reply := other.do("PING"); reply.Str != "PONG" {
+			t.Fatalf("PING while another client is blocked = %+v", reply)
+		}
+	}
+	other.do("RPUSH", list, "item")
+
+	reply := blocked.read()
+	if reply.Typ != "array" || len(reply.Array) != 2 || reply.Array[0].Bulk != list || reply.Array[1].Bulk != "item" {
+		t.Errorf("BLPOP replied %+v, want [%s item]", reply, list)
+	}
+}
+
+func TestBlockingTimeout(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+
+	start := time.Now()
+	if reply := c.do("BLPOP", t.Name(), "1"); reply.Typ != "null" {
+		t.Errorf("BLPOP on an empty list replied %+v, want null", reply)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("BLPOP timed out after %v, want 1s", elapsed)
+	}
+}
+
+func TestCloseWakesBlockedClients(t *testing.T) {
+	s, addr := startServer(t)
+	c := dial(t, addr)
+	c.send("BLPOP", t.Name(), "0")
+	// Give the command the time to reach the server and block
+	time.Sleep(100 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		s.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not return while a client was blocked")
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.reader.Read(); err == nil {
+		t.Errorf("connection still open after Close")
+	}
+}
+
+// A command registered from outside the cmd package is dispatched and, being
+// a write, logged like the built-in ones
+func TestRegisteredCommand(t *testing.T) {
+	cmd.Register("test.touch", cmd.Spec{Arity: 2, Flags: cmd.FlagWrite, FirstKey: 1, LastKey: 1, KeyStep: 1, Summary: "Sets a key to touched.", Group: "test"}, func(args []resp.Value) resp.Value {
+		cmd.DB.Lock()
+		defer cmd.DB.Unlock()
+		cmd.DB.Put(args[0].Bulk, store.TypeString, "touched")
+		return resp.Value{Typ: "string", Str: "OK"}
+	})
+	s, addr := startServer(t)
+	c := dial(t, addr)
+	key := t.Name()
+	defer c.do("DEL", key)
+
+	before := len(logged(s))
+	if reply := c.do("test.touch", key); reply.Str != "OK" {
+		t.Fatalf("TEST.TOUCH replied %+v", reply)
+	}
+	if reply := c.do("GET", key); reply.Bulk != "touched" {
+		t.Errorf("GET after TEST.TOUCH replied %+v", reply)
+	}
+	if got := logged(s)[before:]; len(got) != 1 || got[0] != "TEST.TOUCH "+key {
+		t.Errorf("TEST.TOUCH logged %q", got)
+	}
+}
+
+func TestCommandErrors(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	long := strings.Repeat("x", 200)
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"NOSUCH"}, "ERR unknown command 'NOSUCH', with args beginning with: "},
+		{[]string{"nosuch", "a", "b"}, "ERR unknown command 'nosuch', with args beginning with: 'a' 'b' "},
+		{[]string{"RETRY"}, "ERR unknown command 'RETRY', with args beginning with: "},
+		{[]string{long, long}, "ERR unknown command '" + long[:128] + "', with args beginning with: '" + long[:128] + "' "},
+		{[]string{"GET"}, "ERR wrong number of arguments for 'get' command"},
+		{[]string{"get", "a", "b"}, "ERR wrong number of arguments for 'get' command"},
+		{[]string{"MULTI", "now"}, "ERR wrong number of arguments for 'multi' command"},
+		{[]string{"SUBSCRIBE"}, "ERR wrong number of arguments for 'subscribe' command"},
+	}
+	for _, tt := range tests {
+		if reply := c.do(tt.args...); reply.Typ != "error" || reply.Str != tt.want {
+			t.Errorf("%.20q replied %+v, want %q", tt.args, reply, tt.want)
+		}
+	}
+	if reply := c.do("PING"); reply.Str != "PONG" {
+		t.Errorf("PING after the errors replied %+v", reply)
+	}
+}
@@ -0,0 +1,42 @@
+package server
+
+// Number of hash slots the keyspace is split into, the same as Redis Cluster
+const hashSlots = 16384
+
+// keyHashSlot returns the hash slot of key the way Redis Cluster computes it:
+// CRC16 of the key modulo 16384. When the key contains a hash tag, a non-empty
+// part between the first { and the next }, only the tag is hashed, so that
+// related keys can be made to share a slot.
+func keyHashSlot(key string) int {
+	for i := 0; i < len(key); i++ {
+		if key[i] != '{' {
+			continue
+		}
+		for j := i + 1; j < len(key); j++ {
+			if key[j] == '}' {
+				if j > i+1 {
+					key = key[i+1 : j]
+				}
+				break
+			}
+		}
+		break
+	}
+	return int(crc16(key)) % hashSlots
+}
+
+// crc16 is the CRC16-CCITT (XModem) checksum used by Redis Cluster.
+func crc16(data string) uint16 {
+	var crc uint16
+	for i := 0; i < len(data); i++ {
+		crc ^= uint16(data[i]) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,58 @@
+package server
+
+import "testing"
+
+func TestCRC16(t *testing.T) {
+	// Check values of CRC16-CCITT (XModem), from the Redis Cluster spec
+	tests := []struct {
+		data string
+		want uint16
+	}{
+		{"", 0},
+		{"123456789", 0x31C3},
+		{"A", 0x58E5},
+	}
+	for _, tt := range tests {
+		if got := crc16(tt.data); got != tt.want {
+			t.Errorf("crc16(%q) = %#04x, want %#04x", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestKeyHashSlot(t *testing.T) {
+	// Slots as reported by CLUSTER KEYSLOT
+	tests := []struct {
+		key  string
+		want int
+	}{
+		{"foo", 12182},
+		{"somekey", 11058},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		if got := keyHashSlot(tt.key); got != tt.want {
+			t.Errorf("keyHashSlot(%q) = %d, want %d", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestKeyHashSlotTags(t *testing.T) {
+	tests := []struct {
+		key    string
+		hashed string // What the slot is computed from
+	}{
+		{"{user1000}.following", "user1000"},
+		{"{user1000}.followers", "user1000"},
+		{"foo{bar}{zap}", "bar"},
+		{"foo{{bar}}zap", "{bar"},
+		{"foo{}{bar}", "foo{}{bar}"},
+		{"{}foo", "{}foo"},
+		{"foo{bar", "foo{bar"},
+		{"foo}bar{", "foo}bar{"},
+	}
+	for _, tt := range tests {
+		if got, want := keyHashSlot(tt.key), int(crc16(tt.hashed))%hashSlots; got != want {
+			t.Errorf("keyHashSlot(%q) = %d, want %d, the slot of %q", tt.key, got, want, tt.hashed)
+		}
+	}
+}
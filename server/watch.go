@@ -0,0 +1,111 @@
+package server
+
+import (
+	"github.com/IAmRiteshKoushik/bluedis/cmd"
+	"github.com/IAmRiteshKoushik/bluedis/resp"
+)
+
+// watch implements WATCH. EXEC refuses to run the transaction of a client if
+// any key it watches was modified, deleted or expired after the WATCH, by
+// anyone. Changes are noticed through the keyspace's OnTouch and OnExpire
+// hooks, which call touchWatched.
+func (c *Client) watch(args []resp.Value) (resp.Value, bool) {
+	if c.inMulti {
+		return resp.Value{Typ: "error", Str: "ERR WATCH inside MULTI is not allowed"}, true
+	}
+	if len(args) == 0 {
+		return resp.Value{Typ: "error", Str: "ERR wrong number of arguments for 'watch' command"}, true
+	}
+
+	s := c.server
+	// Whether each key is already past its TTL, without having been removed
+	// yet. Such a key is missing as far as clients can tell, so it expiring
+	// for good later on is not a change.
+	stale := make(map[string]bool, len(args))
+	cmd.DB.RLock()
+	for _, arg := range args {
+		stale[arg.Bulk] = cmd.DB.Expired(arg.Bulk)
+	}
+	cmd.DB.RUnlock()
+
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	if c.watching == nil {
+		c.watching = make(map[string]bool)
+	}
+	for key, expired := range stale {
+		if _, ok := c.watching[key]; ok {
+			continue
+		}
+		c.watching[key] = expired
+		if s.watched[key] == nil {
+			s.watched[key] = make(map[*Client]struct{})
+		}
+		s.watched[key][c] = struct{}{}
+	}
+	return resp.Value{Typ: "string", Str: "OK"}, true
+}
+
+// unwatchCommand implements UNWATCH.
+func (c *Client) unwatchCommand(args []resp.Value) (resp.Value, bool) {
+	c.unwatch()
+	return resp.Value{Typ: "string", Str: "OK"}, true
+}
+
+// unwatch forgets every key watched by the client. EXEC and DISCARD do so
+// whatever the outcome of the transaction, and so does disconnecting.
+func (c *Client) unwatch() {
+	s := c.server
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+
+	for key := range c.watching {
+		delete(s.watched[key], c)
+		if len(s.watched[key]) == 0 {
+			delete(s.watched, key)
+		}
+	}
+	c.watching = nil
+	c.watchTouched = false
+}
+
+// touchWatched flags the clients watching key, or every client watching
+// anything when key is empty.
+func (s *Server) touchWatched(key string) {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+
+	if key != "" {
+		for c := range s.watched[key] {
+			c.watchTouched = true
+		}
+		return
+	}
+	for _, clients := range s.watched {
+		for c := range clients {
+			c.watchTouched = true
+		}
+	}
+}
+
+// watchedKeysChanged reports whether EXEC has to abort because of WATCH. Keys
+// whose TTL passed without the keyspace removing them yet count as expired.
+// The caller holds the exec lock, so nothing can change in the meantime.
+func (c *Client) watchedKeysChanged() bool {
+	s := c.server
+	s.watchMu.Lock()
+	touched, watching := c.watchTouched, c.watching
+	s.watchMu.Unlock()
+	if touched {
+		return true
+	}
+
+	cmd.DB.RLock()
+	defer cmd.DB.RUnlock()
+	for key, stale := range watching {
+		if !stale && cmd.DB.Expired(key) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	_, addr := startServer(t)
+	c, other := dial(t, addr), dial(t, addr)
+	key, result := t.Name()+":watched", t.Name()+":result"
+	defer c.do("DEL", key, result)
+
+	tests := []struct {
+		name   string
+		change func() // Run by another client between WATCH and EXEC
+		aborts bool
+	}{
+		{"unchanged", func() { other.do("GET", key) }, false},
+		{"modified", func() { other.do("SET", key, "w") }, true},
+		{"modified to the same value", func() { other.do("SET", key, "v") }, true},
+		{"deleted", func() { other.do("DEL", key) }, true},
+		{"given a TTL", func() { other.do("EXPIRE", key, "100") }, true},
+		{"expired", func() {
+			other.do("PEXPIRE", key, "1")
+			time.Sleep(10 * time.Millisecond)
+		}, true},
+		{"other key modified", func() { other.do("SET", result, "x") }, false},
+		{"function loaded", func() {
+			other.do("FUNCTION", "LOAD", "#!lua name=watchlib\nredis.register_function('noop', function() end)")
+			other.do("FUNCTION", "DELETE", "watchlib")
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.do("SET", key, "v")
+			c.do("DEL", result)
+
+			if reply := c.do("WATCH", key); reply.Str != "OK" {
+				t.Fatalf("WATCH replied %+v", reply)
+			}
+			tt.change()
+			c.do("MULTI")
+			c.do("SET", result, "done")
+			reply := c.do("EXEC")
+
+			if aborted := reply.Typ == "null"; aborted != tt.aborts {
+				t.Errorf("EXEC replied %q, want aborted %v", flatten(reply), tt.aborts)
+			}
+			if got := other.do("GET", result); (got.Bulk == "done") == tt.aborts {
+				t.Errorf("%s = %q after EXEC", result, flatten(got))
+			}
+		})
+	}
+}
+
+func TestUnwatch(t *testing.T) {
+	_, addr := startServer(t)
+	c, other := dial(t, addr), dial(t, addr)
+	key := t.Name()
+	defer c.do("DEL", key)
+
+	// EXEC forgets the watched keys, whatever the outcome
+	c.do("WATCH", key)
+	other.do("SET", key, "v")
+	c.do("MULTI")
+	if reply := c.do("EXEC"); reply.Typ != "null" {
+		t.Errorf("EXEC replied %+v, want null", reply)
+	}
+	c.do("MULTI")
+	if reply := c.do("EXEC"); reply.Typ != "array" {
+		t.Errorf("second EXEC replied %+v, want it to run", reply)
+	}
+
+	// So do UNWATCH and DISCARD
+	for _, forget := range [][]string{{"UNWATCH"}, {"MULTI", "DISCARD"}} {
+		c.do("WATCH", key)
+		for _, name := range forget {
+			c.do(name)
+		}
+		other.do("SET", key, "w")
+		c.do("MULTI")
+		if reply := c.do("EXEC"); reply.Typ != "array" {
+			t.Errorf("EXEC after %q replied %+v, want it to run", forget, reply)
+		}
+	}
+
+	c.do("MULTI")
+	if reply := c.do("WATCH", key); reply.Typ != "error" {
+		t.Errorf("WATCH inside MULTI replied %+v, want an error", reply)
+	}
+	c.do("DISCARD")
+	if reply := c.do("WATCH"); reply.Typ != "error" {
+		t.Errorf("WATCH without keys replied %+v, want an error", reply)
+	}
+}
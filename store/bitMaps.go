@@ -93,3 +93,36 @@ func (sb *StringBitMap) PopCount(key string) (int, error) {
 	return count, nil
 }
 
+
+// Bytes returns a copy of the raw bit array stored for the given key. Bit n
+// lives in byte n/8 at position n%8, least significant bit first.
+func (sb *StringBitMap) Bytes(key string) []byte {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+
+	data := make([]byte, len(sb.data[key]))
+	copy(data, sb.data[key])
+	return data
+}
+
+// Clone returns an independent copy of the bitmap.
+func (sb *StringBitMap) Clone() *StringBitMap {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+
+	clone := NewStringBitMap()
+	for key, data := range sb.data {
+		clone.data[key] = make([]byte, len(data))
+		copy(clone.data[key], data)
+	}
+	return clone
+}
+
+// NewStringBitMapFromBytes rebuilds a bitmap for the given key from the raw
+// bit array returned by Bytes.
+func NewStringBitMapFromBytes(key string, data []byte) *StringBitMap {
+	sb := NewStringBitMap()
+	sb.data[key] = make([]byte, len(data))
+	copy(sb.data[key], data)
+	return sb
+}
@@ -6,7 +6,6 @@ import (
 	"hash/fnv"
 	"strconv"
 	"sync"
-	"time"
 
 	"github.com/IAmRiteshKoushik/bluedis/resp"
 	"github.com/pierrec/xxHash/xxHash32"
@@ -19,19 +18,25 @@ type BloomFilter struct {
 	mux    sync.RWMutex
 }
 
-// All the hashfunctions will be stored in this map
-var hashFunctions = map[string]hash.Hash32{
-	"murmurhash": murmur3.SeedNew32(uint32(time.Now().Unix())),
-	"fnvhash":    fnv.New32(),
-	"xxhash":     xxHash32.New(uint32(time.Now().Unix())),
+// Seed shared by the seeded hash functions below. It has to stay the same
+// across restarts, because filters are persisted as their raw bits and those
+// bits are only meaningful for the hash functions that set them.
+const hashSeed = uint32(0x5eed1e55)
+
+// All the hashfunctions will be stored in this map. Every call builds a fresh
+// hasher, because a hash.Hash32 carries state between Write and Sum and the
+// filters are used from many client goroutines at once.
+var hashFunctions = map[string]func() hash.Hash32{
+	"murmurhash": func() hash.Hash32 { return murmur3.SeedNew32(hashSeed) },
+	"fnvhash":    func() hash.Hash32 { return fnv.New32() },
+	"xxhash":     func() hash.Hash32 { return xxHash32.New(hashSeed) },
 }
 
 // A common hash function that takes in the hasher and returns the hash
-func getHash(hasher hash.Hash32, data resp.Value) uint32 {
+func getHash(newHasher func() hash.Hash32, data resp.Value) uint32 {
+	hasher := newHasher()
 	hasher.Write([]byte(data.Bulk))
-	hashedSum := hasher.Sum32()
-	hasher.Reset()
-	return hashedSum
+	return hasher.Sum32()
 }
 
 // For each idx given by hash%size, mark that bloom filter bucket as true
@@ -77,6 +82,28 @@ func NewBloomFilter(size int) *BloomFilter {
 	}
 }
 
+// NewBloomFilterFromBytes rebuilds a filter from the raw bits returned by
+// Bytes. The size of the filter is the length of data.
+func NewBloomFilterFromBytes(data []byte) *BloomFilter {
+	bf := NewBloomFilter(len(data))
+	copy(bf.filter, data)
+	return bf
+}
+
+// Bytes returns a copy of the raw bits of the filter.
+func (bf *BloomFilter) Bytes() []byte {
+	bf.mux.RLock()
+	defer bf.mux.RUnlock()
+	data := make([]byte, len(bf.filter))
+	copy(data, bf.filter)
+	return data
+}
+
+// Clone returns an independent copy of the filter.
+func (bf *BloomFilter) Clone() *BloomFilter {
+	return NewBloomFilterFromBytes(bf.Bytes())
+}
+
 func BloomFilterTest() {
 	// Testing to see how the false-positivity rate scales as compared to bloomfilter size
 	for j := 75000; j < 100000; j += 500 {
@@ -0,0 +1,73 @@
+package store
+
+import (
+	"time"
+)
+
+// Tunables of the active expire cycle, modelled after the ones Redis uses
+const (
+	// Number of volatile keys looked at per round
+	activeExpireKeysPerLoop = 20
+
+	// A round that finds at most this percentage of the sampled keys expired
+	// ends the cycle. Anything above it means there are probably many more
+	// stale keys around, so another round is run straight away.
+	activeExpireAcceptableStale = 10
+)
+
+// ActiveExpireCycle removes keys whose TTL has passed without waiting for a
+// command to touch them, so that keys which are never read again do not stay
+// in memory forever.
+//
+// Scanning every volatile key would stall clients on a large keyspace, so the
+// cycle works like the one in Redis: it samples a handful of keys with a TTL,
+// removes the expired ones and repeats as long as a large share of the sample
+// turned out to be expired and the time budget is not used up. The lock is
+// taken per round rather than for the whole cycle so that clients can get in
+// between rounds. Unlike most Keyspace methods, the caller must not hold the
+// lock.
+func (ks *Keyspace) ActiveExpireCycle(budget time.Duration) {
+	start := time.Now()
+
+	for {
+		ks.Lock()
+		sampled, expired := ks.expireSample(activeExpireKeysPerLoop)
+		if sampled > 0 {
+			// Keep a moving estimate of how many volatile keys are stale
+			current := float64(expired) / float64(sampled)
+			ks.stats.ExpiredStalePerc = ks.stats.ExpiredStalePerc*0.95 + current*0.05
+		} else {
+			ks.stats.ExpiredStalePerc = 0
+		}
+		ks.Unlock()
+
+		if sampled == 0 || expired*100 <= sampled*activeExpireAcceptableStale {
+			break
+		}
+		if time.Since(start) > budget {
+			break
+		}
+	}
+
+	ks.Lock()
+	ks.stats.ExpireCycleTime += time.Since(start)
+	ks.Unlock()
+}
+
+// expireSample looks at up to n volatile keys and removes those that have
+// expired. Go randomises where map iteration starts, which is what makes the
+// sample random. The caller must hold the write lock.
+func (ks *Keyspace) expireSample(n int) (sampled, expired int) {
+	now := time.Now()
+	for key, entry := range ks.expires {
+		if sampled == n {
+			break
+		}
+		sampled++
+		if entry.expired(now) {
+			ks.expire(key)
+			expired++
+		}
+	}
+	return sampled, expired
+}
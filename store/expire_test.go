@@ -0,0 +1,98 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// volatileKeyspace returns a keyspace holding the given number of keys that
+// have already expired, keys that expire in an hour, and keys without a TTL.
+func volatileKeyspace(expired, volatile, persistent int) *Keyspace {
+	ks := NewKeyspace()
+	for i := 0; i < expired; i++ {
+		key := fmt.Sprintf("expired:%d", i)
+		ks.Put(key, TypeString, "v")
+		ks.SetExpire(key, time.Now().Add(-time.Second))
+	}
+	for i := 0; i < volatile; i++ {
+		key := fmt.Sprintf("volatile:%d", i)
+		ks.Put(key, TypeString, "v")
+		ks.SetExpire(key, time.Now().Add(time.Hour))
+	}
+	for i := 0; i < persistent; i++ {
+		ks.Put(fmt.Sprintf("persistent:%d", i), TypeString, "v")
+	}
+	return ks
+}
+
+func TestActiveExpireCycle(t *testing.T) {
+	tests := []struct {
+		name                          string
+		expired, volatile, persistent int
+	}{
+		{"nothing volatile", 0, 0, 10},
+		{"nothing expired", 0, 50, 10},
+		{"all expired", 500, 0, 0},
+		{"mixed", 500, 5, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ks := volatileKeyspace(tt.expired, tt.volatile, tt.persistent)
+			var notified int
+			ks.OnExpire = func(key string) { notified++ }
+
+			ks.ActiveExpireCycle(time.Second)
+
+			if got, want := ks.Len(), tt.volatile+tt.persistent; got != want {
+				t.Errorf("%d keys left, want %d", got, want)
+			}
+			if got := ks.VolatileLen(); got != tt.volatile {
+				t.Errorf("%d volatile keys left, want %d", got, tt.volatile)
+			}
+			if got := ks.Stats().ExpiredKeys; got != int64(tt.expired) {
+				t.Errorf("ExpiredKeys = %d, want %d", got, tt.expired)
+			}
+			if notified != tt.expired {
+				t.Errorf("OnExpire called %d times, want %d", notified, tt.expired)
+			}
+		})
+	}
+}
+
+func TestActiveExpireCycleBudget(t *testing.T) {
+	ks := volatileKeyspace(500, 0, 0)
+	// With no time to spare the cycle stops after its first round
+	ks.ActiveExpireCycle(0)
+	if got, want := ks.Len(), 500-activeExpireKeysPerLoop; got != want {
+		t.Errorf("%d keys left after one round, want %d", got, want)
+	}
+	if ks.Stats().ExpiredStalePerc <= 0 {
+		t.Errorf("ExpiredStalePerc = %v after sampling only expired keys", ks.Stats().ExpiredStalePerc)
+	}
+}
+
+func TestVolatileTracking(t *testing.T) {
+	ks := NewKeyspace()
+	ks.Put("k", TypeString, "v")
+	steps := []struct {
+		name string
+		do   func()
+		want int
+	}{
+		{"SetExpire", func() { ks.SetExpire("k", time.Now().Add(time.Hour)) }, 1},
+		{"Persist", func() { ks.Persist("k") }, 0},
+		{"SetExpire again", func() { ks.SetExpire("k", time.Now().Add(time.Hour)) }, 1},
+		{"SetExpire to zero", func() { ks.SetExpire("k", time.Time{}) }, 0},
+		{"SetExpire before Put", func() { ks.SetExpire("k", time.Now().Add(time.Hour)) }, 1},
+		{"Put", func() { ks.Put("k", TypeString, "w") }, 0},
+		{"SetExpire before Remove", func() { ks.SetExpire("k", time.Now().Add(time.Hour)) }, 1},
+		{"Remove", func() { ks.Remove("k") }, 0},
+	}
+	for _, step := range steps {
+		step.do()
+		if got := ks.VolatileLen(); got != step.want {
+			t.Errorf("after %s, %d volatile keys, want %d", step.name, got, step.want)
+		}
+	}
+}
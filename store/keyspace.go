@@ -0,0 +1,311 @@
+package store
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Names of the value types a key can hold, as reported by the TYPE command
+const (
+	TypeString = "string"
+	TypeHash   = "hash"
+	TypeList   = "list"
+	TypeZSet   = "zset"
+	TypeBitMap = "bitmap"
+	TypeBloom  = "MBbloom--"
+)
+
+// ErrWrongType is returned when a command expects a key to hold one type of
+// value but it holds another. The message is sent to clients as is.
+var ErrWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// Entry is a single value in the keyspace tagged with its type. Value holds
+// the type specific structure, e.g. a *DoublyLinkedList for TypeList.
+type Entry struct {
+	Type     string
+	Value    interface{}
+	expireAt time.Time // Zero when the key does not expire
+}
+
+// ExpireAt returns the time the entry expires at, or the zero time if it has
+// no TTL. TTLs are changed through the keyspace (SetExpire, Persist) so that
+// it can keep track of which keys are volatile.
+func (e *Entry) ExpireAt() time.Time {
+	return e.expireAt
+}
+
+// HasExpiry reports whether the entry has a TTL attached.
+func (e *Entry) HasExpiry() bool {
+	return !e.expireAt.IsZero()
+}
+
+func (e *Entry) expired(now time.Time) bool {
+	return e.HasExpiry() && now.After(e.expireAt)
+}
+
+// Keyspace owns every key of the database regardless of its type, so that a
+// key name can only ever refer to one value at a time.
+//
+// The embedded RWMutex guards the key to entry mapping as well as values that
+// do not carry a lock of their own (strings and hashes). All other methods
+// expect the caller to hold it, which lets a command look a key up, check its
+// type and modify it as one atomic step.
+//
+// Keys whose TTL has passed are treated as missing by every lookup, even
+// before they are physically removed. Lookups never remove anything so that
+// they are safe under the read lock; ExpireIfNeeded does that part.
+type Keyspace struct {
+	sync.RWMutex
+	entries map[string]*Entry
+	expires map[string]*Entry // The subset of entries that have a TTL
+	stats   Stats
+
+	// Code of the function libraries by name, see libraries.go
+	libraries map[string]string
+
+	// Number of changes made to the keyspace so far. Keys removed because
+	// their TTL passed are not counted, see ExpiredKeys instead.
+	dirty atomic.Int64
+
+	// OnExpire, when set, is called for every key removed because its TTL
+	// passed, whether it was noticed by a command or by the active expire
+	// cycle. It runs with the lock held and must not call back into the
+	// keyspace.
+	OnExpire func(key string)
+
+	// OnTouch, when set, is called for every change recorded with Touch. An
+	// empty key means that every key may have changed. Changes to function
+	// libraries are not reported, see libraries.go. Like OnExpire it runs
+	// with the lock held and must not call back into the keyspace.
+	OnTouch func(key string)
+}
+
+// Stats are counters about the keyspace exposed through INFO.
+type Stats struct {
+	ExpiredKeys      int64         // Keys removed because their TTL passed
+	ExpiredStalePerc float64       // Estimated share of volatile keys that are already expired
+	ExpireCycleTime  time.Duration // Total time spent in the active expire cycle
+}
+
+func NewKeyspace() *Keyspace {
+	return &Keyspace{
+		entries:   make(map[string]*Entry),
+		expires:   make(map[string]*Entry),
+		libraries: make(map[string]string),
+	}
+}
+
+// Lookup returns the entry stored at key, if any.
+func (ks *Keyspace) Lookup(key string) (*Entry, bool) {
+	entry, ok := ks.entries[key]
+	if !ok || entry.expired(time.Now()) {
+		return nil, false
+	}
+	return entry, true
+}
+
+// LookupType returns the entry stored at key after checking that it holds a
+// value of type typ. A missing key is not an error and yields a nil entry.
+func (ks *Keyspace) LookupType(key, typ string) (*Entry, error) {
+	entry, ok := ks.Lookup(key)
+	if !ok {
+		return nil, nil
+	}
+	if entry.Type != typ {
+		return nil, ErrWrongType
+	}
+	return entry, nil
+}
+
+// Put stores value under key, replacing whatever the key held before along
+// with its TTL.
+func (ks *Keyspace) Put(key, typ string, value interface{}) *Entry {
+	entry := &Entry{Type: typ, Value: value}
+	ks.entries[key] = entry
+	delete(ks.expires, key)
+	ks.Touch(key)
+	return entry
+}
+
+// Remove deletes key and reports whether it existed. An expired key is
+// removed as well but does not count as existing.
+func (ks *Keyspace) Remove(key string) bool {
+	entry, ok := ks.entries[key]
+	if !ok {
+		return false
+	}
+	delete(ks.entries, key)
+	delete(ks.expires, key)
+	if entry.expired(time.Now()) {
+		return false
+	}
+	ks.Touch(key)
+	return true
+}
+
+// SetExpire makes key expire at the given time. A zero time removes the TTL.
+// It reports false if the key does not exist.
+func (ks *Keyspace) SetExpire(key string, at time.Time) bool {
+	entry, ok := ks.Lookup(key)
+	if !ok {
+		return false
+	}
+	entry.expireAt = at
+	if at.IsZero() {
+		delete(ks.expires, key)
+	} else {
+		ks.expires[key] = entry
+	}
+	ks.Touch(key)
+	return true
+}
+
+// Persist removes the TTL of key. It reports whether there was one to remove.
+func (ks *Keyspace) Persist(key string) bool {
+	entry, ok := ks.Lookup(key)
+	if !ok || !entry.HasExpiry() {
+		return false
+	}
+	entry.expireAt = time.Time{}
+	delete(ks.expires, key)
+	ks.Touch(key)
+	return true
+}
+
+// Touch records that the value stored at key was modified. Put, Remove and the
+// TTL methods do so on their own; commands that change a value they looked up,
+// such as pushing to a list, have to call it themselves.
+func (ks *Keyspace) Touch(key string) {
+	ks.dirty.Add(1)
+	if ks.OnTouch != nil {
+		ks.OnTouch(key)
+	}
+}
+
+// Dirty returns the number of changes made to the keyspace so far. Comparing
+// it before and after a command tells whether the command changed anything.
+// It can be called without holding the lock.
+func (ks *Keyspace) Dirty() int64 {
+	return ks.dirty.Load()
+}
+
+// Replace swaps every key and function library of ks for those of other,
+// which must not be used afterwards. It counts as a single change. A replica
+// uses it to switch over to the dataset its primary sent.
+func (ks *Keyspace) Replace(other *Keyspace) {
+	ks.entries = other.entries
+	ks.expires = other.expires
+	ks.libraries = other.libraries
+	ks.Touch("")
+}
+
+// Expired reports whether key is still stored even though its TTL has passed.
+func (ks *Keyspace) Expired(key string) bool {
+	entry, ok := ks.entries[key]
+	return ok && entry.expired(time.Now())
+}
+
+// ExpireIfNeeded removes key if its TTL has passed and reports whether it did.
+// Unlike the lookups it needs the write lock.
+func (ks *Keyspace) ExpireIfNeeded(key string) bool {
+	if !ks.Expired(key) {
+		return false
+	}
+	ks.expire(key)
+	return true
+}
+
+// expire removes a key whose TTL has passed and lets OnExpire know about it.
+func (ks *Keyspace) expire(key string) {
+	delete(ks.entries, key)
+	delete(ks.expires, key)
+	ks.stats.ExpiredKeys++
+	if ks.OnExpire != nil {
+		ks.OnExpire(key)
+	}
+}
+
+// Len returns the number of keys in the keyspace, including expired keys that
+// have not been removed yet.
+func (ks *Keyspace) Len() int {
+	return len(ks.entries)
+}
+
+// VolatileLen returns the number of keys that have a TTL attached.
+func (ks *Keyspace) VolatileLen() int {
+	return len(ks.expires)
+}
+
+// Stats returns a copy of the keyspace counters.
+func (ks *Keyspace) Stats() Stats {
+	return ks.stats
+}
+
+// Keys returns the names of all live keys in no particular order.
+func (ks *Keyspace) Keys() []string {
+	now := time.Now()
+	keys := make([]string, 0, len(ks.entries))
+	for key, entry := range ks.entries {
+		if !entry.expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// ForEach calls fn for every live key until fn returns an error, which is then
+// returned.
+func (ks *Keyspace) ForEach(fn func(key string, entry *Entry) error) error {
+	now := time.Now()
+	for key, entry := range ks.entries {
+		if entry.expired(now) {
+			continue
+		}
+		if err := fn(key, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Clone returns a deep copy of every live key along with its TTL, and of the
+// function libraries. The copy
+// shares nothing with the original, so it can be serialised at leisure (for an
+// AOF rewrite, say) while clients keep modifying the original. The read lock
+// is enough to take it.
+func (ks *Keyspace) Clone() *Keyspace {
+	clone := NewKeyspace()
+	for name, code := range ks.libraries {
+		clone.libraries[name] = code
+	}
+	ks.ForEach(func(key string, entry *Entry) error {
+		copied := &Entry{Type: entry.Type, expireAt: entry.expireAt}
+		switch value := entry.Value.(type) {
+		case map[string]string:
+			hash := make(map[string]string, len(value))
+			for field, v := range value {
+				hash[field] = v
+			}
+			copied.Value = hash
+		case *DoublyLinkedList:
+			copied.Value = value.Clone()
+		case *SortedSet[string, int64, string]:
+			copied.Value = value.Clone()
+		case *StringBitMap:
+			copied.Value = value.Clone()
+		case *BloomFilter:
+			copied.Value = value.Clone()
+		default:
+			// Strings are immutable and can be shared
+			copied.Value = value
+		}
+		clone.entries[key] = copied
+		if copied.HasExpiry() {
+			clone.expires[key] = copied
+		}
+		return nil
+	})
+	return clone
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLibrariesDirty(t *testing.T) {
+	ks := NewKeyspace()
+	ks.OnTouch = func(key string) { t.Errorf("OnTouch(%q) called for a library", key) }
+
+	before := ks.Dirty()
+	ks.SetLibrary("lib", "code")
+	if ks.Dirty() == before {
+		t.Error("SetLibrary did not change the dirty count")
+	}
+	if code, ok := ks.Library("lib"); !ok || code != "code" {
+		t.Errorf("Library = %q, %v after SetLibrary", code, ok)
+	}
+
+	before = ks.Dirty()
+	if !ks.RemoveLibrary("lib") || ks.Dirty() == before {
+		t.Error("RemoveLibrary did not remove the library as a change")
+	}
+	before = ks.Dirty()
+	if ks.RemoveLibrary("lib") || ks.Dirty() != before {
+		t.Error("RemoveLibrary of a missing library counted as a change")
+	}
+}
+
+func TestDirty(t *testing.T) {
+	ks := NewKeyspace()
+	var touched []string
+	ks.OnTouch = func(key string) { touched = append(touched, key) }
+	steps := []struct {
+		name    string
+		do      func()
+		changes bool
+	}{
+		{"Put", func() { ks.Put("k", TypeString, "v") }, true},
+		{"Lookup", func() { ks.Lookup("k") }, false},
+		{"SetExpire", func() { ks.SetExpire("k", time.Now().Add(time.Hour)) }, true},
+		{"SetExpire on a missing key", func() { ks.SetExpire("missing", time.Now().Add(time.Hour)) }, false},
+		{"Persist", func() { ks.Persist("k") }, true},
+		{"Persist without a TTL", func() { ks.Persist("k") }, false},
+		{"Touch", func() { ks.Touch("k") }, true},
+		{"Remove", func() { ks.Remove("k") }, true},
+		{"Remove a missing key", func() { ks.Remove("k") }, false},
+	}
+	for _, step := range steps {
+		before := ks.Dirty()
+		touched = nil
+		step.do()
+		if changed := ks.Dirty() != before; changed != step.changes {
+			t.Errorf("%s changed the dirty count: %v, want %v", step.name, changed, step.changes)
+		}
+		// Every change is reported to OnTouch as well
+		if int64(len(touched)) != ks.Dirty()-before {
+			t.Errorf("%s called OnTouch %d times for %d changes", step.name, len(touched), ks.Dirty()-before)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package store
+
+// Function libraries are not keys, but they are kept along with them so that
+// they get saved, cloned and replaced with the rest of the dataset: snapshots,
+// AOF rewrites and the dataset a replica gets from its primary all carry them.
+// Only their code is stored here; what it defines is up to the scripting
+// engine. Like everything else in the keyspace, they are guarded by its lock.
+//
+// Changing a library counts as a change to the keyspace, so that it gets
+// logged and saved, but it does not go through Touch: there is no key to
+// report to OnTouch, and the empty key would mean that every key may have
+// changed, aborting every transaction with a WATCH. Loading a function does
+// not do that in Redis either.
+
+// Library returns the code of the library called name, if there is one.
+func (ks *Keyspace) Library(name string) (string, bool) {
+	code, ok := ks.libraries[name]
+	return code, ok
+}
+
+// Libraries returns the code of every library by name. The map is a copy.
+func (ks *Keyspace) Libraries() map[string]string {
+	libraries := make(map[string]string, len(ks.libraries))
+	for name, code := range ks.libraries {
+		libraries[name] = code
+	}
+	return libraries
+}
+
+// SetLibrary stores the code of the library called name, replacing the one
+// that had the name before.
+func (ks *Keyspace) SetLibrary(name, code string) {
+	ks.libraries[name] = code
+	ks.dirty.Add(1)
+}
+
+// RemoveLibrary deletes the library called name and reports whether it
+// existed.
+func (ks *Keyspace) RemoveLibrary(name string) bool {
+	if _, ok := ks.libraries[name]; !ok {
+		return false
+	}
+	delete(ks.libraries, name)
+	ks.dirty.Add(1)
+	return true
+}
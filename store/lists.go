@@ -156,3 +156,24 @@ func (dll *DoublyLinkedList) ExtractRange(start, end int) []interface{} {
 	}
 	return result
 }
+
+// Values returns every element of the list from head to tail.
+func (dll *DoublyLinkedList) Values() []interface{} {
+	dll.mu.Lock()
+	defer dll.mu.Unlock()
+
+	values := make([]interface{}, 0, dll.length)
+	for current := dll.head; current != nil; current = current.next {
+		values = append(values, current.value)
+	}
+	return values
+}
+
+// Clone returns an independent copy of the list.
+func (dll *DoublyLinkedList) Clone() *DoublyLinkedList {
+	clone := NewDoublyLinkedList()
+	for _, value := range dll.Values() {
+		clone.PushRight(value)
+	}
+	return clone
+}
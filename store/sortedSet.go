@@ -284,4 +284,23 @@ func (this *SortedSet[K, SCORE, V]) findNodeByRank(start int, remove bool) (trav
         }
     }
     return
-}
\ No newline at end of file
+}
+// Nodes returns every member of the set in ascending score order.
+func (this *SortedSet[K, SCORE, V]) Nodes() []*SortedSetNode[K, SCORE, V] {
+    this.Mu.Lock()
+    defer this.Mu.Unlock()
+    nodes := make([]*SortedSetNode[K, SCORE, V], 0, this.Length)
+    for x := this.Header.Level[0].Forward; x != nil; x = x.Level[0].Forward {
+        nodes = append(nodes, x)
+    }
+    return nodes
+}
+
+// Clone returns an independent copy of the set.
+func (this *SortedSet[K, SCORE, V]) Clone() *SortedSet[K, SCORE, V] {
+    clone := NewSortedSet[K, SCORE, V]()
+    for _, node := range this.Nodes() {
+        clone.AddOrUpdate(node.Key, node.Score, node.Value)
+    }
+    return clone
+}